@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/bnb-chain/zkbnb-setup/keys"
 	"github.com/bnb-chain/zkbnb-setup/phase1"
@@ -29,7 +34,7 @@ func p1t(cCtx *cli.Context) error {
 	if inPower < outPower {
 		return errors.New("cannot transform to a higher power")
 	}
-	err = phase1.Transform(inputPath, outputPath, byte(inPower), byte(outPower))
+	err = phase1.Transform(inputPath, outputPath, cCtx.String("ceremony"), byte(inPower), byte(outPower))
 	return err
 }
 
@@ -47,7 +52,7 @@ func p1n(cCtx *cli.Context) error {
 		return errors.New("can't support powers larger than 26")
 	}
 	outputPath := cCtx.Args().Get(1)
-	err = phase1.Initialize(byte(power), outputPath)
+	err = phase1.Initialize(byte(power), cCtx.String("ceremony"), outputPath)
 	return err
 }
 
@@ -58,7 +63,8 @@ func p1c(cCtx *cli.Context) error {
 	}
 	inputPath := cCtx.Args().Get(0)
 	outputPath := cCtx.Args().Get(1)
-	err := phase1.Contribute(inputPath, outputPath)
+	opts := phase1.ContributeOptions{ParallelSections: cCtx.Bool("parallel")}
+	err := phase1.Contribute(inputPath, outputPath, opts)
 	return err
 }
 
@@ -68,6 +74,9 @@ func p1v(cCtx *cli.Context) error {
 		return errors.New("please provide the correct arguments")
 	}
 	inputPath := cCtx.Args().Get(0)
+	if cCtx.Bool("legacy") {
+		return phase1.VerifyLegacy(inputPath, "")
+	}
 	err := phase1.Verify(inputPath, "")
 	return err
 }
@@ -78,11 +87,37 @@ func p1vt(cCtx *cli.Context) error {
 		return errors.New("please provide the correct arguments")
 	}
 	inputPath := cCtx.Args().Get(0)
-	transformedPath :=cCtx.Args().Get(1)
+	transformedPath := cCtx.Args().Get(1)
+	if cCtx.Bool("legacy") {
+		return phase1.VerifyLegacy(inputPath, transformedPath)
+	}
 	err := phase1.Verify(inputPath, transformedPath)
 	return err
 }
 
+func p1prepare(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	phase1Path := cCtx.Args().Get(0)
+	outputDir := cCtx.Args().Get(1)
+	opts := phase2.Options{MemoryBudget: cCtx.Int64("memory") << 20}
+	err := phase2.Prepare(phase1Path, outputDir, opts)
+	return err
+}
+
+func p1vprepare(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	phase1Path := cCtx.Args().Get(0)
+	preparedPath := cCtx.Args().Get(1)
+	err := phase2.VerifyPrepared(phase1Path, preparedPath)
+	return err
+}
+
 func p2n(cCtx *cli.Context) error {
 	// sanity check
 	if cCtx.Args().Len() != 3 {
@@ -92,57 +127,147 @@ func p2n(cCtx *cli.Context) error {
 	phase1Path := cCtx.Args().Get(0)
 	r1csPath := cCtx.Args().Get(1)
 	phase2Path := cCtx.Args().Get(2)
-	err := phase2.Initialize(phase1Path, r1csPath, phase2Path)
+	opts := phase2.Options{
+		MemoryBudget: cCtx.Int64("memory") << 20,
+		PreparedDir:  cCtx.String("prepared"),
+	}
+	err := phase2.Initialize(phase1Path, r1csPath, phase2Path, cCtx.String("commitments"), opts)
 	return err
 }
 
 func p2np(cCtx *cli.Context) error {
 	// sanity check
-	if cCtx.Args().Len() != 6 {
+	if cCtx.Args().Len() != 3 {
 		return errors.New("please provide the correct arguments")
 	}
 
 	phase1Path := cCtx.Args().Get(0)
-	r1csPath := cCtx.Args().Get(1)
+	session := cCtx.Args().Get(1)
 	phase2Path := cCtx.Args().Get(2)
-	nbCons, err := strconv.Atoi(cCtx.Args().Get(3))
+	opts := phase2.Options{
+		MemoryBudget: cCtx.Int64("memory") << 20,
+		PreparedDir:  cCtx.String("prepared"),
+	}
+	err := phase2.InitializeFromPartedR1CS(phase1Path, session, phase2Path, opts)
+	return err
+}
+
+func p2c(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	outputPath := cCtx.Args().Get(1)
+	if !cCtx.Bool("yes") {
+		if err := confirmCircuits(inputPath); err != nil {
+			return err
+		}
+	}
+	isBundle, err := phase2.IsBundle(inputPath)
 	if err != nil {
 		return err
 	}
+	if isBundle {
+		return phase2.ContributeBundle(inputPath, outputPath)
+	}
+	err = phase2.Contribute(inputPath, outputPath)
+	return err
+}
 
-	nbR1C, err := strconv.Atoi(cCtx.Args().Get(4))
+// confirmCircuits displays the circuits of the phase 2 file and asks the contributor to confirm them
+func confirmCircuits(inputPath string) error {
+	labels, err := phase2.Labels(inputPath)
 	if err != nil {
 		return err
 	}
+	fmt.Println("You are about to contribute to:")
+	for _, label := range labels {
+		if label == "" {
+			label = "<unlabelled>"
+		}
+		fmt.Printf("  - %s\n", label)
+	}
+	fmt.Print("Do you confirm? [y/N]: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		return errors.New("contribution has been aborted")
+	}
+	return nil
+}
+
+func p2v(cCtx *cli.Context) error {
+	// verify the last contribution only against the previous state
+	if prevPath := cCtx.String("prev"); prevPath != "" {
+		if cCtx.Args().Len() != 1 {
+			return errors.New("please provide the correct arguments")
+		}
+		nextPath := cCtx.Args().Get(0)
+		isBundle, err := phase2.IsBundle(nextPath)
+		if err != nil {
+			return err
+		}
+		if isBundle {
+			return phase2.VerifyBundleTransition(prevPath, nextPath)
+		}
+		return phase2.VerifyTransition(prevPath, nextPath)
+	}
 
-	batchSize, err := strconv.Atoi(cCtx.Args().Get(5))
+	// sanity check
+	if cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	originPath := cCtx.Args().Get(1)
+	isBundle, err := phase2.IsBundle(inputPath)
 	if err != nil {
 		return err
 	}
+	if isBundle {
+		return phase2.VerifyBundle(inputPath, originPath)
+	}
+	err = phase2.Verify(inputPath, originPath)
+	return err
+}
 
-	err = phase2.InitializeFromPartedR1CS(phase1Path, r1csPath, phase2Path, nbCons, nbR1C, batchSize)
+func p2b(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() < 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	bundlePath := cCtx.Args().Get(0)
+	phase2Paths := cCtx.Args().Slice()[1:]
+	err := phase2.NewBundle(bundlePath, phase2Paths)
 	return err
 }
 
-func p2c(cCtx *cli.Context) error {
+func p2u(cCtx *cli.Context) error {
 	// sanity check
 	if cCtx.Args().Len() != 2 {
 		return errors.New("please provide the correct arguments")
 	}
-	inputPath := cCtx.Args().Get(0)
-	outputPath := cCtx.Args().Get(1)
-	err := phase2.Contribute(inputPath, outputPath)
+	bundlePath := cCtx.Args().Get(0)
+	outputDir := cCtx.Args().Get(1)
+	err := phase2.ExtractBundle(bundlePath, outputDir)
 	return err
 }
 
-func p2v(cCtx *cli.Context) error {
+func p2audit(cCtx *cli.Context) error {
 	// sanity check
-	if cCtx.Args().Len() != 2 {
+	if cCtx.Args().Len() != 4 && cCtx.Args().Len() != 5 {
 		return errors.New("please provide the correct arguments")
 	}
-	inputPath := cCtx.Args().Get(0)
-	originPath := cCtx.Args().Get(1)
-	err := phase2.Verify(inputPath, originPath)
+	phase1Path := cCtx.Args().Get(0)
+	r1csPath := cCtx.Args().Get(1)
+	phase2Path := cCtx.Args().Get(2)
+	evalsPath := cCtx.Args().Get(3)
+	lagPath := cCtx.Args().Get(4)
+	opts := phase2.Options{MemoryBudget: cCtx.Int64("memory") << 20}
+	err := phase2.Audit(phase1Path, r1csPath, phase2Path, evalsPath, lagPath, cCtx.String("commitments"), opts)
 	return err
 }
 
@@ -152,8 +277,14 @@ func extract(cCtx *cli.Context) error {
 		return errors.New("please provide the correct arguments")
 	}
 	inputPath := cCtx.Args().Get(0)
-	err := keys.ExtractKeys(inputPath)
-	return err
+	format := cCtx.String("format")
+	if err := keys.ExtractKeys(inputPath, format); err != nil {
+		return err
+	}
+	if jsonPath := cCtx.String("snarkjs"); jsonPath != "" {
+		return keys.ExportSnarkJS("vk", jsonPath, format)
+	}
+	return nil
 }
 
 func extracts(cCtx *cli.Context) error {
@@ -163,16 +294,169 @@ func extracts(cCtx *cli.Context) error {
 	}
 	inputPath := cCtx.Args().Get(0)
 	session := cCtx.Args().Get(1)
-	err := keys.ExtractSplitKeys(inputPath, session)
+	format := cCtx.String("format")
+	if err := keys.ExtractSplitKeys(inputPath, session, format); err != nil {
+		return err
+	}
+	if jsonPath := cCtx.String("snarkjs"); jsonPath != "" {
+		vkPath := session + ".vk.save"
+		if format != keys.FormatFork {
+			vkPath = session + ".vk"
+		}
+		return keys.ExportSnarkJS(vkPath, jsonPath, format)
+	}
+	return nil
+}
+
+func verifyKeys(cCtx *cli.Context) error {
+	// split keys
+	if session := cCtx.String("session"); session != "" {
+		if cCtx.Args().Len() > 2 {
+			return errors.New("please provide the correct arguments")
+		}
+		phase2Path := cCtx.Args().Get(0)
+		evalsPath := cCtx.Args().Get(1)
+		return keys.VerifySplitKeys(session, phase2Path, evalsPath)
+	}
+
+	// sanity check
+	if cCtx.Args().Len() < 2 || cCtx.Args().Len() > 4 {
+		return errors.New("please provide the correct arguments")
+	}
+	pkPath := cCtx.Args().Get(0)
+	vkPath := cCtx.Args().Get(1)
+	phase2Path := cCtx.Args().Get(2)
+	evalsPath := cCtx.Args().Get(3)
+	err := keys.VerifyKeys(pkPath, vkPath, phase2Path, evalsPath)
+	return err
+}
+
+func exportZKey(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 3 {
+		return errors.New("please provide the correct arguments")
+	}
+	phase2Path := cCtx.Args().Get(0)
+	r1csPath := cCtx.Args().Get(1)
+	zkeyPath := cCtx.Args().Get(2)
+	err := keys.ExportZKey(phase2Path, cCtx.String("evals"), r1csPath, zkeyPath)
 	return err
 }
 
+func exportGo(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 1 && cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	outputPath := cCtx.Args().Get(1)
+	opts := keys.GoOptions{
+		Package:     cCtx.String("package"),
+		EvalsPath:   cCtx.String("evals"),
+		ProofPath:   cCtx.String("proof"),
+		WitnessPath: cCtx.String("witness"),
+	}
+	// Split keys are given by their session
+	if _, err := os.Stat(inputPath); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(inputPath + ".vk.save"); err == nil {
+			inputPath += ".vk.save"
+		}
+	}
+	if outputPath == "" {
+		outputPath = opts.Package + ".go"
+	}
+	return keys.ExportGo(inputPath, outputPath, opts)
+}
+
 func exportSol(cCtx *cli.Context) error {
+	opts := keys.SolOptions{
+		Name:        cCtx.String("name"),
+		Pragma:      cCtx.String("pragma"),
+		LinkLibrary: cCtx.Bool("link"),
+		EvalsPath:   cCtx.String("evals"),
+		TestPath:    cCtx.String("foundry"),
+		ProofPath:   cCtx.String("proof"),
+		WitnessPath: cCtx.String("witness"),
+	}
+	if cCtx.Bool("multi") {
+		return exportMultiSol(cCtx, opts)
+	}
+
 	// sanity check
-	if cCtx.Args().Len() != 1 {
+	if cCtx.Args().Len() != 1 && cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	outputPath := cCtx.Args().Get(1)
+	// The input used to be the session of split keys
+	if _, err := os.Stat(inputPath); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(inputPath + ".vk.save"); err == nil {
+			if outputPath == "" {
+				outputPath = inputPath + ".sol"
+			}
+			inputPath += ".vk.save"
+		}
+	}
+	if outputPath == "" {
+		outputPath = strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".sol"
+	}
+	return keys.ExportSol(inputPath, outputPath, opts)
+}
+
+// exportMultiSol exports one contract for the circuits given as <id>=<vkPath|session> after the output path
+func exportMultiSol(cCtx *cli.Context, opts keys.SolOptions) error {
+	// sanity check
+	if cCtx.Args().Len() < 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	outputPath := cCtx.Args().Get(0)
+	var circuits []keys.SolCircuit
+	for _, arg := range cCtx.Args().Slice()[1:] {
+		idStr, vkPath, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("circuit %s isn't given as <id>=<vkPath>", arg)
+		}
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid circuit ID %s: %w", idStr, err)
+		}
+		if _, err := os.Stat(vkPath); errors.Is(err, os.ErrNotExist) {
+			if _, err := os.Stat(vkPath + ".vk.save"); err == nil {
+				vkPath += ".vk.save"
+			}
+		}
+		circuits = append(circuits, keys.SolCircuit{ID: id, VKPath: vkPath})
+	}
+	return keys.ExportMultiSol(circuits, outputPath, opts)
+}
+
+func prove(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 4 && cCtx.Args().Len() != 5 {
+		return errors.New("please provide the correct arguments")
+	}
+	r1csPath := cCtx.Args().Get(0)
+	pkPath := cCtx.Args().Get(1)
+	vkPath := cCtx.Args().Get(2)
+	witnessPath := cCtx.Args().Get(3)
+	outputDir := cCtx.Args().Get(4)
+	if outputDir == "" {
+		outputDir = "."
+	}
+	return keys.Prove(r1csPath, pkPath, vkPath, witnessPath, outputDir)
+}
+
+func provep(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 3 && cCtx.Args().Len() != 4 {
 		return errors.New("please provide the correct arguments")
 	}
 	session := cCtx.Args().Get(0)
-	err := keys.ExportSol(session)
-	return err
+	vkPath := cCtx.Args().Get(1)
+	witnessPath := cCtx.Args().Get(2)
+	outputDir := cCtx.Args().Get(3)
+	if outputDir == "" {
+		outputDir = "."
+	}
+	return keys.ProveSplit(session, vkPath, witnessPath, outputDir)
 }
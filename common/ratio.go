@@ -0,0 +1,85 @@
+package common
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+// RatioBatch collects SameRatio checks to verify them with a single multi-pairing.
+//
+// Each check e(a₁, a₂) = e(b₁, b₂) is weighted by a random ρ as e(ρa₁, a₂)·e(-ρb₁, b₂), and the G1 terms of the
+// checks are summed per G2 operand, so there is one pairing per distinct G2 point. A failing check makes the product
+// 1 with probability at most 1/r, as long as all points are in their subgroups.
+type RatioBatch struct {
+	checks []ratioCheck
+}
+
+type ratioCheck struct {
+	a1, b1 bn254.G1Affine
+	a2, b2 bn254.G2Affine
+	err    error
+}
+
+// Add adds the check e(a₁, a₂) = e(b₁, b₂), which fails with err
+func (b *RatioBatch) Add(a1, b1 bn254.G1Affine, a2, b2 bn254.G2Affine, err error) {
+	b.checks = append(b.checks, ratioCheck{a1, b1, a2, b2, err})
+}
+
+// Verify returns nil if all the checks hold, or else the error of the first failing one,
+// found by evaluating the checks one by one
+func (b *RatioBatch) Verify() error {
+	if len(b.checks) == 0 {
+		return nil
+	}
+
+	// Weigh each check by its randomness
+	terms := make([]bn254.G1Jac, 2*len(b.checks))
+	Parallelize(len(b.checks), func(start, end int) {
+		var rho fr.Element
+		var rhoBi big.Int
+		for i := start; i < end; i++ {
+			rho.SetRandom()
+			rho.BigInt(&rhoBi)
+			c := &b.checks[i]
+			terms[2*i].ScalarMultiplicationAffine(&c.a1, &rhoBi)
+			terms[2*i+1].ScalarMultiplicationAffine(&c.b1, &rhoBi)
+			terms[2*i+1].Neg(&terms[2*i+1])
+		}
+	})
+
+	// Sum the terms of the same G2 operand
+	index := make(map[bn254.G2Affine]int)
+	var P []bn254.G1Jac
+	var Q []bn254.G2Affine
+	for i := range b.checks {
+		for j, q := range []*bn254.G2Affine{&b.checks[i].a2, &b.checks[i].b2} {
+			k, ok := index[*q]
+			if !ok {
+				k = len(Q)
+				index[*q] = k
+				P = append(P, bn254.G1Jac{})
+				Q = append(Q, *q)
+			}
+			P[k].AddAssign(&terms[2*i+j])
+		}
+	}
+
+	ok, err := bn254.PairingCheck(bn254.BatchJacobianToAffineG1(P), Q)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	// Pinpoint the failing check
+	for _, c := range b.checks {
+		if !SameRatio(c.a1, c.b1, c.a2, c.b2) {
+			return c.err
+		}
+	}
+	return errors.New("failed batched pairing check")
+}
@@ -0,0 +1,143 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+)
+
+// Batched subgroup checks, for points decoded by NewBatchDecoder.
+//
+// The cofactor of G2 is 10069·5864401·1875725156269·p₁₇₇, each prime once, so a point outside G2 has a component
+// of prime order ℓ ≥ 10069 in a cyclic group. A random combination Σ dᵢ·Pᵢ with dᵢ < 2¹² < ℓ only lands in G2 if
+// the dᵢ of that point is the single value mod ℓ cancelling that component, so each of the independent rounds
+// misses it with probability at most 2⁻¹², and all of them with at most 2⁻¹³².
+const (
+	subgroupRounds = 11
+	subgroupBits   = 12
+
+	// Below this many points, the buckets cost more than checking each point
+	subgroupBatchMin = 1 << subgroupBits
+)
+
+var (
+	errNotOnCurve    = errors.New("invalid point: not on the curve")
+	errNotInSubGroup = errors.New("invalid point: subgroup check failed")
+)
+
+// NewBatchDecoder returns a decoder of r which skips subgroup checks. The caller checks the decoded points per batch
+// with CheckG1 and CheckG2, which cost less than checking them one at a time.
+func NewBatchDecoder(r io.Reader) *bn254.Decoder {
+	return bn254.NewDecoder(r, bn254.NoSubgroupChecks())
+}
+
+// CheckG1 returns an error if a point isn't in G1. G1 is the whole curve, so the points only need to be on it.
+func CheckG1(points []bn254.G1Affine) error {
+	var invalid atomic.Bool
+	Parallelize(len(points), func(start, end int) {
+		for i := start; i < end; i++ {
+			if !points[i].IsOnCurve() {
+				invalid.Store(true)
+				return
+			}
+		}
+	})
+	if invalid.Load() {
+		return errNotOnCurve
+	}
+	return nil
+}
+
+// CheckG2 returns an error if a point isn't in G2, except with probability 2⁻¹³².
+// The points are checked to be on the curve one by one, then to be in G2 by random combinations.
+func CheckG2(points []bn254.G2Affine) error {
+	var offCurve, outOfSubGroup atomic.Bool
+	Parallelize(len(points), func(start, end int) {
+		for i := start; i < end; i++ {
+			if !points[i].IsOnCurve() {
+				offCurve.Store(true)
+				return
+			}
+			if len(points) < subgroupBatchMin && !points[i].IsInSubGroup() {
+				outOfSubGroup.Store(true)
+				return
+			}
+		}
+	})
+	if offCurve.Load() {
+		return errNotOnCurve
+	}
+	if outOfSubGroup.Load() {
+		return errNotInSubGroup
+	}
+	if len(points) < subgroupBatchMin {
+		return nil
+	}
+
+	// Each round is split into chunks of points so the cores are busy, each chunk combined in its own buckets
+	chunks := (runtime.NumCPU() + subgroupRounds - 1) / subgroupRounds
+	if limit := len(points) / subgroupBatchMin; chunks > limit {
+		chunks = limit
+	}
+	chunkSize := (len(points) + chunks - 1) / chunks
+	sums := make([]bn254.G2Jac, subgroupRounds*chunks)
+	errs := make([]error, len(sums))
+	Parallelize(len(sums), func(start, end int) {
+		buckets := make([]bn254.G2Jac, 1<<subgroupBits)
+		scalars := make([]byte, 2*chunkSize)
+		for t := start; t < end; t++ {
+			from := t % chunks * chunkSize
+			to := from + chunkSize
+			if to > len(points) {
+				to = len(points)
+			}
+			if _, errs[t] = rand.Read(scalars); errs[t] != nil {
+				return
+			}
+			combineG2(&sums[t], points[from:to], scalars, buckets)
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	for round := 0; round < subgroupRounds; round++ {
+		var sum bn254.G2Jac
+		for _, s := range sums[round*chunks : (round+1)*chunks] {
+			sum.AddAssign(&s)
+		}
+		if !sum.IsInSubGroup() {
+			return errNotInSubGroup
+		}
+	}
+	return nil
+}
+
+// combineG2 sets res to Σ dᵢ·Pᵢ, dᵢ being the low bits of the i-th 2 bytes of scalars, by adding Pᵢ to the bucket dᵢ
+// then summing the buckets weighted by their index
+func combineG2(res *bn254.G2Jac, points []bn254.G2Affine, scalars []byte, buckets []bn254.G2Jac) {
+	for i := range buckets {
+		buckets[i].X.SetOne()
+		buckets[i].Y.SetOne()
+		buckets[i].Z.SetZero()
+	}
+	for i := range points {
+		d := binary.LittleEndian.Uint16(scalars[2*i:]) & (1<<subgroupBits - 1)
+		buckets[d].AddMixed(&points[i])
+	}
+
+	// Σ d·bucket[d] as the sum of the running sums from the top bucket down
+	var running bn254.G2Jac
+	*res = bn254.G2Jac{}
+	for d := len(buckets) - 1; d > 0; d-- {
+		running.AddAssign(&buckets[d])
+		res.AddAssign(&running)
+	}
+}
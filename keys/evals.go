@@ -0,0 +1,94 @@
+package keys
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bnb-chain/zkbnb-setup/common"
+	"github.com/bnb-chain/zkbnb-setup/phase2"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+)
+
+// checkEvals returns an error if the evaluations file doesn't have the sizes given by the header of phase 2,
+// so that evaluations of another circuit are rejected before their slices are allocated.
+// The file has [α]₁, [β]₁, [β]₂, then A, B₁, B₂, VKK, and CKK, each prefixed by its length.
+func checkEvals(evalsFile *os.File, header *phase2.Header) error {
+	info, err := evalsFile.Stat()
+	if err != nil {
+		return err
+	}
+	slices := []struct {
+		name      string
+		length    int
+		pointSize int64
+	}{
+		{"A", header.Wires, bn254.SizeOfG1AffineCompressed},
+		{"B₁", header.Wires, bn254.SizeOfG1AffineCompressed},
+		{"B₂", header.Wires, bn254.SizeOfG2AffineCompressed},
+		{"VKK", header.Public, bn254.SizeOfG1AffineCompressed},
+		{"CKK", header.PrivateCommitted, bn254.SizeOfG1AffineCompressed},
+	}
+	position := int64(2*bn254.SizeOfG1AffineCompressed + bn254.SizeOfG2AffineCompressed)
+	var length [4]byte
+	for _, s := range slices {
+		if _, err := evalsFile.ReadAt(length[:], position); err == io.EOF {
+			return fmt.Errorf("evaluations end before %s, they don't belong to the phase 2 file", s.name)
+		} else if err != nil {
+			return err
+		}
+		if n := binary.BigEndian.Uint32(length[:]); n != uint32(s.length) {
+			return fmt.Errorf("evaluations have %d points in %s, expected %d, they don't belong to the phase 2 file", n, s.name, s.length)
+		}
+		position += 4 + int64(s.length)*s.pointSize
+	}
+	if info.Size() < position {
+		return fmt.Errorf("evaluations have %d bytes, expected at least %d, they don't belong to the phase 2 file", info.Size(), position)
+	}
+	return nil
+}
+
+// evaluations of the circuit read from the evaluations file, without the commitments
+type evaluations struct {
+	G1 struct {
+		Alpha, Beta bn254.G1Affine
+		A, B, VKK   []bn254.G1Affine
+	}
+	G2 struct {
+		Beta bn254.G2Affine
+		B    []bn254.G2Affine
+	}
+}
+
+// readEvals reads the evaluations file of the phase 2 file whose header is given.
+// Subgroups aren't checked since the points are only compared to those of the keys.
+func readEvals(evalsPath string, header *phase2.Header) (*evaluations, error) {
+	evalsFile, err := os.Open(evalsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer evalsFile.Close()
+	if err := checkEvals(evalsFile, header); err != nil {
+		return nil, err
+	}
+
+	var evals evaluations
+	dec := common.NewBatchDecoder(bufio.NewReader(evalsFile))
+	toDecode := []interface{}{
+		&evals.G1.Alpha,
+		&evals.G1.Beta,
+		&evals.G2.Beta,
+		&evals.G1.A,
+		&evals.G1.B,
+		&evals.G2.B,
+		&evals.G1.VKK,
+	}
+	for _, v := range toDecode {
+		if err := dec.Decode(v); err != nil {
+			return nil, err
+		}
+	}
+	return &evals, nil
+}
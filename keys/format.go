@@ -0,0 +1,340 @@
+package keys
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/bnb-chain/zkbnb-setup/phase2"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+)
+
+// Layouts the keys can be serialized in
+const (
+	FormatFork     = "gnark-fork" // bnb-chain gnark fork, the layout keys are extracted in
+	FormatUpstream = "gnark-v0.9" // consensys/gnark v0.9 and later
+)
+
+// commitmentKey mirrors pedersen.Key as written by the gnark fork
+type commitmentKey struct {
+	G, GRootSigmaNeg     bn254.G2Affine
+	BasisExpSigma, Basis []bn254.G1Affine
+}
+
+// setupCommitmentKeys sets up a Pedersen commitment key for each basis as pedersen.Setup does,
+// but with the same σ for all since consensys/gnark v0.9 folds their proofs of knowledge.
+// It returns the verifying part, [g]₂ and [-g/σ]₂, on its own as well.
+func setupCommitmentKeys(bases [][]bn254.G1Affine) (commitmentKey, []commitmentKey, error) {
+	var vk commitmentKey
+	gBytes := make([]byte, fr.Bytes)
+	if _, err := rand.Read(gBytes); err != nil {
+		return vk, nil, err
+	}
+	var err error
+	if vk.G, err = bn254.HashToG2(gBytes, []byte("random on g2")); err != nil {
+		return vk, nil, err
+	}
+
+	var sigma, sigmaInvNeg fr.Element
+	for sigma.IsZero() {
+		if _, err := sigma.SetRandom(); err != nil {
+			return vk, nil, err
+		}
+	}
+	sigmaInvNeg.Inverse(&sigma).Neg(&sigmaInvNeg)
+	var sigmaBi, sigmaInvNegBi big.Int
+	sigma.BigInt(&sigmaBi)
+	sigmaInvNeg.BigInt(&sigmaInvNegBi)
+	vk.GRootSigmaNeg.ScalarMultiplication(&vk.G, &sigmaInvNegBi)
+
+	keys := make([]commitmentKey, len(bases))
+	for j, basis := range bases {
+		keys[j] = vk
+		keys[j].Basis = basis
+		keys[j].BasisExpSigma = make([]bn254.G1Affine, len(basis))
+		for i := range basis {
+			keys[j].BasisExpSigma[i].ScalarMultiplication(&basis[i], &sigmaBi)
+		}
+	}
+	return vk, keys, nil
+}
+
+// writeTo writes the key as pedersen.Key.WriteTo of the gnark fork
+func (ck *commitmentKey) writeTo(w io.Writer) (int64, error) {
+	enc := bn254.NewEncoder(w, bn254.RawEncoding())
+	toEncode := []interface{}{
+		&ck.G,
+		&ck.GRootSigmaNeg,
+		ck.BasisExpSigma,
+		ck.Basis,
+	}
+	for _, v := range toEncode {
+		if err := enc.Encode(v); err != nil {
+			return enc.BytesWritten(), err
+		}
+	}
+	return enc.BytesWritten(), nil
+}
+
+func (ck *commitmentKey) readFrom(reader io.Reader) error {
+	dec := bn254.NewDecoder(reader)
+	toDecode := []interface{}{
+		&ck.G,
+		&ck.GRootSigmaNeg,
+		&ck.BasisExpSigma,
+		&ck.Basis,
+	}
+	for _, v := range toDecode {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckFormat returns an error if format isn't supported
+func CheckFormat(format string) error {
+	if format != FormatFork && format != FormatUpstream {
+		return fmt.Errorf("unknown keys format %s, expected %s or %s", format, FormatFork, FormatUpstream)
+	}
+	return nil
+}
+
+// convertKeys rewrites pk extracted in the layout of the gnark fork at pkPath,
+// and writes vk to vk, in the layout of consensys/gnark v0.9
+func convertKeys(pkPath string, vk *VerifyingKey) error {
+	var pk provingKey
+	pkFile, err := os.Open(pkPath)
+	if err != nil {
+		return err
+	}
+	defer pkFile.Close()
+	if err := pk.readFrom(bufio.NewReader(pkFile)); err != nil {
+		return err
+	}
+	return writeUpstreamKeys(&pk, vk, pkPath, "vk")
+}
+
+// convertSplitKeys writes the split proving key of session and vk to <session>.pk and <session>.vk
+// in the layout of consensys/gnark v0.9
+func convertSplitKeys(session string, vk *VerifyingKey) error {
+	var pk provingKey
+	if err := pk.readSplit(session); err != nil {
+		return err
+	}
+	return writeUpstreamKeys(&pk, vk, session+".pk", session+".vk")
+}
+
+// writeUpstreamKeys writes pk and vk in the layout of consensys/gnark v0.9,
+// where the Pedersen commitment keys move from the verifying key to the proving key
+func writeUpstreamKeys(pk *provingKey, vk *VerifyingKey, outPkPath, outVkPath string) error {
+	fmt.Printf("Writing keys in %s\n", FormatUpstream)
+
+	// The verifier sees the commitment wires committed to by a later commitment as public inputs
+	// following the public wires, as GetPublicAndCommitmentCommitted of consensys/gnark v0.9 translates them
+	nbPublic := len(vk.G1.K) - len(vk.commitments)
+	publicCommitted := make([][]uint64, len(vk.commitments))
+	for j, c := range vk.commitments {
+		publicCommitted[j] = make([]uint64, len(c.PublicAndCommitmentCommitted))
+		for k, w := range c.PublicAndCommitmentCommitted {
+			if k >= c.NbPublicCommitted {
+				for l := range vk.commitments {
+					if vk.commitments[l].CommitmentIndex == w {
+						w = nbPublic + l
+						break
+					}
+				}
+			}
+			publicCommitted[j][k] = uint64(w)
+		}
+	}
+
+	// Write to temporary files since the output may replace the input
+	if err := writeFile(outPkPath, func(w io.Writer) error {
+		return pk.writeUpstream(w, vk.commitmentKeys)
+	}); err != nil {
+		return err
+	}
+	return writeFile(outVkPath, func(w io.Writer) error {
+		return vk.writeUpstream(w, publicCommitted)
+	})
+}
+
+// writeUpstream writes the proving key as ProvingKey.WriteRawTo of consensys/gnark v0.9
+func (pk *provingKey) writeUpstream(w io.Writer, commitmentKeys []commitmentKey) error {
+	domain := fft.NewDomain(pk.Domain)
+	if _, err := domain.WriteTo(w); err != nil {
+		return err
+	}
+	enc := bn254.NewEncoder(w, bn254.RawEncoding())
+	toEncode := []interface{}{
+		&pk.G1.Alpha,
+		&pk.G1.Beta,
+		&pk.G1.Delta,
+		pk.G1.A,
+		pk.G1.B,
+		pk.G1.Z,
+		pk.G1.K,
+		&pk.G2.Beta,
+		&pk.G2.Delta,
+		pk.G2.B,
+		pk.NbWires,
+		pk.NbInfinityA,
+		pk.NbInfinityB,
+		&pk.InfinityA,
+		&pk.InfinityB,
+	}
+	for _, v := range toEncode {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+
+	// uint32(len(CommitmentKeys)), then the basis and basis^σ of each
+	if err := binary.Write(w, binary.BigEndian, uint32(len(commitmentKeys))); err != nil {
+		return err
+	}
+	for i := range commitmentKeys {
+		if err := enc.Encode(commitmentKeys[i].Basis); err != nil {
+			return err
+		}
+		if err := enc.Encode(commitmentKeys[i].BasisExpSigma); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeUpstream writes the verifying key as VerifyingKey.WriteRawTo of consensys/gnark v0.9
+func (vk *VerifyingKey) writeUpstream(w io.Writer, publicCommitted [][]uint64) error {
+	enc := bn254.NewEncoder(w, bn254.RawEncoding())
+
+	// [α]₁,[β]₁,[β]₂,[γ]₂,[δ]₁,[δ]₂,uint32(len(K)),[K]₁
+	toEncode := []interface{}{
+		&vk.G1.Alpha,
+		&vk.G1.Beta,
+		&vk.G2.Beta,
+		&vk.G2.Gamma,
+		&vk.G1.Delta,
+		&vk.G2.Delta,
+		vk.G1.K,
+	}
+	for _, v := range toEncode {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+
+	// PublicAndCommitmentCommitted as uint32(len), then uint32(len) and the uint64 indexes of each commitment
+	if err := binary.Write(w, binary.BigEndian, uint32(len(publicCommitted))); err != nil {
+		return err
+	}
+	for _, committed := range publicCommitted {
+		if err := binary.Write(w, binary.BigEndian, uint32(len(committed))); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, committed); err != nil {
+			return err
+		}
+	}
+
+	// Pedersen verifying key
+	if err := enc.Encode(&vk.CommitmentKey.G); err != nil {
+		return err
+	}
+	return enc.Encode(&vk.CommitmentKey.GRootSigmaNeg)
+}
+
+// readUpstream reads the verifying key written by writeUpstream
+func (vk *VerifyingKey) readUpstream(reader io.Reader) error {
+	dec := bn254.NewDecoder(reader)
+	toDecode := []interface{}{
+		&vk.G1.Alpha,
+		&vk.G1.Beta,
+		&vk.G2.Beta,
+		&vk.G2.Gamma,
+		&vk.G1.Delta,
+		&vk.G2.Delta,
+		&vk.G1.K,
+	}
+	for _, v := range toDecode {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	}
+
+	var nbCommitments uint32
+	if err := binary.Read(reader, binary.BigEndian, &nbCommitments); err != nil {
+		return err
+	}
+	vk.commitments = make([]phase2.Commitment, nbCommitments)
+	for j := range vk.commitments {
+		var nbCommitted uint32
+		if err := binary.Read(reader, binary.BigEndian, &nbCommitted); err != nil {
+			return err
+		}
+		committed := make([]uint64, nbCommitted)
+		if err := binary.Read(reader, binary.BigEndian, committed); err != nil {
+			return err
+		}
+		vk.commitments[j].PublicAndCommitmentCommitted = make([]int, nbCommitted)
+		for k := range committed {
+			vk.commitments[j].PublicAndCommitmentCommitted[k] = int(committed[k])
+		}
+	}
+
+	if err := dec.Decode(&vk.CommitmentKey.G); err != nil {
+		return err
+	}
+	return dec.Decode(&vk.CommitmentKey.GRootSigmaNeg)
+}
+
+// readVerifyingKey reads the verifying key at vkPath written in format
+func readVerifyingKey(vkPath, format string) (*VerifyingKey, error) {
+	if err := CheckFormat(format); err != nil {
+		return nil, err
+	}
+	var vk VerifyingKey
+	vkFile, err := os.Open(vkPath)
+	if err != nil {
+		return nil, err
+	}
+	defer vkFile.Close()
+	if format == FormatFork {
+		err = vk.readFrom(bufio.NewReader(vkFile))
+	} else {
+		err = vk.readUpstream(bufio.NewReader(vkFile))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &vk, nil
+}
+
+// writeFile writes to a temporary file which then replaces path
+func writeFile(path string, write func(w io.Writer) error) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	if err := write(writer); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
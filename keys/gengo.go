@@ -0,0 +1,137 @@
+package keys
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// GoOptions customizes the Go package exported by ExportGo
+type GoOptions struct {
+	Package   string // name of the package, verifier by default
+	EvalsPath string // evaluations of a phase 2 input, evals by default
+
+	// If ProofPath isn't empty, the generated test also checks that the proof at ProofPath
+	// verifies for the public witness at WitnessPath
+	ProofPath   string
+	WitnessPath string
+}
+
+// ExportGo exports to outputPath the Go source of a package embedding the verifying key at inputPath, and its test
+// next to it. The input is either a verifying key in the gnark fork layout or a phase 2 file (.ph2), whose verifying
+// key is built on the fly from the evaluations at opts.EvalsPath
+func ExportGo(inputPath, outputPath string, opts GoOptions) error {
+	fmt.Printf("Exporting %s\n", outputPath)
+	if opts.Package == "" {
+		opts.Package = "verifier"
+	}
+	if opts.EvalsPath == "" {
+		opts.EvalsPath = "evals"
+	}
+	if !token.IsIdentifier(opts.Package) {
+		return fmt.Errorf("invalid package name %s", opts.Package)
+	}
+	if !strings.HasSuffix(outputPath, ".go") || strings.HasSuffix(outputPath, "_test.go") {
+		return errors.New("output path must be a .go file which isn't a test")
+	}
+
+	vk, err := readVerifierVK(inputPath, opts.EvalsPath)
+	if err != nil {
+		return err
+	}
+	data := struct {
+		*VerifyingKey
+		Package       string
+		Size          int64
+		Digest, Proof string
+		Input         []string
+	}{VerifyingKey: vk, Package: opts.Package}
+
+	// The test checks the embedded verifying key against the digest of the serialized one
+	h := sha256.New()
+	size, err := vk.writePointsTo(h)
+	if err != nil {
+		return err
+	}
+	data.Size = size
+	data.Digest = hex.EncodeToString(h.Sum(nil))
+	if opts.ProofPath != "" {
+		if data.Proof, data.Input, err = readSample(vk, &opts); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	if err := executeGoTemplate(goVerifierTemplate, outputPath, data); err != nil {
+		return err
+	}
+	testPath := strings.TrimSuffix(outputPath, ".go") + "_test.go"
+	if err := executeGoTemplate(goVerifierTestTemplate, testPath, data); err != nil {
+		return err
+	}
+	fmt.Printf("%s has been extracted successfully\n", outputPath)
+	return nil
+}
+
+// readSample returns the proof at opts.ProofPath in hexadecimal and the public inputs of opts.WitnessPath
+func readSample(vk *VerifyingKey, opts *GoOptions) (string, []string, error) {
+	if opts.WitnessPath == "" {
+		return "", nil, errors.New("the sample proof needs its public witness")
+	}
+	proof, err := os.ReadFile(opts.ProofPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	w, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return "", nil, err
+	}
+	witnessFile, err := os.Open(opts.WitnessPath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer witnessFile.Close()
+	if _, err := w.ReadFrom(bufio.NewReader(witnessFile)); err != nil {
+		return "", nil, err
+	}
+	public, ok := w.Vector().(fr.Vector)
+	if !ok || len(public) != len(vk.G1.K)-1 {
+		return "", nil, fmt.Errorf("the public witness doesn't have the %d inputs of the verifying key", len(vk.G1.K)-1)
+	}
+	inputs := make([]string, len(public))
+	for i := range public {
+		inputs[i] = public[i].String()
+	}
+	return hex.EncodeToString(proof), inputs, nil
+}
+
+// executeGoTemplate executes the template into path, formatted by gofmt
+func executeGoTemplate(text, path string, data interface{}) error {
+	var src strings.Builder
+	if err := executeTemplateTo(text, &src, data); err != nil {
+		return err
+	}
+	formatted, err := format.Source([]byte(src.String()))
+	if err != nil {
+		return err
+	}
+	return writeFile(path, func(w io.Writer) error {
+		_, err := w.Write(formatted)
+		return err
+	})
+}
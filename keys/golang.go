@@ -0,0 +1,274 @@
+package keys
+
+// goVerifierTemplate is a Go package embedding the verifying key, which it rebuilds
+// in the layout of the gnark fork so gnark's verifier can be called on it
+const goVerifierTemplate = `// Code generated by zkbnb-setup keys gen-go. DO NOT EDIT.
+
+// Package {{.Package}} verifies Groth16 proofs on bn254 against the embedded verifying key.
+package {{.Package}}
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+)
+
+// NbPublicInputs is the number of public inputs of the circuit
+const NbPublicInputs = {{sub (len .G1.K) 1}}
+
+// Coordinates of the verifying key, G2 coordinates are given as A0 + A1·u
+const (
+	alphaG1X   = "{{.G1.Alpha.X.String}}"
+	alphaG1Y   = "{{.G1.Alpha.Y.String}}"
+	betaG1X    = "{{.G1.Beta.X.String}}"
+	betaG1Y    = "{{.G1.Beta.Y.String}}"
+	betaG2XA0  = "{{.G2.Beta.X.A0.String}}"
+	betaG2XA1  = "{{.G2.Beta.X.A1.String}}"
+	betaG2YA0  = "{{.G2.Beta.Y.A0.String}}"
+	betaG2YA1  = "{{.G2.Beta.Y.A1.String}}"
+	gammaG2XA0 = "{{.G2.Gamma.X.A0.String}}"
+	gammaG2XA1 = "{{.G2.Gamma.X.A1.String}}"
+	gammaG2YA0 = "{{.G2.Gamma.Y.A0.String}}"
+	gammaG2YA1 = "{{.G2.Gamma.Y.A1.String}}"
+	deltaG1X   = "{{.G1.Delta.X.String}}"
+	deltaG1Y   = "{{.G1.Delta.Y.String}}"
+	deltaG2XA0 = "{{.G2.Delta.X.A0.String}}"
+	deltaG2XA1 = "{{.G2.Delta.X.A1.String}}"
+	deltaG2YA0 = "{{.G2.Delta.Y.A0.String}}"
+	deltaG2YA1 = "{{.G2.Delta.Y.A1.String}}"
+
+	// [g]₂ and [-g/σ]₂ of the Pedersen commitment key, which the circuit doesn't use
+	commitmentGXA0             = "{{.CommitmentKey.G.X.A0.String}}"
+	commitmentGXA1             = "{{.CommitmentKey.G.X.A1.String}}"
+	commitmentGYA0             = "{{.CommitmentKey.G.Y.A0.String}}"
+	commitmentGYA1             = "{{.CommitmentKey.G.Y.A1.String}}"
+	commitmentGRootSigmaNegXA0 = "{{.CommitmentKey.GRootSigmaNeg.X.A0.String}}"
+	commitmentGRootSigmaNegXA1 = "{{.CommitmentKey.GRootSigmaNeg.X.A1.String}}"
+	commitmentGRootSigmaNegYA0 = "{{.CommitmentKey.GRootSigmaNeg.Y.A0.String}}"
+	commitmentGRootSigmaNegYA1 = "{{.CommitmentKey.GRootSigmaNeg.Y.A1.String}}"
+)
+
+// kG1 holds [K]₁, the first point is for the constant wire followed by one point per public input
+var kG1 = [NbPublicInputs + 1][2]string{
+{{- range .G1.K}}
+	{"{{.X.String}}", "{{.Y.String}}"},
+{{- end}}
+}
+
+var (
+	vk     groth16.VerifyingKey
+	vkErr  error
+	vkOnce sync.Once
+)
+
+// VerifyingKey returns the embedded verifying key
+func VerifyingKey() (groth16.VerifyingKey, error) {
+	vkOnce.Do(func() {
+		vk, vkErr = buildVerifyingKey()
+	})
+	return vk, vkErr
+}
+
+// Verify verifies proof for the public inputs against the embedded verifying key
+func Verify(proof groth16.Proof, publicInputs []fr.Element) error {
+	if len(publicInputs) != NbPublicInputs {
+		return fmt.Errorf("expected %d public inputs, got %d", NbPublicInputs, len(publicInputs))
+	}
+	vk, err := VerifyingKey()
+	if err != nil {
+		return err
+	}
+	publicWitness, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return err
+	}
+	values := make(chan any, len(publicInputs))
+	for i := range publicInputs {
+		values <- publicInputs[i]
+	}
+	close(values)
+	if err := publicWitness.Fill(NbPublicInputs, 0, values); err != nil {
+		return err
+	}
+	return groth16.Verify(proof, vk, publicWitness)
+}
+
+// buildVerifyingKey writes the coordinates as VerifyingKey.WriteRawTo does and reads them back
+func buildVerifyingKey() (groth16.VerifyingKey, error) {
+	var g1 [3]bn254.G1Affine
+	var g2 [5]bn254.G2Affine
+	k := make([]bn254.G1Affine, len(kG1))
+	for i, c := range [][2]string{
+		{alphaG1X, alphaG1Y},
+		{betaG1X, betaG1Y},
+		{deltaG1X, deltaG1Y},
+	} {
+		if err := setG1(&g1[i], c[0], c[1]); err != nil {
+			return nil, err
+		}
+	}
+	for i, c := range [][4]string{
+		{betaG2XA0, betaG2XA1, betaG2YA0, betaG2YA1},
+		{gammaG2XA0, gammaG2XA1, gammaG2YA0, gammaG2YA1},
+		{deltaG2XA0, deltaG2XA1, deltaG2YA0, deltaG2YA1},
+		{commitmentGXA0, commitmentGXA1, commitmentGYA0, commitmentGYA1},
+		{commitmentGRootSigmaNegXA0, commitmentGRootSigmaNegXA1, commitmentGRootSigmaNegYA0, commitmentGRootSigmaNegYA1},
+	} {
+		if err := setG2(&g2[i], c); err != nil {
+			return nil, err
+		}
+	}
+	for i := range kG1 {
+		if err := setG1(&k[i], kG1[i][0], kG1[i][1]); err != nil {
+			return nil, err
+		}
+	}
+
+	// The circuit has no Pedersen commitment, so the bases of the commitment key are empty
+	var buf bytes.Buffer
+	enc := bn254.NewEncoder(&buf, bn254.RawEncoding())
+	toEncode := []interface{}{
+		&g2[3],
+		&g2[4],
+		[]bn254.G1Affine{},
+		[]bn254.G1Affine{},
+		&g1[0],
+		&g1[1],
+		&g2[0],
+		&g2[1],
+		&g1[2],
+		&g2[2],
+		k,
+	}
+	for _, v := range toEncode {
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+	if err := gob.NewEncoder(&buf).Encode(constraint.Commitment{}); err != nil {
+		return nil, err
+	}
+
+	vk := groth16.NewVerifyingKey(ecc.BN254)
+	if _, err := vk.ReadFrom(&buf); err != nil {
+		return nil, err
+	}
+	return vk, nil
+}
+
+func setG1(p *bn254.G1Affine, x, y string) error {
+	if _, err := p.X.SetString(x); err != nil {
+		return err
+	}
+	_, err := p.Y.SetString(y)
+	return err
+}
+
+func setG2(p *bn254.G2Affine, c [4]string) error {
+	for i, e := range []*fp.Element{&p.X.A0, &p.X.A1, &p.Y.A0, &p.Y.A1} {
+		if _, err := e.SetString(c[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+`
+
+// goVerifierTestTemplate tests the package generated from goVerifierTemplate: the verifying key
+// must be the one it was generated from, and the sample proof, if any, must verify
+const goVerifierTestTemplate = `// Code generated by zkbnb-setup keys gen-go. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+{{- if .Proof}}
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+{{- end}}
+)
+
+// SHA-256 of the first vkSize bytes of the verifying key the package was generated from,
+// the gob encoded commitment info which follows depends on the types the process encoded before
+const (
+	vkSize   = {{.Size}}
+	vkDigest = "{{.Digest}}"
+)
+
+func TestVerifyingKey(t *testing.T) {
+	vk, err := VerifyingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := vk.WriteRawTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() < vkSize {
+		t.Fatal("verifying key is too short")
+	}
+	digest := sha256.Sum256(buf.Bytes()[:vkSize])
+	if hex.EncodeToString(digest[:]) != vkDigest {
+		t.Error("embedded verifying key differs from the one the package was generated from")
+	}
+}
+{{- if .Proof}}
+
+// Sample proof and its public inputs
+const sampleProof = "{{.Proof}}"
+
+var samplePublicInputs = []string{
+{{- range .Input}}
+	"{{.}}",
+{{- end}}
+}
+
+func TestVerify(t *testing.T) {
+	proofBytes, err := hex.DecodeString(sampleProof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := groth16.NewProof(ecc.BN254)
+	if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		t.Fatal(err)
+	}
+	publicInputs := make([]fr.Element, len(samplePublicInputs))
+	for i := range samplePublicInputs {
+		if _, err := publicInputs[i].SetString(samplePublicInputs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Verify(proof, publicInputs); err != nil {
+		t.Fatal(err)
+	}
+
+	{{- if .Input}}
+
+	// The proof is rejected once the first public input is changed
+	var one fr.Element
+	one.SetOne()
+	publicInputs[0].Add(&publicInputs[0], &one)
+	if err := Verify(proof, publicInputs); err == nil {
+		t.Error("proof is accepted for a wrong public input")
+	}
+	{{- end}}
+	if err := Verify(proof, append(publicInputs, fr.Element{})); err == nil {
+		t.Error("proof is accepted for a wrong number of public inputs")
+	}
+}
+{{- end}}
+`
@@ -3,16 +3,15 @@ package keys
 import (
 	"bufio"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 
+	"github.com/bnb-chain/zkbnb-setup/common"
 	"github.com/bnb-chain/zkbnb-setup/phase2"
-	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark-crypto/ecc/bn254"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
-	"github.com/consensys/gnark-crypto/ecc/bn254/fr/pedersen"
-	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/constraint"
 )
 
@@ -27,12 +26,28 @@ type VerifyingKey struct {
 		Beta, Delta, Gamma bn254.G2Affine
 	}
 
-	CommitmentKey  pedersen.Key
+	CommitmentKey  commitmentKey
 	CommitmentInfo constraint.Commitment // since the verifier doesn't input a constraint system, this needs to be provided here
+
+	commitmentKeys []commitmentKey     // one per commitment, sharing the verifying part of CommitmentKey
+	commitments    []phase2.Commitment // several commitments can only be written in the upstream layout
 }
 
 func (vk *VerifyingKey) writeTo(w io.Writer) (int64, error) {
-	n, err := vk.CommitmentKey.WriteTo(w)
+	n, err := vk.writePointsTo(w)
+	if err != nil {
+		return n, err
+	}
+	encGob := gob.NewEncoder(w)
+	if err := encGob.Encode(vk.CommitmentInfo); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// writePointsTo writes the key up to CommitmentInfo, whose gob encoding depends on the types the process encoded before
+func (vk *VerifyingKey) writePointsTo(w io.Writer) (int64, error) {
+	n, err := vk.CommitmentKey.writeTo(w)
 	if err != nil {
 		return n, err
 	}
@@ -62,11 +77,6 @@ func (vk *VerifyingKey) writeTo(w io.Writer) (int64, error) {
 	if err := enc.Encode(vk.G1.K); err != nil {
 		return n + enc.BytesWritten(), err
 	}
-
-	encGob := gob.NewEncoder(w)
-	if err := encGob.Encode(vk.CommitmentInfo); err != nil {
-		return n + enc.BytesWritten(), err
-	}
 	return n + enc.BytesWritten(), nil
 }
 
@@ -93,6 +103,9 @@ func extractPK(phase2Path string) error {
 	if err := header.Read(ph2Reader); err != nil {
 		return err
 	}
+	if err := checkEvals(evalsFile, &header); err != nil {
+		return err
+	}
 
 	decPh2 := bn254.NewDecoder(ph2Reader)
 	decEvals := bn254.NewDecoder(evalsReader)
@@ -146,9 +159,15 @@ func extractPK(phase2Path string) error {
 		return err
 	}
 
+	batchPh2 := common.NewBatchDecoder(ph2Reader)
+	batchEvals := common.NewBatchDecoder(evalsReader)
+
 	// 4. Read, Filter, Write A
 	var buffG1 []bn254.G1Affine
-	if err := decEvals.Decode(&buffG1); err != nil {
+	if err := batchEvals.Decode(&buffG1); err != nil {
+		return err
+	}
+	if err := common.CheckG1(buffG1); err != nil {
 		return err
 	}
 	buffG1, infinityA, nbInfinityA := filterInfinityG1(buffG1)
@@ -157,7 +176,10 @@ func extractPK(phase2Path string) error {
 	}
 
 	// 5. Read, Filter, Write B
-	if err := decEvals.Decode(&buffG1); err != nil {
+	if err := batchEvals.Decode(&buffG1); err != nil {
+		return err
+	}
+	if err := common.CheckG1(buffG1); err != nil {
 		return err
 	}
 	buffG1, infinityB, nbInfinityB := filterInfinityG1(buffG1)
@@ -168,10 +190,13 @@ func extractPK(phase2Path string) error {
 	// 6. Read/Write Z
 	buffG1 = make([]bn254.G1Affine, header.Domain-1)
 	for i := 0; i < header.Domain-1; i++ {
-		if err := decPh2.Decode(&buffG1[i]); err != nil {
+		if err := batchPh2.Decode(&buffG1[i]); err != nil {
 			return err
 		}
 	}
+	if err := common.CheckG1(buffG1); err != nil {
+		return err
+	}
 	if err := encPk.Encode(buffG1); err != nil {
 		return err
 	}
@@ -179,10 +204,13 @@ func extractPK(phase2Path string) error {
 	// 7. Read/Write PKK
 	buffG1 = make([]bn254.G1Affine, header.Witness)
 	for i := 0; i < header.Witness; i++ {
-		if err := decPh2.Decode(&buffG1[i]); err != nil {
+		if err := batchPh2.Decode(&buffG1[i]); err != nil {
 			return err
 		}
 	}
+	if err := common.CheckG1(buffG1); err != nil {
+		return err
+	}
 	if err := encPk.Encode(buffG1); err != nil {
 		return err
 	}
@@ -199,7 +227,10 @@ func extractPK(phase2Path string) error {
 
 	// 10. Read, Filter, Write B₂
 	var buffG2 []bn254.G2Affine
-	if err := decEvals.Decode(&buffG2); err != nil {
+	if err := batchEvals.Decode(&buffG2); err != nil {
+		return err
+	}
+	if err := common.CheckG2(buffG2); err != nil {
 		return err
 	}
 	buffG2, _, _ = filterInfinityG2(buffG2)
@@ -260,6 +291,9 @@ func extractSplitPK(phase2Path, session string) error {
 	if err := header.Read(ph2Reader); err != nil {
 		return err
 	}
+	if err := checkEvals(evalsFile, &header); err != nil {
+		return err
+	}
 
 	decPh2 := bn254.NewDecoder(ph2Reader)
 	decEvals := bn254.NewDecoder(evalsReader)
@@ -370,9 +404,15 @@ func extractSplitPK(phase2Path, session string) error {
 		return err
 	}
 
+	batchPh2 := common.NewBatchDecoder(ph2Reader)
+	batchEvals := common.NewBatchDecoder(evalsReader)
+
 	// 4. Read, Filter, Write A
 	var buffG1 []bn254.G1Affine
-	if err := decEvals.Decode(&buffG1); err != nil {
+	if err := batchEvals.Decode(&buffG1); err != nil {
+		return err
+	}
+	if err := common.CheckG1(buffG1); err != nil {
 		return err
 	}
 	buffG1, infinityA, nbInfinityA := filterInfinityG1(buffG1)
@@ -381,7 +421,10 @@ func extractSplitPK(phase2Path, session string) error {
 	}
 
 	// 5. Read, Filter, Write B
-	if err := decEvals.Decode(&buffG1); err != nil {
+	if err := batchEvals.Decode(&buffG1); err != nil {
+		return err
+	}
+	if err := common.CheckG1(buffG1); err != nil {
 		return err
 	}
 	buffG1, infinityB, nbInfinityB := filterInfinityG1(buffG1)
@@ -392,10 +435,13 @@ func extractSplitPK(phase2Path, session string) error {
 	// 6. Read/Write Z
 	buffG1 = make([]bn254.G1Affine, header.Domain-1)
 	for i := 0; i < header.Domain-1; i++ {
-		if err := decPh2.Decode(&buffG1[i]); err != nil {
+		if err := batchPh2.Decode(&buffG1[i]); err != nil {
 			return err
 		}
 	}
+	if err := common.CheckG1(buffG1); err != nil {
+		return err
+	}
 	if err := encPkZ.Encode(buffG1); err != nil {
 		return err
 	}
@@ -403,10 +449,13 @@ func extractSplitPK(phase2Path, session string) error {
 	// 7. Read/Write PKK
 	buffG1 = make([]bn254.G1Affine, header.Witness)
 	for i := 0; i < header.Witness; i++ {
-		if err := decPh2.Decode(&buffG1[i]); err != nil {
+		if err := batchPh2.Decode(&buffG1[i]); err != nil {
 			return err
 		}
 	}
+	if err := common.CheckG1(buffG1); err != nil {
+		return err
+	}
 	if err := encPkK.Encode(buffG1); err != nil {
 		return err
 	}
@@ -423,7 +472,10 @@ func extractSplitPK(phase2Path, session string) error {
 
 	// 10. Read, Filter, Write B₂
 	var buffG2 []bn254.G2Affine
-	if err := decEvals.Decode(&buffG2); err != nil {
+	if err := batchEvals.Decode(&buffG2); err != nil {
+		return err
+	}
+	if err := common.CheckG2(buffG2); err != nil {
 		return err
 	}
 	buffG2, _, _ = filterInfinityG2(buffG2)
@@ -461,55 +513,51 @@ func extractSplitPK(phase2Path, session string) error {
 	return nil
 }
 
-func extractVK(phase2Path string) error {
+// readVK reads the verifying key from the phase 2 file and its evaluations, and sets up the commitment keys
+func readVK(phase2Path, evalsPath string) (*VerifyingKey, error) {
 	vk := VerifyingKey{}
 	// Phase 2 file
 	phase2File, err := os.Open(phase2Path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer phase2File.Close()
 
 	// Evaluations
-	evalsFile, err := os.Open("evals")
+	evalsFile, err := os.Open(evalsPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer evalsFile.Close()
 
-	// Use buffered IO to write parameters efficiently
+	// Use buffered IO to read parameters efficiently
 	ph2Reader := bufio.NewReader(phase2File)
 	evalsReader := bufio.NewReader(evalsFile)
 
 	var header phase2.Header
 	if err := header.Read(ph2Reader); err != nil {
-		return err
+		return nil, err
+	}
+	if err := checkEvals(evalsFile, &header); err != nil {
+		return nil, err
 	}
 
 	decPh2 := bn254.NewDecoder(ph2Reader)
 	decEvals := bn254.NewDecoder(evalsReader)
 
-	vkFile, err := os.Create("vk")
-	if err != nil {
-		return err
-	}
-	defer vkFile.Close()
-	vkWriter := bufio.NewWriter(vkFile)
-	defer vkWriter.Flush()
-
 	// 1. Read [α]₁
 	if err := decEvals.Decode(&vk.G1.Alpha); err != nil {
-		return err
+		return nil, err
 	}
 
 	// 2. Read [β]₁
 	if err := decEvals.Decode(&vk.G1.Beta); err != nil {
-		return err
+		return nil, err
 	}
 
 	// 3. Read [β]₂
 	if err := decEvals.Decode(&vk.G2.Beta); err != nil {
-		return err
+		return nil, err
 	}
 
 	// 4. Set [γ]₂
@@ -518,124 +566,96 @@ func extractVK(phase2Path string) error {
 
 	// 5. Read [δ]₁
 	if err := decPh2.Decode(&vk.G1.Delta); err != nil {
-		return err
+		return nil, err
 	}
 
 	// 6. Read [δ]₂
 	if err := decPh2.Decode(&vk.G2.Delta); err != nil {
-		return err
+		return nil, err
 	}
 
 	// 7. Read VKK
 	pos := int64(128*(header.Wires+1) + 12)
 	if _, err := evalsFile.Seek(pos, io.SeekStart); err != nil {
-		return err
+		return nil, err
 	}
 	evalsReader.Reset(evalsFile)
 	if err := decEvals.Decode(&vk.G1.K); err != nil {
-		return err
+		return nil, err
 	}
 
-	// 8. Setup commitment key
+	// 8. Read CKK and the commitments, evaluations written before several commitments
+	// were supported only have the CommitmentInfo
 	var ckk []bn254.G1Affine
 	if err := decEvals.Decode(&ckk); err != nil {
-		return err
+		return nil, err
 	}
-	vk.CommitmentKey, err = pedersen.Setup(ckk)
-	if err != nil {
-		return err
+	decGob := gob.NewDecoder(evalsReader)
+	if err := decGob.Decode(&vk.CommitmentInfo); err != nil {
+		return nil, err
 	}
-	if _, err := vk.writeTo(vkWriter); err != nil {
-		return err
+	if err := decGob.Decode(&vk.commitments); err == io.EOF {
+		vk.commitments = phase2.CommitmentsFromInfo(vk.CommitmentInfo)
+	} else if err != nil {
+		return nil, err
 	}
-	return nil
-}
 
-func extractSplitVK(phase2Path, session string) error {
-	vk := VerifyingKey{}
-	// Phase 2 file
-	phase2File, err := os.Open(phase2Path)
-	if err != nil {
-		return err
+	// 9. Setup commitment keys, splitting CKK by commitment
+	bases := make([][]bn254.G1Affine, len(vk.commitments))
+	for j := range vk.commitments {
+		n := len(vk.commitments[j].PrivateCommitted)
+		if n > len(ckk) {
+			return nil, errors.New("evaluations have fewer CKK than committed wires")
+		}
+		bases[j], ckk = ckk[:n], ckk[n:]
 	}
-	defer phase2File.Close()
-
-	// Evaluations
-	evalsFile, err := os.Open("evals")
+	vk.CommitmentKey, vk.commitmentKeys, err = setupCommitmentKeys(bases)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer evalsFile.Close()
+	if len(vk.commitmentKeys) == 1 {
+		vk.CommitmentKey = vk.commitmentKeys[0]
+	}
+	return &vk, nil
+}
 
-	// Use buffered IO to write parameters efficiently
-	ph2Reader := bufio.NewReader(phase2File)
-	evalsReader := bufio.NewReader(evalsFile)
+// checkForkVK returns an error if vk can't be written in the layout of the gnark fork
+func checkForkVK(vk *VerifyingKey) error {
+	if len(vk.commitments) > 1 {
+		return fmt.Errorf("the circuit has %d commitments while %s supports one, use %s instead", len(vk.commitments), FormatFork, FormatUpstream)
+	}
+	return nil
+}
 
-	var header phase2.Header
-	if err := header.Read(ph2Reader); err != nil {
+func extractVK(vk *VerifyingKey) error {
+	if err := checkForkVK(vk); err != nil {
 		return err
 	}
-
-	decPh2 := bn254.NewDecoder(ph2Reader)
-	decEvals := bn254.NewDecoder(evalsReader)
-
-	name := fmt.Sprintf("%s.vk.save", session)
-	vkFile, err := os.Create(name)
+	vkFile, err := os.Create("vk")
 	if err != nil {
 		return err
 	}
 	defer vkFile.Close()
 	vkWriter := bufio.NewWriter(vkFile)
 	defer vkWriter.Flush()
-
-	// 1. Read [α]₁
-	if err := decEvals.Decode(&vk.G1.Alpha); err != nil {
-		return err
-	}
-
-	// 2. Read [β]₁
-	if err := decEvals.Decode(&vk.G1.Beta); err != nil {
-		return err
-	}
-
-	// 3. Read [β]₂
-	if err := decEvals.Decode(&vk.G2.Beta); err != nil {
-		return err
-	}
-
-	// 4. Set [γ]₂
-	_, _, _, gammaG2 := bn254.Generators()
-	vk.G2.Gamma.Set(&gammaG2)
-
-	// 5. Read [δ]₁
-	if err := decPh2.Decode(&vk.G1.Delta); err != nil {
-		return err
-	}
-
-	// 6. Read [δ]₂
-	if err := decPh2.Decode(&vk.G2.Delta); err != nil {
-		return err
-	}
-
-	// 7. Read VKK
-	pos := int64(128*(header.Wires+1) + 12)
-	if _, err := evalsFile.Seek(pos, io.SeekStart); err != nil {
-		return err
-	}
-	evalsReader.Reset(evalsFile)
-	if err := decEvals.Decode(&vk.G1.K); err != nil {
+	if _, err := vk.writeTo(vkWriter); err != nil {
 		return err
 	}
+	return nil
+}
 
-	// 8. Setup commitment key
-	var ckk []bn254.G1Affine
-	if err := decEvals.Decode(&ckk); err != nil {
+func extractSplitVK(vk *VerifyingKey, session string) error {
+	if err := checkForkVK(vk); err != nil {
 		return err
 	}
-	vk.CommitmentKey, err = pedersen.Setup(ckk)
+	name := fmt.Sprintf("%s.vk.save", session)
+	vkFile, err := os.Create(name)
 	if err != nil {
 		return err
 	}
+	defer vkFile.Close()
+	vkWriter := bufio.NewWriter(vkFile)
+	defer vkWriter.Flush()
 	if _, err := vk.writeTo(vkWriter); err != nil {
 		return err
 	}
@@ -647,59 +667,64 @@ func extractSplitVK(phase2Path, session string) error {
 		return err
 	}
 	defer commitmentKeyFile.Close()
-	vk.CommitmentKey.WriteTo(commitmentKeyFile)
-	_, err = vk.CommitmentKey.WriteTo(commitmentKeyFile)
+	_, err = vk.CommitmentKey.writeTo(commitmentKeyFile)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func ExtractKeys(phase2Path string) error {
+// ExtractKeys extracts pk and vk from the phase 2 file and the evaluations in format
+func ExtractKeys(phase2Path, format string) error {
+	if err := CheckFormat(format); err != nil {
+		return err
+	}
 	fmt.Println("Extracting proving key")
 	if err := extractPK(phase2Path); err != nil {
 		return err
 	}
 	fmt.Println("Extracting verifying key")
-	if err := extractVK(phase2Path); err != nil {
+	vk, err := readVK(phase2Path, "evals")
+	if err != nil {
+		return err
+	}
+	if format == FormatFork {
+		err = extractVK(vk)
+	} else {
+		err = convertKeys("pk", vk)
+	}
+	if err != nil {
 		return err
 	}
 	fmt.Println("Keys have been extracted successfully")
 	return nil
 }
 
-func ExtractSplitKeys(phase2Path, session string) error {
+// ExtractSplitKeys extracts the proving key split by session and the verifying key in format.
+// Since the upstream gnark has no split layout, gnark-v0.9 keys are written to <session>.pk and <session>.vk
+// next to the split files.
+func ExtractSplitKeys(phase2Path, session, format string) error {
+	if err := CheckFormat(format); err != nil {
+		return err
+	}
 	fmt.Println("Extracting proving key")
 	if err := extractSplitPK(phase2Path, session); err != nil {
 		return err
 	}
 	fmt.Println("Extracting verifying key")
-	if err := extractSplitVK(phase2Path, session); err != nil {
-		return err
-	}
-	fmt.Println("Keys have been extracted successfully")
-	return nil
-}
-
-func ExportSol(session string) error {
-	filename := session + ".sol"
-	fmt.Printf("Exporting %s\n", filename)
-	f, _ := os.Open(session + ".vk.save")
-	verifyingKey := groth16.NewVerifyingKey(ecc.BN254)
-	_, err := verifyingKey.ReadFrom(f)
+	vk, err := readVK(phase2Path, "evals")
 	if err != nil {
-		panic(fmt.Errorf("read file error"))
+		return err
 	}
-	err = f.Close()
-	f, err = os.Create(filename)
-	if err != nil {
-		panic(err)
+	if format == FormatFork {
+		err = extractSplitVK(vk, session)
+	} else {
+		err = convertSplitKeys(session, vk)
 	}
-	err = verifyingKey.ExportSolidity(f)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Printf("%s has been extracted successfully\n", filename)
+	fmt.Println("Keys have been extracted successfully")
 	return nil
 }
 
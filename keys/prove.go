@@ -0,0 +1,240 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/bnb-chain/zkbnb-setup/phase2"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"golang.org/x/crypto/sha3"
+)
+
+// witnessJSON is the witness given to Prove, its values are listed in the order of the public,
+// then secret, variables of the circuit as decimal or 0x prefixed hexadecimal strings, or numbers
+type witnessJSON struct {
+	Public []json.Number `json:"public"`
+	Secret []json.Number `json:"secret"`
+}
+
+// Prove proves the witness JSON at witnessPath for the R1CS at r1csPath with the proving key pkPath,
+// and verifies the proof with the verifying key vkPath. The proof, the public inputs, and the calldata of
+// verifyProof of the exported Solidity verifier are written to outputDir.
+func Prove(r1csPath, pkPath, vkPath, witnessPath, outputDir string) error {
+	fmt.Println("Reading R1CS")
+	ccs, err := phase2.ReadR1CS(r1csPath)
+	if err != nil {
+		return err
+	}
+	w, err := readWitness(ccs, witnessPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Reading proving key")
+	pk := groth16.NewProvingKey(ecc.BN254)
+	pkFile, err := os.Open(pkPath)
+	if err != nil {
+		return err
+	}
+	defer pkFile.Close()
+	if _, err := pk.ReadFrom(bufio.NewReader(pkFile)); err != nil {
+		return err
+	}
+
+	fmt.Println("Proving")
+	prf, err := groth16.Prove(ccs, pk, w)
+	if err != nil {
+		return err
+	}
+	return verifyAndWriteProof(prf, vkPath, w, outputDir)
+}
+
+// ProveSplit is Prove for the parted R1CS and split proving key of session, as written by p2np and keys <session>.
+// #R1C and the batch size the R1CS was split with are read from the parts.
+func ProveSplit(session, vkPath, witnessPath, outputDir string) error {
+	fmt.Println("Reading parted R1CS")
+	split, err := phase2.ReadSplitR1CS(session)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("#R1C:=%d in parts of %d\n", split.NbR1C, split.BatchSize)
+	ccs := groth16.NewCS(ecc.BN254)
+	ccs.LoadFromSplitBinaryConcurrent(session, split.NbR1C, split.BatchSize, runtime.NumCPU())
+	w, err := readWitness(ccs, witnessPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Reading split proving key")
+	pks, err := groth16.ReadSegmentProveKey(ecc.BN254, session)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Proving")
+	prf, err := groth16.ProveRoll(ccs, pks[0], pks[1], w, session)
+	if err != nil {
+		return err
+	}
+	return verifyAndWriteProof(prf, vkPath, w, outputDir)
+}
+
+// readWitness reads the witness JSON at witnessPath and checks its size against ccs
+func readWitness(ccs constraint.ConstraintSystem, witnessPath string) (witness.Witness, error) {
+	data, err := os.ReadFile(witnessPath)
+	if err != nil {
+		return nil, err
+	}
+	var values witnessJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return nil, err
+	}
+
+	// The constant wire isn't part of the witness
+	nbPublic := ccs.GetNbPublicVariables() - 1
+	nbSecret := ccs.GetNbSecretVariables()
+	if len(values.Public) != nbPublic || len(values.Secret) != nbSecret {
+		return nil, fmt.Errorf("witness has %d public and %d secret values, expected %d and %d",
+			len(values.Public), len(values.Secret), nbPublic, nbSecret)
+	}
+
+	w, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		for _, v := range append(values.Public, values.Secret...) {
+			ch <- v.String()
+		}
+	}()
+	if err := w.Fill(nbPublic, nbSecret, ch); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// verifyAndWriteProof verifies prf against the verifying key at vkPath, then writes to outputDir
+// proof, the public witness as public.wtns and public.json, and the calldata of verifyProof as calldata
+func verifyAndWriteProof(prf groth16.Proof, vkPath string, w witness.Witness, outputDir string) error {
+	fmt.Println("Verifying")
+	vk := groth16.NewVerifyingKey(ecc.BN254)
+	vkFile, err := os.Open(vkPath)
+	if err != nil {
+		return err
+	}
+	defer vkFile.Close()
+	if _, err := vk.ReadFrom(bufio.NewReader(vkFile)); err != nil {
+		return err
+	}
+	pubWitness, err := w.Public()
+	if err != nil {
+		return err
+	}
+	if err := groth16.Verify(prf, vk, pubWitness); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+	var proofBuf bytes.Buffer
+	if _, err := prf.WriteTo(&proofBuf); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(outputDir, "proof"), func(w io.Writer) error {
+		_, err := w.Write(proofBuf.Bytes())
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(outputDir, "public.wtns"), func(w io.Writer) error {
+		_, err := pubWitness.WriteTo(w)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// Public inputs as snarkjs lists them
+	public, ok := pubWitness.Vector().(fr.Vector)
+	if !ok {
+		return errors.New("public witness isn't on bn254")
+	}
+	inputs := make([]string, len(public))
+	for i := range public {
+		inputs[i] = public[i].String()
+	}
+	if err := writeFile(filepath.Join(outputDir, "public.json"), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(inputs)
+	}); err != nil {
+		return err
+	}
+
+	a, b, c, err := readProofPoints(&proofBuf)
+	if err != nil {
+		return err
+	}
+	calldata := verifyProofCalldata(&a, &b, &c, public)
+	if err := writeFile(filepath.Join(outputDir, "calldata"), func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "0x%s\n", hex.EncodeToString(calldata))
+		return err
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("Proof has been verified and written to %s\n", outputDir)
+	return nil
+}
+
+// readProofPoints reads [A]₁, [B]₂, [C]₁ of a proof written by gnark, either compressed or raw
+func readProofPoints(reader io.Reader) (a bn254.G1Affine, b bn254.G2Affine, c bn254.G1Affine, err error) {
+	dec := bn254.NewDecoder(reader)
+	for _, v := range []interface{}{&a, &b, &c} {
+		if err = dec.Decode(v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// verifyProofCalldata returns the ABI encoded call of verifyProof(uint256[2],uint256[2][2],uint256[2],uint256[N])
+// of the contract exported by ExportSol. All arguments are static so they're encoded in place.
+func verifyProofCalldata(a *bn254.G1Affine, b *bn254.G2Affine, c *bn254.G1Affine, public fr.Vector) []byte {
+	signature := fmt.Sprintf("verifyProof(uint256[2],uint256[2][2],uint256[2],uint256[%d])", len(public))
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(signature))
+	calldata := h.Sum(nil)[:4]
+
+	// The coordinates of G2 are written as A1 then A0, as the contract expects them
+	words := []*big.Int{
+		a.X.BigInt(new(big.Int)), a.Y.BigInt(new(big.Int)),
+		b.X.A1.BigInt(new(big.Int)), b.X.A0.BigInt(new(big.Int)),
+		b.Y.A1.BigInt(new(big.Int)), b.Y.A0.BigInt(new(big.Int)),
+		c.X.BigInt(new(big.Int)), c.Y.BigInt(new(big.Int)),
+	}
+	for i := range public {
+		words = append(words, public[i].BigInt(new(big.Int)))
+	}
+	for _, word := range words {
+		calldata = append(calldata, word.FillBytes(make([]byte, 32))...)
+	}
+	return calldata
+}
@@ -0,0 +1,87 @@
+package keys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+)
+
+// snarkJSVerifyingKey is the verification_key.json format of snarkjs for groth16
+type snarkJSVerifyingKey struct {
+	Protocol string     `json:"protocol"`
+	Curve    string     `json:"curve"`
+	NPublic  int        `json:"nPublic"`
+	Alpha1   []string   `json:"vk_alpha_1"`
+	Beta2    [][]string `json:"vk_beta_2"`
+	Gamma2   [][]string `json:"vk_gamma_2"`
+	Delta2   [][]string `json:"vk_delta_2"`
+	IC       [][]string `json:"IC"`
+}
+
+// ExportSnarkJS exports the verifying key at vkPath, written in format, as snarkjs verification_key.json to outputPath
+func ExportSnarkJS(vkPath, outputPath, format string) error {
+	fmt.Printf("Exporting %s\n", outputPath)
+	vk, err := readVerifyingKey(vkPath, format)
+	if err != nil {
+		return err
+	}
+
+	// snarkjs has no notion of Pedersen commitments
+	if vk.CommitmentInfo.Is() || len(vk.commitments) > 0 {
+		return errors.New("the circuit uses a Pedersen commitment which can't be represented in snarkjs verifying key")
+	}
+	if len(vk.G1.K) == 0 {
+		return errors.New("verifying key has no K")
+	}
+
+	// K[0] corresponds to the constant wire, so it isn't counted as public input
+	jsonVK := snarkJSVerifyingKey{
+		Protocol: "groth16",
+		Curve:    "bn128",
+		NPublic:  len(vk.G1.K) - 1,
+		Alpha1:   snarkJSG1(&vk.G1.Alpha),
+		Beta2:    snarkJSG2(&vk.G2.Beta),
+		Gamma2:   snarkJSG2(&vk.G2.Gamma),
+		Delta2:   snarkJSG2(&vk.G2.Delta),
+		IC:       make([][]string, len(vk.G1.K)),
+	}
+	for i := range vk.G1.K {
+		jsonVK.IC[i] = snarkJSG1(&vk.G1.K[i])
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	enc := json.NewEncoder(outputFile)
+	enc.SetIndent("", " ")
+	if err := enc.Encode(&jsonVK); err != nil {
+		return err
+	}
+	fmt.Printf("%s has been exported successfully\n", outputPath)
+	return nil
+}
+
+// snarkJSG1 returns the projective coordinates of p as decimal strings
+func snarkJSG1(p *bn254.G1Affine) []string {
+	if p.IsInfinity() {
+		return []string{"0", "1", "0"}
+	}
+	return []string{p.X.String(), p.Y.String(), "1"}
+}
+
+// snarkJSG2 returns the projective coordinates of p as decimal strings, each coordinate as [a0, a1]
+func snarkJSG2(p *bn254.G2Affine) [][]string {
+	if p.IsInfinity() {
+		return [][]string{{"0", "0"}, {"1", "0"}, {"0", "0"}}
+	}
+	return [][]string{
+		{p.X.A0.String(), p.X.A1.String()},
+		{p.Y.A0.String(), p.Y.A1.String()},
+		{"1", "0"},
+	}
+}
@@ -0,0 +1,234 @@
+package keys
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// SolOptions customizes the verifier contract exported by ExportSol
+type SolOptions struct {
+	Name        string // name of the contract, Verifier by default
+	Pragma      string // version pragma of solidity, ^0.8.0 by default
+	LinkLibrary bool   // declare the Pairing library functions public so the library is deployed once and linked
+	EvalsPath   string // evaluations of a phase 2 input, evals by default
+
+	// If TestPath isn't empty, a Foundry test verifying the proof at ProofPath
+	// for the public witness at WitnessPath against the contract is written there
+	TestPath    string
+	ProofPath   string
+	WitnessPath string
+}
+
+// ExportSol exports the verifier contract of the verifying key at inputPath to outputPath. The input is
+// either a verifying key in the gnark fork layout or a phase 2 file (.ph2), whose verifying key is built
+// on the fly from the evaluations at opts.EvalsPath
+func ExportSol(inputPath, outputPath string, opts SolOptions) error {
+	fmt.Printf("Exporting %s\n", outputPath)
+	opts.setDefaults()
+
+	vk, err := readVerifierVK(inputPath, opts.EvalsPath)
+	if err != nil {
+		return err
+	}
+	data := struct {
+		*VerifyingKey
+		Name, Pragma, Visibility string
+	}{vk, opts.Name, opts.Pragma, opts.visibility()}
+	if err := executeTemplate(solidityTemplate, outputPath, data); err != nil {
+		return err
+	}
+
+	if opts.TestPath != "" {
+		if err := exportFoundryTest(vk, outputPath, &opts); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%s has been extracted successfully\n", outputPath)
+	return nil
+}
+
+// SolCircuit is a circuit of the contract exported by ExportMultiSol
+type SolCircuit struct {
+	ID     uint64 // ID of the circuit in the contract, e.g. the block size
+	VKPath string // verifying key in the gnark fork layout
+}
+
+// ExportMultiSol exports one verifier contract for the circuits to outputPath. The contract hardcodes their verifying keys,
+// selected by circuit ID, and shares the verification routine behind verifyProof(circuitId, proof, input)
+func ExportMultiSol(circuits []SolCircuit, outputPath string, opts SolOptions) error {
+	fmt.Printf("Exporting %s\n", outputPath)
+	opts.setDefaults()
+	if len(circuits) == 0 {
+		return errors.New("no circuit to export")
+	}
+	if opts.TestPath != "" {
+		return errors.New("the Foundry test is only supported for a single circuit")
+	}
+
+	type circuit struct {
+		ID uint64
+		*VerifyingKey
+	}
+	data := struct {
+		Name, Pragma, Visibility string
+		Circuits                 []circuit
+	}{Name: opts.Name, Pragma: opts.Pragma, Visibility: opts.visibility()}
+	ids := make(map[uint64]bool)
+	for _, c := range circuits {
+		if ids[c.ID] {
+			return fmt.Errorf("circuit ID %d is used twice", c.ID)
+		}
+		ids[c.ID] = true
+		if filepath.Ext(c.VKPath) == ".ph2" {
+			return fmt.Errorf("%s: the circuits need their own evals, extract their verifying keys first", c.VKPath)
+		}
+		vk, err := readVerifierVK(c.VKPath, "")
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.VKPath, err)
+		}
+		data.Circuits = append(data.Circuits, circuit{c.ID, vk})
+	}
+	if err := executeTemplate(solidityHeader+solidityMultiContract, outputPath, data); err != nil {
+		return err
+	}
+	fmt.Printf("%s has been extracted successfully\n", outputPath)
+	return nil
+}
+
+func (opts *SolOptions) setDefaults() {
+	if opts.Name == "" {
+		opts.Name = "Verifier"
+	}
+	if opts.Pragma == "" {
+		opts.Pragma = "^0.8.0"
+	}
+	if opts.EvalsPath == "" {
+		opts.EvalsPath = "evals"
+	}
+}
+
+// visibility returns the visibility of the Pairing library functions
+func (opts *SolOptions) visibility() string {
+	if opts.LinkLibrary {
+		return "public"
+	}
+	return "internal"
+}
+
+// readVerifierVK reads the verifying key of a generated verifier, from a phase 2 file and its evaluations or from a vk file
+func readVerifierVK(inputPath, evalsPath string) (*VerifyingKey, error) {
+	var vk *VerifyingKey
+	var err error
+	if filepath.Ext(inputPath) == ".ph2" {
+		vk, err = readVK(inputPath, evalsPath)
+	} else {
+		vk, err = readVerifyingKey(inputPath, FormatFork)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The generated verifiers have no notion of Pedersen commitments
+	if vk.CommitmentInfo.Is() || len(vk.commitments) > 0 {
+		return nil, errors.New("the circuit uses a Pedersen commitment which the generated verifier doesn't support")
+	}
+	if len(vk.G1.K) == 0 {
+		return nil, errors.New("verifying key has no K")
+	}
+	return vk, nil
+}
+
+// exportFoundryTest writes a Foundry test of the contract at solPath to opts.TestPath
+func exportFoundryTest(vk *VerifyingKey, solPath string, opts *SolOptions) error {
+	fmt.Printf("Exporting %s\n", opts.TestPath)
+	if opts.ProofPath == "" || opts.WitnessPath == "" {
+		return errors.New("the Foundry test needs a proof and its public witness")
+	}
+
+	// Proof is [A]₁, [B]₂, [C]₁ either compressed or raw
+	proofFile, err := os.Open(opts.ProofPath)
+	if err != nil {
+		return err
+	}
+	defer proofFile.Close()
+	a, b, c, err := readProofPoints(bufio.NewReader(proofFile))
+	if err != nil {
+		return err
+	}
+
+	// Public witness
+	w, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return err
+	}
+	witnessFile, err := os.Open(opts.WitnessPath)
+	if err != nil {
+		return err
+	}
+	defer witnessFile.Close()
+	if _, err := w.ReadFrom(bufio.NewReader(witnessFile)); err != nil {
+		return err
+	}
+	public, ok := w.Vector().(fr.Vector)
+	if !ok || len(public) != len(vk.G1.K)-1 {
+		return fmt.Errorf("the public witness doesn't have the %d inputs of the verifying key", len(vk.G1.K)-1)
+	}
+
+	// The test imports the contract relatively to its own directory
+	importPath, err := filepath.Rel(filepath.Dir(opts.TestPath), solPath)
+	if err != nil {
+		return err
+	}
+	importPath = filepath.ToSlash(importPath)
+	if !strings.HasPrefix(importPath, ".") {
+		importPath = "./" + importPath
+	}
+
+	// The coordinates of G2 are written as A1 then A0, as the contract expects them
+	data := struct {
+		Name, Pragma, Import, R string
+		A, B, C, Input          []string
+	}{
+		Name:   opts.Name,
+		Pragma: opts.Pragma,
+		Import: importPath,
+		R:      fr.Modulus().String(),
+		A:      []string{a.X.String(), a.Y.String()},
+		B:      []string{b.X.A1.String(), b.X.A0.String(), b.Y.A1.String(), b.Y.A0.String()},
+		C:      []string{c.X.String(), c.Y.String()},
+		Input:  make([]string, len(public)),
+	}
+	for i := range public {
+		data.Input[i] = public[i].String()
+	}
+	return executeTemplate(foundryTestTemplate, opts.TestPath, data)
+}
+
+func executeTemplate(text, path string, data interface{}) error {
+	return writeFile(path, func(w io.Writer) error {
+		return executeTemplateTo(text, w, data)
+	})
+}
+
+func executeTemplateTo(text string, w io.Writer, data interface{}) error {
+	helpers := template.FuncMap{
+		"sub": func(a, b int) int {
+			return a - b
+		},
+	}
+	tmpl, err := template.New("").Funcs(helpers).Parse(text)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
@@ -0,0 +1,477 @@
+package keys
+
+// solidityTemplate is the template of the gnark fork, based on an audited template https://github.com/appliedzkp/semaphore/blob/master/contracts/sol/verifier.sol
+// audit report https://github.com/appliedzkp/semaphore/blob/master/audit/Audit%20Report%20Summary%20for%20Semaphore%20and%20MicroMix.pdf
+// with the contract name, the pragma and the visibility of the Pairing library functions as parameters.
+// The *_raw functions write their result in memory so they stay internal even when the library is linked.
+const solidityTemplate = `
+{{- $lenK := len .G1.K }}` + solidityHeader + solidityContract
+
+// solidityHeader is the license, the pragma, and the Pairing library shared by the contracts
+const solidityHeader = `
+// SPDX-License-Identifier: AML
+//
+// Copyright 2017 Christian Reitwiessner
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+// 2019 OKIMS
+
+pragma solidity {{.Pragma}};
+
+library Pairing {
+
+    uint256 constant PRIME_Q = 21888242871839275222246405745257275088696311157297823662689037894645226208583;
+
+    struct G1Point {
+        uint256 X;
+        uint256 Y;
+    }
+
+    // Encoding of field elements is: X[0] * z + X[1]
+    struct G2Point {
+        uint256[2] X;
+        uint256[2] Y;
+    }
+
+    /*
+     * @return The negation of p, i.e. p.plus(p.negate()) should be zero.
+     */
+    function negate(G1Point memory p) {{.Visibility}} pure returns (G1Point memory) {
+
+        // The prime q in the base field F_q for G1
+        if (p.X == 0 && p.Y == 0) {
+            return G1Point(0, 0);
+        } else {
+            return G1Point(p.X, PRIME_Q - (p.Y % PRIME_Q));
+        }
+    }
+
+    /*
+     * @return The sum of two points of G1
+     */
+    function plus(
+        G1Point memory p1,
+        G1Point memory p2
+    ) {{.Visibility}} view returns (G1Point memory r) {
+
+        uint256[4] memory input;
+        input[0] = p1.X;
+        input[1] = p1.Y;
+        input[2] = p2.X;
+        input[3] = p2.Y;
+        bool success;
+
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 6, input, 0xc0, r, 0x60)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 { invalid() }
+        }
+
+        require(success,"pairing-add-failed");
+    }
+
+
+    /*
+     * Same as plus but accepts raw input instead of struct
+     * @return The sum of two points of G1, one is represented as array
+     */
+    function plus_raw(uint256[4] memory input, G1Point memory r) internal view {
+        bool success;
+
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 6, input, 0xc0, r, 0x60)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 {invalid()}
+        }
+
+        require(success, "pairing-add-failed");
+    }
+
+    /*
+     * @return The product of a point on G1 and a scalar, i.e.
+     *         p == p.scalar_mul(1) and p.plus(p) == p.scalar_mul(2) for all
+     *         points p.
+     */
+    function scalar_mul(G1Point memory p, uint256 s) {{.Visibility}} view returns (G1Point memory r) {
+
+        uint256[3] memory input;
+        input[0] = p.X;
+        input[1] = p.Y;
+        input[2] = s;
+        bool success;
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 7, input, 0x80, r, 0x60)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 { invalid() }
+        }
+        require (success,"pairing-mul-failed");
+    }
+
+
+    /*
+     * Same as scalar_mul but accepts raw input instead of struct,
+     * Which avoid extra allocation. provided input can be allocated outside and re-used multiple times
+     */
+    function scalar_mul_raw(uint256[3] memory input, G1Point memory r) internal view {
+        bool success;
+
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 7, input, 0x80, r, 0x60)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 {invalid()}
+        }
+        require(success, "pairing-mul-failed");
+    }
+
+    /* @return The result of computing the pairing check
+     *         e(p1[0], p2[0]) *  .... * e(p1[n], p2[n]) == 1
+     *         For example,
+     *         pairing([P1(), P1().negate()], [P2(), P2()]) should return true.
+     */
+    function pairing(
+        G1Point memory a1,
+        G2Point memory a2,
+        G1Point memory b1,
+        G2Point memory b2,
+        G1Point memory c1,
+        G2Point memory c2,
+        G1Point memory d1,
+        G2Point memory d2
+    ) {{.Visibility}} view returns (bool) {
+
+        G1Point[4] memory p1 = [a1, b1, c1, d1];
+        G2Point[4] memory p2 = [a2, b2, c2, d2];
+        uint256 inputSize = 24;
+        uint256[] memory input = new uint256[](inputSize);
+
+        for (uint256 i = 0; i < 4; i++) {
+            uint256 j = i * 6;
+            input[j + 0] = p1[i].X;
+            input[j + 1] = p1[i].Y;
+            input[j + 2] = p2[i].X[0];
+            input[j + 3] = p2[i].X[1];
+            input[j + 4] = p2[i].Y[0];
+            input[j + 5] = p2[i].Y[1];
+        }
+
+        uint256[1] memory out;
+        bool success;
+
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 8, add(input, 0x20), mul(inputSize, 0x20), out, 0x20)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 { invalid() }
+        }
+
+        require(success,"pairing-opcode-failed");
+
+        return out[0] != 0;
+    }
+}
+
+`
+
+// solidityContract verifies the proofs of one circuit with inputs of static length
+const solidityContract = `contract {{.Name}} {
+
+    using Pairing for *;
+
+    uint256 constant SNARK_SCALAR_FIELD = 21888242871839275222246405745257275088548364400416034343698204186575808495617;
+    uint256 constant PRIME_Q = 21888242871839275222246405745257275088696311157297823662689037894645226208583;
+
+    struct VerifyingKey {
+        Pairing.G1Point alfa1;
+        Pairing.G2Point beta2;
+        Pairing.G2Point gamma2;
+        Pairing.G2Point delta2;
+        // []G1Point IC (K in gnark) appears directly in verifyProof
+    }
+
+    struct Proof {
+        Pairing.G1Point A;
+        Pairing.G2Point B;
+        Pairing.G1Point C;
+    }
+
+    function verifyingKey() internal pure returns (VerifyingKey memory vk) {
+        vk.alfa1 = Pairing.G1Point(uint256({{.G1.Alpha.X.String}}), uint256({{.G1.Alpha.Y.String}}));
+        vk.beta2 = Pairing.G2Point([uint256({{.G2.Beta.X.A1.String}}), uint256({{.G2.Beta.X.A0.String}})], [uint256({{.G2.Beta.Y.A1.String}}), uint256({{.G2.Beta.Y.A0.String}})]);
+        vk.gamma2 = Pairing.G2Point([uint256({{.G2.Gamma.X.A1.String}}), uint256({{.G2.Gamma.X.A0.String}})], [uint256({{.G2.Gamma.Y.A1.String}}), uint256({{.G2.Gamma.Y.A0.String}})]);
+        vk.delta2 = Pairing.G2Point([uint256({{.G2.Delta.X.A1.String}}), uint256({{.G2.Delta.X.A0.String}})], [uint256({{.G2.Delta.Y.A1.String}}), uint256({{.G2.Delta.Y.A0.String}})]);
+    }
+
+
+    // accumulate scalarMul(mul_input) into q
+    // that is computes sets q = (mul_input[0:2] * mul_input[3]) + q
+    function accumulate(
+        uint256[3] memory mul_input,
+        Pairing.G1Point memory p,
+        uint256[4] memory buffer,
+        Pairing.G1Point memory q
+    ) internal view {
+        // computes p = mul_input[0:2] * mul_input[3]
+        Pairing.scalar_mul_raw(mul_input, p);
+
+        // point addition inputs
+        buffer[0] = q.X;
+        buffer[1] = q.Y;
+        buffer[2] = p.X;
+        buffer[3] = p.Y;
+
+        // q = p + q
+        Pairing.plus_raw(buffer, q);
+    }
+
+    /*
+     * @returns Whether the proof is valid given the hardcoded verifying key
+     *          above and the public inputs
+     */
+    function verifyProof(
+        uint256[2] memory a,
+        uint256[2][2] memory b,
+        uint256[2] memory c,
+        uint256[{{sub $lenK 1}}] calldata input
+    ) public view returns (bool r) {
+
+        Proof memory proof;
+        proof.A = Pairing.G1Point(a[0], a[1]);
+        proof.B = Pairing.G2Point([b[0][0], b[0][1]], [b[1][0], b[1][1]]);
+        proof.C = Pairing.G1Point(c[0], c[1]);
+
+        // Make sure that proof.A, B, and C are each less than the prime q
+        require(proof.A.X < PRIME_Q, "verifier-aX-gte-prime-q");
+        require(proof.A.Y < PRIME_Q, "verifier-aY-gte-prime-q");
+
+        require(proof.B.X[0] < PRIME_Q, "verifier-bX0-gte-prime-q");
+        require(proof.B.Y[0] < PRIME_Q, "verifier-bY0-gte-prime-q");
+
+        require(proof.B.X[1] < PRIME_Q, "verifier-bX1-gte-prime-q");
+        require(proof.B.Y[1] < PRIME_Q, "verifier-bY1-gte-prime-q");
+
+        require(proof.C.X < PRIME_Q, "verifier-cX-gte-prime-q");
+        require(proof.C.Y < PRIME_Q, "verifier-cY-gte-prime-q");
+
+        // Make sure that every input is less than the snark scalar field
+        for (uint256 i = 0; i < input.length; i++) {
+            require(input[i] < SNARK_SCALAR_FIELD,"verifier-gte-snark-scalar-field");
+        }
+
+        VerifyingKey memory vk = verifyingKey();
+
+        // Compute the linear combination vk_x
+        Pairing.G1Point memory vk_x = Pairing.G1Point(0, 0);
+
+        // Buffer reused for addition p1 + p2 to avoid memory allocations
+        // [0:2] -> p1.X, p1.Y ; [2:4] -> p2.X, p2.Y
+        uint256[4] memory add_input;
+
+        // Buffer reused for multiplication p1 * s
+        // [0:2] -> p1.X, p1.Y ; [3] -> s
+        uint256[3] memory mul_input;
+
+        // temporary point to avoid extra allocations in accumulate
+        Pairing.G1Point memory q = Pairing.G1Point(0, 0);
+
+        {{- $k0 := index .G1.K 0}}
+
+        vk_x.X = uint256({{$k0.X.String}}); // vk.K[0].X
+        vk_x.Y = uint256({{$k0.Y.String}}); // vk.K[0].Y
+
+        {{- if eq (len .G1.K) 1}}
+            // no public input, vk_x == vk.K[0]
+        {{- end}}
+        {{- range $i, $ki := .G1.K }}
+            {{- if gt $i 0 -}}
+                {{- $j := sub $i 1 }}
+        mul_input[0] = uint256({{$ki.X.String}}); // vk.K[{{$i}}].X
+        mul_input[1] = uint256({{$ki.Y.String}}); // vk.K[{{$i}}].Y
+        mul_input[2] = input[{{$j}}];
+        accumulate(mul_input, q, add_input, vk_x); // vk_x += vk.K[{{$i}}] * input[{{$j}}]
+            {{- end -}}
+        {{- end }}
+
+        return Pairing.pairing(
+            Pairing.negate(proof.A),
+            proof.B,
+            vk.alfa1,
+            vk.beta2,
+            vk_x,
+            vk.gamma2,
+            proof.C,
+            vk.delta2
+        );
+    }
+}
+`
+
+// solidityMultiContract verifies the proofs of several circuits, whose verifying keys are selected by circuit ID.
+// The proof is passed as A.X, A.Y, B.X[0], B.X[1], B.Y[0], B.Y[1], C.X, C.Y as the coordinates of verifyProof of solidityContract
+const solidityMultiContract = `contract {{.Name}} {
+
+    using Pairing for *;
+
+    uint256 constant SNARK_SCALAR_FIELD = 21888242871839275222246405745257275088548364400416034343698204186575808495617;
+    uint256 constant PRIME_Q = 21888242871839275222246405745257275088696311157297823662689037894645226208583;
+
+    struct VerifyingKey {
+        Pairing.G1Point alfa1;
+        Pairing.G2Point beta2;
+        Pairing.G2Point gamma2;
+        Pairing.G2Point delta2;
+        Pairing.G1Point[] IC; // K in gnark
+    }
+
+    /*
+     * @returns The hardcoded verifying key of circuitId, reverts if the circuit is unknown
+     */
+    function verifyingKey(uint256 circuitId) internal pure returns (VerifyingKey memory vk) {
+        {{- range $n, $c := .Circuits}}
+        {{if $n}}} else {{end}}if (circuitId == {{$c.ID}}) {
+            vk.alfa1 = Pairing.G1Point(uint256({{$c.G1.Alpha.X.String}}), uint256({{$c.G1.Alpha.Y.String}}));
+            vk.beta2 = Pairing.G2Point([uint256({{$c.G2.Beta.X.A1.String}}), uint256({{$c.G2.Beta.X.A0.String}})], [uint256({{$c.G2.Beta.Y.A1.String}}), uint256({{$c.G2.Beta.Y.A0.String}})]);
+            vk.gamma2 = Pairing.G2Point([uint256({{$c.G2.Gamma.X.A1.String}}), uint256({{$c.G2.Gamma.X.A0.String}})], [uint256({{$c.G2.Gamma.Y.A1.String}}), uint256({{$c.G2.Gamma.Y.A0.String}})]);
+            vk.delta2 = Pairing.G2Point([uint256({{$c.G2.Delta.X.A1.String}}), uint256({{$c.G2.Delta.X.A0.String}})], [uint256({{$c.G2.Delta.Y.A1.String}}), uint256({{$c.G2.Delta.Y.A0.String}})]);
+            vk.IC = new Pairing.G1Point[]({{len $c.G1.K}});
+            {{- range $i, $ki := $c.G1.K}}
+            vk.IC[{{$i}}] = Pairing.G1Point(uint256({{$ki.X.String}}), uint256({{$ki.Y.String}}));
+            {{- end}}
+        {{- end}}
+        } else {
+            revert("verifier-unknown-circuit");
+        }
+    }
+
+    // accumulate scalarMul(mul_input) into q
+    // that is computes sets q = (mul_input[0:2] * mul_input[3]) + q
+    function accumulate(
+        uint256[3] memory mul_input,
+        Pairing.G1Point memory p,
+        uint256[4] memory buffer,
+        Pairing.G1Point memory q
+    ) internal view {
+        // computes p = mul_input[0:2] * mul_input[3]
+        Pairing.scalar_mul_raw(mul_input, p);
+
+        // point addition inputs
+        buffer[0] = q.X;
+        buffer[1] = q.Y;
+        buffer[2] = p.X;
+        buffer[3] = p.Y;
+
+        // q = p + q
+        Pairing.plus_raw(buffer, q);
+    }
+
+    /*
+     * @returns Whether the proof is valid given the hardcoded verifying key
+     *          of circuitId above and the public inputs
+     */
+    function verifyProof(
+        uint256 circuitId,
+        uint256[8] calldata proof,
+        uint256[] calldata input
+    ) public view returns (bool r) {
+
+        // Make sure that proof.A, B, and C are each less than the prime q
+        for (uint256 i = 0; i < proof.length; i++) {
+            require(proof[i] < PRIME_Q, "verifier-proof-gte-prime-q");
+        }
+
+        // Make sure that every input is less than the snark scalar field
+        for (uint256 i = 0; i < input.length; i++) {
+            require(input[i] < SNARK_SCALAR_FIELD,"verifier-gte-snark-scalar-field");
+        }
+
+        VerifyingKey memory vk = verifyingKey(circuitId);
+        require(input.length + 1 == vk.IC.length, "verifier-bad-input-length");
+
+        // Compute the linear combination vk_x
+        Pairing.G1Point memory vk_x = Pairing.G1Point(vk.IC[0].X, vk.IC[0].Y);
+
+        // Scoped so that the buffers don't overflow the stack of the pairing call below
+        {
+            // Buffer reused for addition p1 + p2 to avoid memory allocations
+            // [0:2] -> p1.X, p1.Y ; [2:4] -> p2.X, p2.Y
+            uint256[4] memory add_input;
+
+            // Buffer reused for multiplication p1 * s
+            // [0:2] -> p1.X, p1.Y ; [3] -> s
+            uint256[3] memory mul_input;
+
+            // temporary point to avoid extra allocations in accumulate
+            Pairing.G1Point memory q = Pairing.G1Point(0, 0);
+
+            for (uint256 i = 0; i < input.length; i++) {
+                mul_input[0] = vk.IC[i + 1].X;
+                mul_input[1] = vk.IC[i + 1].Y;
+                mul_input[2] = input[i];
+                accumulate(mul_input, q, add_input, vk_x); // vk_x += vk.IC[i + 1] * input[i]
+            }
+        }
+
+        return Pairing.pairing(
+            Pairing.negate(Pairing.G1Point(proof[0], proof[1])),
+            Pairing.G2Point([proof[2], proof[3]], [proof[4], proof[5]]),
+            vk.alfa1,
+            vk.beta2,
+            vk_x,
+            vk.gamma2,
+            Pairing.G1Point(proof[6], proof[7]),
+            vk.delta2
+        );
+    }
+}
+`
+
+// foundryTestTemplate is a Foundry test verifying a sample proof against the contract,
+// and checking that the proof is rejected once the first public input is changed
+const foundryTestTemplate = `// SPDX-License-Identifier: AML
+pragma solidity {{.Pragma}};
+
+import "forge-std/Test.sol";
+import "{{.Import}}";
+
+contract {{.Name}}Test is Test {
+    {{.Name}} verifier;
+
+    uint256[2] a = [uint256({{index .A 0}}), uint256({{index .A 1}})];
+    uint256[2][2] b = [[uint256({{index .B 0}}), uint256({{index .B 1}})], [uint256({{index .B 2}}), uint256({{index .B 3}})]];
+    uint256[2] c = [uint256({{index .C 0}}), uint256({{index .C 1}})];
+    uint256[{{len .Input}}] input{{if .Input}} = [{{range $i, $v := .Input}}{{if $i}}, {{end}}uint256({{$v}}){{end}}]{{end}};
+
+    function setUp() public {
+        verifier = new {{.Name}}();
+    }
+
+    function testVerifyProof() public {
+        assertTrue(verifier.verifyProof(a, b, c, input));
+    }
+{{- if .Input}}
+
+    function testRejectWrongInput() public {
+        uint256[{{len .Input}}] memory wrong = input;
+        wrong[0] = addmod(wrong[0], 1, {{.R}});
+        assertFalse(verifier.verifyProof(a, b, c, wrong));
+    }
+{{- end}}
+}
+`
@@ -0,0 +1,439 @@
+package keys
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bnb-chain/zkbnb-setup/common"
+	"github.com/bnb-chain/zkbnb-setup/phase2"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+// provingKey mirrors the proving key as written by extractPK and extractSplitPK
+type provingKey struct {
+	Domain uint64
+	G1     struct {
+		Alpha, Beta, Delta bn254.G1Affine
+		A, B, Z, K         []bn254.G1Affine
+	}
+	G2 struct {
+		Beta, Delta bn254.G2Affine
+		B           []bn254.G2Affine
+	}
+	NbWires, NbInfinityA, NbInfinityB uint64
+	InfinityA, InfinityB              []bool
+}
+
+// parameters of phase 2 the keys are extracted from
+type parameters struct {
+	Header phase2.Header
+	G1     struct {
+		Delta bn254.G1Affine
+		Z, K  []bn254.G1Affine
+	}
+	G2 struct {
+		Delta bn254.G2Affine
+	}
+}
+
+func (pk *provingKey) readFrom(reader io.Reader) error {
+	dec := bn254.NewDecoder(reader)
+
+	// Domain is decoded without its precomputed twiddle factors
+	var cardinalityInv, generator, generatorInv, frMultiplicativeGen, frMultiplicativeGenInv fr.Element
+	toDecode := []interface{}{
+		&pk.Domain,
+		&cardinalityInv,
+		&generator,
+		&generatorInv,
+		&frMultiplicativeGen,
+		&frMultiplicativeGenInv,
+		&pk.G1.Alpha,
+		&pk.G1.Beta,
+		&pk.G1.Delta,
+		&pk.G1.A,
+		&pk.G1.B,
+		&pk.G1.Z,
+		&pk.G1.K,
+		&pk.G2.Beta,
+		&pk.G2.Delta,
+		&pk.G2.B,
+		&pk.NbWires,
+		&pk.NbInfinityA,
+		&pk.NbInfinityB,
+	}
+	for _, v := range toDecode {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	}
+
+	return pk.readInfinity(dec)
+}
+
+func (pk *provingKey) readSplit(session string) error {
+	// Points are raw encoded in the split files
+	decodeFile := func(suffix string, toDecode ...interface{}) error {
+		file, err := os.Open(fmt.Sprintf("%s.pk.%s.save", session, suffix))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		dec := bn254.NewDecoder(bufio.NewReader(file))
+		for _, v := range toDecode {
+			if err := dec.Decode(v); err != nil {
+				return err
+			}
+		}
+		if suffix == "E" {
+			return pk.readInfinity(dec)
+		}
+		return nil
+	}
+
+	if err := decodeFile("E", &pk.Domain, &pk.G1.Alpha, &pk.G1.Beta, &pk.G1.Delta, &pk.G2.Beta, &pk.G2.Delta,
+		&pk.NbWires, &pk.NbInfinityA, &pk.NbInfinityB); err != nil {
+		return err
+	}
+	if err := decodeFile("A", &pk.G1.A); err != nil {
+		return err
+	}
+	if err := decodeFile("B1", &pk.G1.B); err != nil {
+		return err
+	}
+	if err := decodeFile("Z", &pk.G1.Z); err != nil {
+		return err
+	}
+	if err := decodeFile("K", &pk.G1.K); err != nil {
+		return err
+	}
+	return decodeFile("B2", &pk.G2.B)
+}
+
+// readInfinity reads the infinity bitmaps which aren't prefixed by their length
+func (pk *provingKey) readInfinity(dec *bn254.Decoder) error {
+	pk.InfinityA = make([]bool, pk.NbWires)
+	if err := dec.Decode(&pk.InfinityA); err != nil {
+		return err
+	}
+	pk.InfinityB = make([]bool, pk.NbWires)
+	if err := dec.Decode(&pk.InfinityB); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (vk *VerifyingKey) readFrom(reader io.Reader) error {
+	if err := vk.CommitmentKey.readFrom(reader); err != nil {
+		return err
+	}
+	dec := bn254.NewDecoder(reader)
+	toDecode := []interface{}{
+		&vk.G1.Alpha,
+		&vk.G1.Beta,
+		&vk.G2.Beta,
+		&vk.G2.Gamma,
+		&vk.G1.Delta,
+		&vk.G2.Delta,
+		&vk.G1.K,
+	}
+	for _, v := range toDecode {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	}
+	decGob := gob.NewDecoder(reader)
+	return decGob.Decode(&vk.CommitmentInfo)
+}
+
+func (p *parameters) readFrom(reader *bufio.Reader) error {
+	if err := p.Header.Read(reader); err != nil {
+		return err
+	}
+	dec := bn254.NewDecoder(reader)
+	if err := dec.Decode(&p.G1.Delta); err != nil {
+		return err
+	}
+	if err := dec.Decode(&p.G2.Delta); err != nil {
+		return err
+	}
+	p.G1.Z = make([]bn254.G1Affine, p.Header.Domain-1)
+	for i := range p.G1.Z {
+		if err := dec.Decode(&p.G1.Z[i]); err != nil {
+			return err
+		}
+	}
+	p.G1.K = make([]bn254.G1Affine, p.Header.Witness)
+	for i := range p.G1.K {
+		if err := dec.Decode(&p.G1.K[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyKeys checks that the proving and verifying keys extracted by ExtractKeys belong together.
+// If phase2Path isn't empty, Z and K are checked against [δ]₂ with those of the phase 2 file, any state of the
+// ceremony the keys are extracted from, and compared to them if it's the last one. If evalsPath isn't empty as well,
+// the other points are compared to the evaluations.
+func VerifyKeys(pkPath, vkPath, phase2Path, evalsPath string) error {
+	var pk provingKey
+	fmt.Println("Reading proving key")
+	pkFile, err := os.Open(pkPath)
+	if err != nil {
+		return err
+	}
+	defer pkFile.Close()
+	if err := pk.readFrom(bufio.NewReader(pkFile)); err != nil {
+		return err
+	}
+	return verifyKeys(&pk, vkPath, phase2Path, evalsPath)
+}
+
+// VerifySplitKeys is VerifyKeys for the split proving and verifying keys extracted by ExtractSplitKeys
+func VerifySplitKeys(session, phase2Path, evalsPath string) error {
+	var pk provingKey
+	fmt.Println("Reading proving key")
+	if err := pk.readSplit(session); err != nil {
+		return err
+	}
+	return verifyKeys(&pk, fmt.Sprintf("%s.vk.save", session), phase2Path, evalsPath)
+}
+
+func verifyKeys(pk *provingKey, vkPath, phase2Path, evalsPath string) error {
+	var vk VerifyingKey
+	fmt.Println("Reading verifying key")
+	vkFile, err := os.Open(vkPath)
+	if err != nil {
+		return err
+	}
+	defer vkFile.Close()
+	if err := vk.readFrom(bufio.NewReader(vkFile)); err != nil {
+		return err
+	}
+
+	_, _, g1, g2 := bn254.Generators()
+
+	// [α]₁, [β]₁, [β]₂, [δ]₁, [δ]₂
+	fmt.Println("Verifying [α]₁, [β]₁, [β]₂, [δ]₁, and [δ]₂")
+	if !pk.G1.Alpha.Equal(&vk.G1.Alpha) {
+		return errors.New("[α]₁ of proving and verifying keys mismatch")
+	}
+	if !pk.G1.Beta.Equal(&vk.G1.Beta) || !pk.G2.Beta.Equal(&vk.G2.Beta) {
+		return errors.New("[β] of proving and verifying keys mismatch")
+	}
+	if !pk.G1.Delta.Equal(&vk.G1.Delta) || !pk.G2.Delta.Equal(&vk.G2.Delta) {
+		return errors.New("[δ] of proving and verifying keys mismatch")
+	}
+	if !common.SameRatio(pk.G1.Beta, g1, g2, pk.G2.Beta) {
+		return errors.New("[β]₁ and [β]₂ are inconsistent")
+	}
+	if !common.SameRatio(pk.G1.Delta, g1, g2, pk.G2.Delta) {
+		return errors.New("[δ]₁ and [δ]₂ are inconsistent")
+	}
+	if !vk.G2.Gamma.Equal(&g2) {
+		return errors.New("[γ]₂ isn't the generator")
+	}
+
+	// Infinity bitmaps
+	fmt.Println("Verifying infinity bitmaps of A and B")
+	if err := verifyInfinity(pk.InfinityA, pk.NbInfinityA, len(pk.G1.A), "A"); err != nil {
+		return err
+	}
+	if err := verifyInfinity(pk.InfinityB, pk.NbInfinityB, len(pk.G1.B), "B₁"); err != nil {
+		return err
+	}
+	if err := verifyInfinity(pk.InfinityB, pk.NbInfinityB, len(pk.G2.B), "B₂"); err != nil {
+		return err
+	}
+	if uint64(len(pk.G1.Z)) != pk.Domain-1 {
+		return fmt.Errorf("Z has %d points but the domain size is %d", len(pk.G1.Z), pk.Domain)
+	}
+
+	// [B]₁ and [B]₂
+	fmt.Println("Verifying [B]₁ and [B]₂")
+	if len(pk.G1.B) != len(pk.G2.B) {
+		return errors.New("[B]₁ and [B]₂ lengths mismatch")
+	}
+	r := randomScalars(len(pk.G1.B))
+	var b1 bn254.G1Affine
+	var b2 bn254.G2Affine
+	if _, err := b1.MultiExp(pk.G1.B, r, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+	if _, err := b2.MultiExp(pk.G2.B, r, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+	if !common.SameRatio(b1, g1, g2, b2) {
+		return errors.New("[B]₁ and [B]₂ are inconsistent")
+	}
+
+	if phase2Path == "" {
+		fmt.Println("Skipping verification of Z and K against phase 2")
+		fmt.Println("Keys verification has been successful")
+		return nil
+	}
+
+	// Phase 2 parameters
+	fmt.Println("Reading phase 2 parameters")
+	var params parameters
+	phase2File, err := os.Open(phase2Path)
+	if err != nil {
+		return err
+	}
+	defer phase2File.Close()
+	if err := params.readFrom(bufio.NewReader(phase2File)); err != nil {
+		return err
+	}
+	if pk.NbWires != uint64(params.Header.Wires) || pk.Domain != uint64(params.Header.Domain) ||
+		len(pk.G1.K) != params.Header.Witness || len(vk.G1.K) != params.Header.Public {
+		return errors.New("sizes of the keys mismatch the phase 2 header")
+	}
+
+	// Each contribution divides Z and K by its δ, so they agree with any state of phase 2
+	// iff e(Σrᵢ·pkᵢ, [δ]₂) = e(Σrᵢ·ph2ᵢ, [δ']₂)
+	fmt.Println("Verifying Z and K against [δ]₂")
+	var batch common.RatioBatch
+	l1, l2, err := linearCombination(pk.G1.Z, params.G1.Z)
+	if err != nil {
+		return err
+	}
+	batch.Add(l1, l2, pk.G2.Delta, params.G2.Delta, errors.New("Z is inconsistent with [δ]"))
+	if l1, l2, err = linearCombination(pk.G1.K, params.G1.K); err != nil {
+		return err
+	}
+	batch.Add(l1, l2, pk.G2.Delta, params.G2.Delta, errors.New("K is inconsistent with [δ]"))
+	if err := batch.Verify(); err != nil {
+		return err
+	}
+
+	// The keys are extracted from the last state, which has the same δ
+	if pk.G1.Delta.Equal(&params.G1.Delta) && pk.G2.Delta.Equal(&params.G2.Delta) {
+		fmt.Println("Comparing Z and K with phase 2")
+		if err := sameG1(pk.G1.Z, params.G1.Z, "Z"); err != nil {
+			return err
+		}
+		if err := sameG1(pk.G1.K, params.G1.K, "K"); err != nil {
+			return err
+		}
+	}
+
+	if evalsPath == "" {
+		fmt.Println("Skipping comparison with the evaluations")
+		fmt.Println("Keys verification has been successful")
+		return nil
+	}
+
+	// The other points are the evaluations, A and B without the points at infinity
+	fmt.Println("Verifying [α]₁, [β]₁, [β]₂, A, B, and VKK against the evaluations")
+	evals, err := readEvals(evalsPath, &params.Header)
+	if err != nil {
+		return err
+	}
+	if !pk.G1.Alpha.Equal(&evals.G1.Alpha) || !pk.G1.Beta.Equal(&evals.G1.Beta) || !pk.G2.Beta.Equal(&evals.G2.Beta) {
+		return errors.New("[α] or [β] of the keys mismatches the evaluations")
+	}
+	A, infinityA, _ := filterInfinityG1(evals.G1.A)
+	if err := sameG1(pk.G1.A, A, "A"); err != nil {
+		return err
+	}
+	if err := sameInfinity(pk.InfinityA, infinityA, "A"); err != nil {
+		return err
+	}
+	B1, infinityB, _ := filterInfinityG1(evals.G1.B)
+	if err := sameG1(pk.G1.B, B1, "B₁"); err != nil {
+		return err
+	}
+	if err := sameInfinity(pk.InfinityB, infinityB, "B"); err != nil {
+		return err
+	}
+	B2, _, _ := filterInfinityG2(evals.G2.B)
+	if len(pk.G2.B) != len(B2) {
+		return fmt.Errorf("B₂ has %d points, expected %d from the evaluations", len(pk.G2.B), len(B2))
+	}
+	for i := range B2 {
+		if !pk.G2.B[i].Equal(&B2[i]) {
+			return fmt.Errorf("B₂ mismatches the evaluations at %d", i)
+		}
+	}
+	if err := sameG1(vk.G1.K, evals.G1.VKK, "K of the verifying key"); err != nil {
+		return err
+	}
+
+	fmt.Println("Keys verification has been successful")
+	return nil
+}
+
+func verifyInfinity(infinity []bool, nbInfinity uint64, nbPoints int, field string) error {
+	var count uint64
+	for _, inf := range infinity {
+		if inf {
+			count++
+		}
+	}
+	if count != nbInfinity {
+		return fmt.Errorf("infinity bitmap of %s has %d points at infinity, expected %d", field, count, nbInfinity)
+	}
+	if uint64(nbPoints) != uint64(len(infinity))-nbInfinity {
+		return fmt.Errorf("%s has %d points, expected %d", field, nbPoints, uint64(len(infinity))-nbInfinity)
+	}
+	return nil
+}
+
+// linearCombination returns Σrᵢ·aᵢ and Σrᵢ·bᵢ using the same random rᵢ
+func linearCombination(a, b []bn254.G1Affine) (bn254.G1Affine, bn254.G1Affine, error) {
+	var la, lb bn254.G1Affine
+	if len(a) != len(b) {
+		return la, lb, fmt.Errorf("lengths mismatch %d != %d", len(a), len(b))
+	}
+	r := randomScalars(len(a))
+	if _, err := la.MultiExp(a, r, ecc.MultiExpConfig{}); err != nil {
+		return la, lb, err
+	}
+	if _, err := lb.MultiExp(b, r, ecc.MultiExpConfig{}); err != nil {
+		return la, lb, err
+	}
+	return la, lb, nil
+}
+
+// sameG1 returns an error if the points of the keys aren't the expected ones
+func sameG1(points, expected []bn254.G1Affine, field string) error {
+	if len(points) != len(expected) {
+		return fmt.Errorf("%s has %d points, expected %d", field, len(points), len(expected))
+	}
+	for i := range points {
+		if !points[i].Equal(&expected[i]) {
+			return fmt.Errorf("%s mismatches at %d", field, i)
+		}
+	}
+	return nil
+}
+
+// sameInfinity returns an error if the infinity bitmap of the keys isn't the expected one
+func sameInfinity(infinity, expected []bool, field string) error {
+	if len(infinity) != len(expected) {
+		return fmt.Errorf("infinity bitmap of %s has %d wires, expected %d", field, len(infinity), len(expected))
+	}
+	for i := range infinity {
+		if infinity[i] != expected[i] {
+			return fmt.Errorf("infinity bitmap of %s mismatches at %d", field, i)
+		}
+	}
+	return nil
+}
+
+func randomScalars(n int) []fr.Element {
+	r := make([]fr.Element, n)
+	common.Parallelize(n, func(start, end int) {
+		for i := start; i < end; i++ {
+			r[i].SetRandom()
+		}
+	})
+	return r
+}
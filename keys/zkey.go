@@ -0,0 +1,419 @@
+package keys
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/bnb-chain/zkbnb-setup/common"
+	"github.com/bnb-chain/zkbnb-setup/lagrange"
+	"github.com/bnb-chain/zkbnb-setup/phase2"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// Sections of the snarkjs zkey format for groth16
+const (
+	zkeyHeader uint32 = iota + 1
+	zkeyGroth16Header
+	zkeyIC
+	zkeyCoefficients
+	zkeyA
+	zkeyB1
+	zkeyB2
+	zkeyC
+	zkeyH
+)
+
+// zkeyWriter writes the sections of a zkey file, the size of each section is patched once it's written
+type zkeyWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// ExportZKey exports the proving key of the phase 2 state at phase2Path and its evaluations at evalsPath
+// as snarkjs zkey to zkeyPath, reading the coefficients of the circuit from r1csPath.
+// The contributions of phase 2 aren't exported: snarkjs verifies its own transcript of contributions from the
+// circuit hash, which phase 2 doesn't compute, so the zkey has no MPC section and can't be checked by zkey verify.
+func ExportZKey(phase2Path, evalsPath, r1csPath, zkeyPath string) error {
+	fmt.Printf("Exporting %s\n", zkeyPath)
+
+	// Read R1CS File
+	r1cs, err := phase2.ReadR1CS(r1csPath)
+	if err != nil {
+		return err
+	}
+
+	// Phase 2 file
+	phase2File, err := os.Open(phase2Path)
+	if err != nil {
+		return err
+	}
+	defer phase2File.Close()
+	ph2Reader := bufio.NewReader(phase2File)
+	var header phase2.Header
+	if err := header.Read(ph2Reader); err != nil {
+		return err
+	}
+	if header.Constraints != len(r1cs.Constraints) || header.Wires != r1cs.NbInternalVariables+r1cs.GetNbPublicVariables()+r1cs.GetNbSecretVariables() {
+		return errors.New("the circuit doesn't match phase 2 parameters")
+	}
+
+	// snarkjs has no notion of Pedersen commitments, which phase 2 counts as public wires
+	if header.Public != r1cs.GetNbPublicVariables() {
+		return errors.New("the circuit uses a Pedersen commitment which can't be represented in snarkjs zkey")
+	}
+
+	// Read [δ]₁, [δ]₂, Z, and PKK
+	var deltaG1 bn254.G1Affine
+	var deltaG2 bn254.G2Affine
+	decPh2 := bn254.NewDecoder(ph2Reader)
+	if err := decPh2.Decode(&deltaG1); err != nil {
+		return err
+	}
+	if err := decPh2.Decode(&deltaG2); err != nil {
+		return err
+	}
+	batchPh2 := common.NewBatchDecoder(ph2Reader)
+	Z := make([]bn254.G1Affine, header.Domain)
+	for i := 0; i < header.Domain-1; i++ {
+		if err := batchPh2.Decode(&Z[i]); err != nil {
+			return err
+		}
+	}
+	PKK := make([]bn254.G1Affine, header.Witness)
+	for i := 0; i < header.Witness; i++ {
+		if err := batchPh2.Decode(&PKK[i]); err != nil {
+			return err
+		}
+	}
+	if err := common.CheckG1(Z); err != nil {
+		return err
+	}
+	if err := common.CheckG1(PKK); err != nil {
+		return err
+	}
+
+	// Evaluations
+	evalsFile, err := os.Open(evalsPath)
+	if err != nil {
+		return err
+	}
+	defer evalsFile.Close()
+	if err := checkEvals(evalsFile, &header); err != nil {
+		return err
+	}
+	var alphaG1, betaG1 bn254.G1Affine
+	var betaG2 bn254.G2Affine
+	var A, B1, VKK []bn254.G1Affine
+	var B2 []bn254.G2Affine
+	evalsReader := bufio.NewReader(evalsFile)
+	decEvals := bn254.NewDecoder(evalsReader)
+	toDecode := []interface{}{
+		&alphaG1,
+		&betaG1,
+		&betaG2,
+	}
+	for _, v := range toDecode {
+		if err := decEvals.Decode(v); err != nil {
+			return err
+		}
+	}
+
+	batchEvals := common.NewBatchDecoder(evalsReader)
+	toDecode = []interface{}{
+		&A,
+		&B1,
+		&B2,
+		&VKK,
+	}
+	for _, v := range toDecode {
+		if err := batchEvals.Decode(v); err != nil {
+			return err
+		}
+	}
+	for _, points := range [][]bn254.G1Affine{A, B1, VKK} {
+		if err := common.CheckG1(points); err != nil {
+			return err
+		}
+	}
+	if err := common.CheckG2(B2); err != nil {
+		return err
+	}
+
+	zkeyFile, err := os.Create(zkeyPath)
+	if err != nil {
+		return err
+	}
+	defer zkeyFile.Close()
+	w := &zkeyWriter{file: zkeyFile, writer: bufio.NewWriter(zkeyFile)}
+
+	// "zkey", version, #sections
+	if _, err := w.writer.WriteString("zkey"); err != nil {
+		return err
+	}
+	if err := w.writeUint32(1, zkeyH); err != nil {
+		return err
+	}
+
+	// 1. Protocol: groth16
+	if err := w.section(zkeyHeader, func() error {
+		return w.writeUint32(1)
+	}); err != nil {
+		return err
+	}
+
+	// 2. Groth16 header
+	// K[0] corresponds to the constant wire, so it isn't counted as public input
+	nPublic := header.Public - 1
+	_, _, _, gammaG2 := bn254.Generators()
+	if err := w.section(zkeyGroth16Header, func() error {
+		if err := w.writeUint32(fp.Bytes); err != nil {
+			return err
+		}
+		if err := w.writeModulus(fp.Modulus()); err != nil {
+			return err
+		}
+		if err := w.writeUint32(fr.Bytes); err != nil {
+			return err
+		}
+		if err := w.writeModulus(fr.Modulus()); err != nil {
+			return err
+		}
+		if err := w.writeUint32(uint32(header.Wires), uint32(nPublic), uint32(header.Domain)); err != nil {
+			return err
+		}
+		if err := w.writeG1(&alphaG1, &betaG1); err != nil {
+			return err
+		}
+		if err := w.writeG2(&betaG2, &gammaG2); err != nil {
+			return err
+		}
+		if err := w.writeG1(&deltaG1); err != nil {
+			return err
+		}
+		return w.writeG2(&deltaG2)
+	}); err != nil {
+		return err
+	}
+
+	// 3. IC
+	if err := w.section(zkeyIC, func() error {
+		return w.writeG1(pointersG1(VKK)...)
+	}); err != nil {
+		return err
+	}
+
+	// 4. Coefficients of A and B, C is computed by the prover as A∘B
+	fmt.Println("Processing coefficients")
+	if err := w.section(zkeyCoefficients, func() error {
+		return w.writeCoefficients(r1cs)
+	}); err != nil {
+		return err
+	}
+
+	// 5. A, 6. B₁, 7. B₂
+	if err := w.section(zkeyA, func() error {
+		return w.writeG1(pointersG1(A)...)
+	}); err != nil {
+		return err
+	}
+	if err := w.section(zkeyB1, func() error {
+		return w.writeG1(pointersG1(B1)...)
+	}); err != nil {
+		return err
+	}
+	if err := w.section(zkeyB2, func() error {
+		return w.writeG2(pointersG2(B2)...)
+	}); err != nil {
+		return err
+	}
+
+	// 8. C of private wires
+	if err := w.section(zkeyC, func() error {
+		return w.writeG1(pointersG1(PKK)...)
+	}); err != nil {
+		return err
+	}
+
+	// 9. H
+	fmt.Println("Processing H")
+	H := computeH(Z)
+	if err := w.section(zkeyH, func() error {
+		return w.writeG1(pointersG1(H)...)
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s has been exported successfully\n", zkeyPath)
+	return nil
+}
+
+// computeH converts Z, as stored in phase 2, into the H section of zkey.
+// Z holds [τⁱ(τⁿ-1)/δ]₁ in bit-reversed order, whereas snarkjs evaluates the quotient
+// on the odd powers of the 2n-th root of unity ω₂ₙ and expects Hₖ = [L₂ₖ₊₁(τ)/δ]₁ of the domain of size 2n.
+// Since (τⁿ-1) is -2 on these points, Hₖ = -½·(1/n)·∑ᵢ ω⁻ⁱᵏ·ω₂ₙ⁻ⁱ·Zᵢ
+func computeH(Z []bn254.G1Affine) []bn254.G1Affine {
+	n := len(Z)
+	H := make([]bn254.G1Affine, n)
+	copy(H, Z)
+	common.BitReverseG1(H)
+
+	// Scale Zᵢ by -½·ω₂ₙ⁻ⁱ
+	var shift, scale fr.Element
+	shift.Inverse(&fft.NewDomain(uint64(2 * n)).Generator)
+	scale.SetUint64(2).Inverse(&scale).Neg(&scale)
+	scales := make([]fr.Element, n)
+	for i := 0; i < n; i++ {
+		scales[i] = scale
+		scale.Mul(&scale, &shift)
+	}
+	common.Parallelize(n, func(start, end int) {
+		var s big.Int
+		for i := start; i < end; i++ {
+			scales[i].BigInt(&s)
+			H[i].ScalarMultiplication(&H[i], &s)
+		}
+	})
+
+	lagrange.ConvertG1(H, fft.NewDomain(uint64(n)))
+	return H
+}
+
+// section writes a section of sectionType whose content is written by write
+func (w *zkeyWriter) section(sectionType uint32, write func() error) error {
+	if err := w.writeUint32(sectionType); err != nil {
+		return err
+	}
+	if err := binary.Write(w.writer, binary.LittleEndian, uint64(0)); err != nil {
+		return err
+	}
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	start, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if err := write(); err != nil {
+		return err
+	}
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	end, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	// Patch the size of the section
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(end-start))
+	_, err = w.file.WriteAt(size[:], start-8)
+	return err
+}
+
+func (w *zkeyWriter) writeUint32(values ...uint32) error {
+	for _, v := range values {
+		if err := binary.Write(w.writer, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeModulus writes m in little-endian
+func (w *zkeyWriter) writeModulus(m *big.Int) error {
+	var buf [32]byte
+	m.FillBytes(buf[:])
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+	_, err := w.writer.Write(buf[:])
+	return err
+}
+
+// writeG1 writes the coordinates in Montgomery form as little-endian, infinity is (0, 0)
+func (w *zkeyWriter) writeG1(points ...*bn254.G1Affine) error {
+	for _, p := range points {
+		if err := binary.Write(w.writer, binary.LittleEndian, [2]fp.Element{p.X, p.Y}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeG2 writes the coordinates in Montgomery form as little-endian, infinity is (0, 0)
+func (w *zkeyWriter) writeG2(points ...*bn254.G2Affine) error {
+	for _, p := range points {
+		if err := binary.Write(w.writer, binary.LittleEndian, [4]fp.Element{p.X.A0, p.X.A1, p.Y.A0, p.Y.A1}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCoefficients writes the non-zero terms of A and B as (matrix, constraint, signal, value).
+// snarkjs multiplies the values with the witness in Montgomery form, so the value is written as coefficient·R² mod r
+func (w *zkeyWriter) writeCoefficients(r1cs *cs_bn254.R1CS) error {
+	nbCoefficients := 0
+	for _, c := range r1cs.Constraints {
+		for _, terms := range []constraint.LinearExpression{c.L, c.R} {
+			for _, t := range terms {
+				if t.CoeffID() != constraint.CoeffIdZero {
+					nbCoefficients++
+				}
+			}
+		}
+	}
+	if err := w.writeUint32(uint32(nbCoefficients)); err != nil {
+		return err
+	}
+
+	var R fr.Element
+	R.SetBigInt(new(big.Int).Lsh(big.NewInt(1), 256))
+	for i, c := range r1cs.Constraints {
+		for m, terms := range []constraint.LinearExpression{c.L, c.R} {
+			for _, t := range terms {
+				if t.CoeffID() == constraint.CoeffIdZero {
+					continue
+				}
+				if err := w.writeUint32(uint32(m), uint32(i), uint32(t.WireID())); err != nil {
+					return err
+				}
+				// The Montgomery form of coefficient·R is coefficient·R²
+				var v fr.Element
+				v.Mul(&r1cs.Coefficients[t.CoeffID()], &R)
+				if err := binary.Write(w.writer, binary.LittleEndian, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func pointersG1(points []bn254.G1Affine) []*bn254.G1Affine {
+	res := make([]*bn254.G1Affine, len(points))
+	for i := range points {
+		res[i] = &points[i]
+	}
+	return res
+}
+
+func pointersG2(points []bn254.G2Affine) []*bn254.G2Affine {
+	res := make([]*bn254.G2Affine, len(points))
+	for i := range points {
+		res[i] = &points[i]
+	}
+	return res
+}
@@ -0,0 +1,304 @@
+package lagrange
+
+import (
+	"encoding/binary"
+	"io"
+	"math/big"
+	"math/bits"
+	"os"
+
+	"github.com/bnb-chain/zkbnb-setup/common"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+)
+
+const (
+	// Points are stored in the temporary file as their affine coordinates in Montgomery form
+	g1RawSize = 2 * fp.Bytes
+	g2RawSize = 4 * fp.Bytes
+
+	// Memory taken per point of a block: Jacobian and affine coordinates, and their encoding
+	g1PointSize = 96 + 64 + g1RawSize
+	g2PointSize = 192 + 128 + g2RawSize
+)
+
+// fourStep splits the FFT on a domain of size n = n1·n2 of points laid out as a matrix of n2 rows by n1 columns.
+// The n1 columns are transformed by FFTs of size n2 and scaled by twiddles, then the n2 rows by FFTs of size n1,
+// which leaves the point k2 + n2·k1 of the result at row k2 and column k1. Only blocks of columns or rows
+// are held in memory, the matrix is stored in a temporary file in between.
+type fourStep struct {
+	n, n1, n2     int
+	columns, rows int // #columns and #rows per block
+
+	domain                *fft.Domain
+	columnTw, rowTw       *twiddles
+	columnOrder, rowOrder func(i int) int
+}
+
+func newFourStep(domain *fft.Domain, pointSize, budget int64) *fourStep {
+	n := int(domain.Cardinality)
+	logN := bits.TrailingZeros(uint(n))
+	s := &fourStep{
+		n:      n,
+		n1:     1 << (logN / 2),
+		n2:     1 << (logN - logN/2),
+		domain: domain,
+	}
+	s.columns = blockSize(s.n1, s.n2, pointSize, budget)
+	s.rows = blockSize(s.n2, s.n1, pointSize, budget)
+	s.columnTw = newTwiddles(fft.NewDomain(uint64(s.n2)), true, false)
+	s.rowTw = newTwiddles(fft.NewDomain(uint64(s.n1)), true, false)
+	s.columnOrder = bitReverse(s.n2)
+	s.rowOrder = bitReverse(s.n1)
+	return s
+}
+
+// blockSize returns the largest power of two, at most count, of vectors of length points fitting in budget
+func blockSize(count, length int, pointSize, budget int64) int {
+	size := 1
+	for size < count && int64(2*size*length)*pointSize <= budget {
+		size *= 2
+	}
+	return size
+}
+
+// column returns the index in a block of columns of the point at row k of the column j, once transformed
+func (s *fourStep) column(j, k int) int {
+	return j*s.n2 + s.columnOrder(k)
+}
+
+// row is column for a block of rows
+func (s *fourStep) row(j, k int) int {
+	return j*s.n1 + s.rowOrder(k)
+}
+
+// twiddle returns ω⁻ʲᵏ/n, by which the point at row k of the column j is scaled, where ω generates the domain.
+// It carries the scaling of the inverse FFT as well.
+func (s *fourStep) twiddle(j, k int) glvScalar {
+	var t fr.Element
+	var exp big.Int
+	t.Exp(s.domain.GeneratorInv, exp.SetInt64(int64(j*k%s.n))).Mul(&t, &s.domain.CardinalityInv)
+	return newGLVScalar(&t)
+}
+
+func putElement(buf []byte, e *fp.Element) {
+	for i := range e {
+		binary.LittleEndian.PutUint64(buf[8*i:], e[i])
+	}
+}
+
+func getElement(e *fp.Element, buf []byte) {
+	for i := range e {
+		e[i] = binary.LittleEndian.Uint64(buf[8*i:])
+	}
+}
+
+// readBlocks reads the count points of size bytes at position of src, for each of length consecutive blocks of src
+// which are stride points apart. The block i lands at buf[i*count*size:].
+func readBlocks(src io.ReaderAt, position int64, buf []byte, length, count, stride, size int) error {
+	for i := 0; i < length; i++ {
+		chunk := buf[i*count*size : (i+1)*count*size]
+		if _, err := src.ReadAt(chunk, position+int64(i*stride*size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBlocks is the converse of readBlocks
+func writeBlocks(dst io.WriterAt, position int64, buf []byte, length, count, stride, size int) error {
+	for i := 0; i < length; i++ {
+		chunk := buf[i*count*size : (i+1)*count*size]
+		if _, err := dst.WriteAt(chunk, position+int64(i*stride*size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConvertG1OnDisk is ConvertG1 for domains which don't fit in memory. It reads the domain compressed points
+// at position of src, and writes them compressed in the Lagrange basis at position of dst, holding at most
+// budget bytes of points at once. The intermediate matrix is stored in a temporary file in dir.
+func ConvertG1OnDisk(src io.ReaderAt, srcPos int64, dst io.WriterAt, dstPos int64, domain *fft.Domain, dir string, budget int64) error {
+	const size = bn254.SizeOfG1AffineCompressed
+	s := newFourStep(domain, g1PointSize, budget)
+
+	tmp, err := os.CreateTemp(dir, "lag")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	nbPoints := s.columns * s.n2
+	if s.rows*s.n1 > nbPoints {
+		nbPoints = s.rows * s.n1
+	}
+	buf := make([]byte, nbPoints*g1RawSize)
+	points := make([]bn254.G1Affine, nbPoints)
+	jac := make([]bn254.G1Jac, nbPoints)
+	errs := make([]error, nbPoints)
+
+	// Columns, read row after row then transposed so each column is contiguous
+	for from := 0; from < s.n1; from += s.columns {
+		block := s.columns * s.n2
+		if err := readBlocks(src, srcPos+int64(from*size), buf, s.n2, s.columns, s.n1, size); err != nil {
+			return err
+		}
+		common.Parallelize(block, func(start, end int) {
+			for i := start; i < end; i++ {
+				_, errs[i] = points[i].SetBytes(buf[i*size : (i+1)*size])
+				jac[(i%s.columns)*s.n2+i/s.columns].FromAffine(&points[i])
+			}
+		})
+		for _, err := range errs[:block] {
+			if err != nil {
+				return err
+			}
+		}
+
+		difFFTG1(jac[:block], s.columnTw)
+
+		// Rows of the block are written back to the matrix
+		toAffineG1(points[:block], jac, func(i int) int {
+			return s.column(i%s.columns, i/s.columns)
+		}, func(i int, p *bn254.G1Jac) {
+			t := s.twiddle(from+i%s.columns, i/s.columns)
+			t.mulG1(p)
+		})
+		common.Parallelize(block, func(start, end int) {
+			for i := start; i < end; i++ {
+				putElement(buf[i*g1RawSize:], &points[i].X)
+				putElement(buf[i*g1RawSize+fp.Bytes:], &points[i].Y)
+			}
+		})
+		if err := writeBlocks(tmp, int64(from*g1RawSize), buf, s.n2, s.columns, s.n1, g1RawSize); err != nil {
+			return err
+		}
+	}
+
+	// Rows, written transposed so the points of the result are in order
+	for from := 0; from < s.n2; from += s.rows {
+		block := s.rows * s.n1
+		if _, err := tmp.ReadAt(buf[:block*g1RawSize], int64(from*s.n1*g1RawSize)); err != nil {
+			return err
+		}
+		common.Parallelize(block, func(start, end int) {
+			for i := start; i < end; i++ {
+				getElement(&points[i].X, buf[i*g1RawSize:])
+				getElement(&points[i].Y, buf[i*g1RawSize+fp.Bytes:])
+				jac[i].FromAffine(&points[i])
+			}
+		})
+
+		difFFTG1(jac[:block], s.rowTw)
+
+		toAffineG1(points[:block], jac, func(i int) int {
+			return s.row(i%s.rows, i/s.rows)
+		}, nil)
+		common.Parallelize(block, func(start, end int) {
+			for i := start; i < end; i++ {
+				b := points[i].Bytes()
+				copy(buf[i*size:], b[:])
+			}
+		})
+		if err := writeBlocks(dst, dstPos+int64(from*size), buf, s.n1, s.rows, s.n2, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConvertG2OnDisk is ConvertG1OnDisk on G2
+func ConvertG2OnDisk(src io.ReaderAt, srcPos int64, dst io.WriterAt, dstPos int64, domain *fft.Domain, dir string, budget int64) error {
+	const size = bn254.SizeOfG2AffineCompressed
+	s := newFourStep(domain, g2PointSize, budget)
+
+	tmp, err := os.CreateTemp(dir, "lag")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	nbPoints := s.columns * s.n2
+	if s.rows*s.n1 > nbPoints {
+		nbPoints = s.rows * s.n1
+	}
+	buf := make([]byte, nbPoints*g2RawSize)
+	points := make([]bn254.G2Affine, nbPoints)
+	jac := make([]bn254.G2Jac, nbPoints)
+	errs := make([]error, nbPoints)
+
+	for from := 0; from < s.n1; from += s.columns {
+		block := s.columns * s.n2
+		if err := readBlocks(src, srcPos+int64(from*size), buf, s.n2, s.columns, s.n1, size); err != nil {
+			return err
+		}
+		common.Parallelize(block, func(start, end int) {
+			for i := start; i < end; i++ {
+				_, errs[i] = points[i].SetBytes(buf[i*size : (i+1)*size])
+				jac[(i%s.columns)*s.n2+i/s.columns].FromAffine(&points[i])
+			}
+		})
+		for _, err := range errs[:block] {
+			if err != nil {
+				return err
+			}
+		}
+
+		difFFTG2(jac[:block], s.columnTw)
+
+		toAffineG2(points[:block], jac, func(i int) int {
+			return s.column(i%s.columns, i/s.columns)
+		}, func(i int, p *bn254.G2Jac) {
+			t := s.twiddle(from+i%s.columns, i/s.columns)
+			t.mulG2(p)
+		})
+		common.Parallelize(block, func(start, end int) {
+			for i := start; i < end; i++ {
+				putElement(buf[i*g2RawSize:], &points[i].X.A0)
+				putElement(buf[i*g2RawSize+fp.Bytes:], &points[i].X.A1)
+				putElement(buf[i*g2RawSize+2*fp.Bytes:], &points[i].Y.A0)
+				putElement(buf[i*g2RawSize+3*fp.Bytes:], &points[i].Y.A1)
+			}
+		})
+		if err := writeBlocks(tmp, int64(from*g2RawSize), buf, s.n2, s.columns, s.n1, g2RawSize); err != nil {
+			return err
+		}
+	}
+
+	for from := 0; from < s.n2; from += s.rows {
+		block := s.rows * s.n1
+		if _, err := tmp.ReadAt(buf[:block*g2RawSize], int64(from*s.n1*g2RawSize)); err != nil {
+			return err
+		}
+		common.Parallelize(block, func(start, end int) {
+			for i := start; i < end; i++ {
+				getElement(&points[i].X.A0, buf[i*g2RawSize:])
+				getElement(&points[i].X.A1, buf[i*g2RawSize+fp.Bytes:])
+				getElement(&points[i].Y.A0, buf[i*g2RawSize+2*fp.Bytes:])
+				getElement(&points[i].Y.A1, buf[i*g2RawSize+3*fp.Bytes:])
+				jac[i].FromAffine(&points[i])
+			}
+		})
+
+		difFFTG2(jac[:block], s.rowTw)
+
+		toAffineG2(points[:block], jac, func(i int) int {
+			return s.row(i%s.rows, i/s.rows)
+		}, nil)
+		common.Parallelize(block, func(start, end int) {
+			for i := start; i < end; i++ {
+				b := points[i].Bytes()
+				copy(buf[i*size:], b[:])
+			}
+		})
+		if err := writeBlocks(dst, dstPos+int64(from*size), buf, s.n1, s.rows, s.n2, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
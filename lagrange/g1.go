@@ -1,20 +1,15 @@
 package lagrange
 
 import (
-	"math/big"
 	"math/bits"
-	"runtime"
 
 	"github.com/bnb-chain/zkbnb-setup/common"
-	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
 )
 
-type Empty struct {
-}
-
 func butterflyG1(a *bn254.G1Jac, b *bn254.G1Jac) {
 	t := *a
 	a.AddAssign(b)
@@ -22,138 +17,159 @@ func butterflyG1(a *bn254.G1Jac, b *bn254.G1Jac) {
 	*b = t
 }
 
-// KerDIF8 is a kernel that process an FFT of size 8
-func kerDIF8G1(a []bn254.G1Jac, twiddles [][]fr.Element, stage int) {
-	butterflyG1(&a[0], &a[4])
-	butterflyG1(&a[1], &a[5])
-	butterflyG1(&a[2], &a[6])
-	butterflyG1(&a[3], &a[7])
-
-	var twiddle big.Int
-	twiddles[stage+0][1].BigInt(&twiddle)
-	a[5].ScalarMultiplication(&a[5], &twiddle)
-	twiddles[stage+0][2].BigInt(&twiddle)
-	a[6].ScalarMultiplication(&a[6], &twiddle)
-	twiddles[stage+0][3].BigInt(&twiddle)
-	a[7].ScalarMultiplication(&a[7], &twiddle)
-	butterflyG1(&a[0], &a[2])
-	butterflyG1(&a[1], &a[3])
-	butterflyG1(&a[4], &a[6])
-	butterflyG1(&a[5], &a[7])
-	twiddles[stage+1][1].BigInt(&twiddle)
-	a[3].ScalarMultiplication(&a[3], &twiddle)
-	twiddles[stage+1][1].BigInt(&twiddle)
-	a[7].ScalarMultiplication(&a[7], &twiddle)
-	butterflyG1(&a[0], &a[1])
-	butterflyG1(&a[2], &a[3])
-	butterflyG1(&a[4], &a[5])
-	butterflyG1(&a[6], &a[7])
-}
-
-// parallelize threshold for a single butterfly op, if the fft stage is not parallelized already
-const butterflyThreshold = 16
-
-func difFFTG1(a []bn254.G1Jac, twiddles [][]fr.Element, stage, maxSplits int, chDone chan struct{}) {
-	if chDone != nil {
-		defer close(chDone)
-	}
-
-	n := len(a)
+// difFFTG1 runs the decimation in frequency FFT, or inverse FFT, of the twiddles tw on each of the consecutive vectors of size tw.n of a,
+// leaving them in bit reversed order. The stages are merged by pairs into radix-4 butterflies, after a radix-2
+// stage if their number is odd, and each pass over a is spread across the cores.
+// If tw is normalized, the scaling by 1/n is carried by the twiddles of the leading sub-FFT of each stage,
+// the one whose points haven't been multiplied by a twiddle yet, rather than by a pass on every point.
+func difFFTG1(a []bn254.G1Jac, tw *twiddles) {
+	n := tw.n
 	if n == 1 {
 		return
-	} else if n == 8 {
-		kerDIF8G1(a, twiddles, stage)
-		return
 	}
-	m := n >> 1
-
-	if (m > butterflyThreshold) && (stage < maxSplits) {
-		// 1 << stage == estimated used CPUs
-		numCPU := runtime.NumCPU() / (1 << (stage))
-		common.Parallelize(m, func(start, end int) {
-			var twiddle big.Int
-			for i := start; i < end; i++ {
-				butterflyG1(&a[i], &a[i+m])
-				twiddles[stage][i].BigInt(&twiddle)
-				a[i+m].ScalarMultiplication(&a[i+m], &twiddle)
+	normalized := tw.scaled != nil
+	stage := 0
+	if bits.TrailingZeros(uint(n))%2 == 1 {
+		m := n / 2
+		common.Parallelize(len(a)/2, func(start, end int) {
+			for g := start; g < end; g++ {
+				i := g % m
+				base := g/m*n + i
+				butterflyG1(&a[base], &a[base+m])
+				tw.mulG1(&a[base+m], i, normalized)
 			}
-		}, numCPU)
-	} else {
-		// i == 0
-		butterflyG1(&a[0], &a[m])
-		var twiddle big.Int
-		for i := 1; i < m; i++ {
-			butterflyG1(&a[i], &a[i+m])
-			twiddles[stage][i].BigInt(&twiddle)
-			a[i+m].ScalarMultiplication(&a[i+m], &twiddle)
-		}
+		})
+		stage++
 	}
 
-	if m == 1 {
-		return
+	for ; 1<<stage < n; stage += 2 {
+		size := n >> stage
+		q := size / 4
+		common.Parallelize(len(a)/4, func(start, end int) {
+			for g := start; g < end; g++ {
+				i := g % q
+				base := g/q*size + i
+				leading := normalized && base%n < size
+				p0, p1, p2, p3 := &a[base], &a[base+q], &a[base+2*q], &a[base+3*q]
+				butterflyG1(p0, p2)
+				tw.mulG1(p2, i<<stage, leading)
+				butterflyG1(p1, p3)
+				tw.mulG1(p3, (i+q)<<stage, leading)
+				butterflyG1(p0, p1)
+				tw.mulG1(p1, i<<(stage+1), leading)
+				butterflyG1(p2, p3)
+				tw.mulG1(p3, i<<(stage+1), false)
+			}
+		})
 	}
 
-	nextStage := stage + 1
-	if stage < maxSplits {
-		chDone := make(chan struct{}, 1)
-		go difFFTG1(a[m:n], twiddles, nextStage, maxSplits, chDone)
-		difFFTG1(a[0:m], twiddles, nextStage, maxSplits, nil)
-		<-chDone
-	} else {
-		difFFTG1(a[0:m], twiddles, nextStage, maxSplits, nil)
-		difFFTG1(a[m:n], twiddles, nextStage, maxSplits, nil)
+	// The first point only went through the top halves
+	if normalized {
+		for base := 0; base < len(a); base += n {
+			tw.scaled[0].mulG1(&a[base])
+		}
 	}
 }
 
-func bitReversePointsG1(a []bn254.G1Jac) {
-	n := uint64(len(a))
-	nn := uint64(64 - bits.TrailingZeros64(n))
-
-	numCPU := uint64(runtime.NumCPU())
-	chDone := make(chan Empty, numCPU)
-
-	for id := 0; id < int(numCPU); id++ {
-		start := n / numCPU * uint64(id)
-		end := n / numCPU * uint64(id+1)
-		if id == int(numCPU-1) {
-			end = n
+// toAffineG1 sets res[i] to jac[index(i)], scaled first by scale if it isn't nil, in affine coordinates.
+// The inversions of Z are batched with Montgomery's trick, one per core.
+func toAffineG1(res []bn254.G1Affine, jac []bn254.G1Jac, index func(i int) int, scale func(i int, p *bn254.G1Jac)) {
+	common.Parallelize(len(res), func(start, end int) {
+		// res[i].X holds the product of the Z of the points before i
+		var acc, zInv, zInv2 fp.Element
+		acc.SetOne()
+		for i := start; i < end; i++ {
+			p := &jac[index(i)]
+			if scale != nil {
+				scale(i, p)
+			}
+			res[i].X = acc
+			if !p.Z.IsZero() {
+				acc.Mul(&acc, &p.Z)
+			}
 		}
-		go func(start uint64, end uint64) {
-			for j := start; j < end; j++ {
-				irev := bits.Reverse64(j) >> nn
-				if irev > j {
-					a[j], a[irev] = a[irev], a[j]
-				}
+		acc.Inverse(&acc)
+		for i := end - 1; i >= start; i-- {
+			p := &jac[index(i)]
+			if p.Z.IsZero() {
+				res[i].X.SetZero()
+				res[i].Y.SetZero()
+				continue
 			}
-			chDone <- Empty{}
-		}(start, end)
-	}
-	for i := 0; i < int(numCPU); i++ {
-		<-chDone
+			zInv.Mul(&acc, &res[i].X)
+			acc.Mul(&acc, &p.Z)
+			zInv2.Square(&zInv)
+			res[i].X.Mul(&p.X, &zInv2)
+			res[i].Y.Mul(&p.Y, &zInv2).Mul(&res[i].Y, &zInv)
+		}
+	})
+}
+
+// bitReverse returns the bit reversal of i < n
+func bitReverse(n int) func(i int) int {
+	shift := 64 - bits.TrailingZeros64(uint64(n))
+	return func(i int) int {
+		return int(bits.Reverse64(uint64(i)) >> shift)
 	}
 }
 
+// ConvertG1 converts the points [τⁱ]₁ for i < n in place to the Lagrange basis [Lᵢ(τ)]₁ of the domain of size n
 func ConvertG1(buff []bn254.G1Affine, domain *fft.Domain) {
-	numCPU := uint64(runtime.NumCPU())
-	maxSplits := bits.TrailingZeros64(ecc.NextPowerOfTwo(numCPU))
-	jac := make([]bn254.G1Jac, len(buff))
-	for i := 0; i < len(buff); i++ {
-		jac[i].FromAffine(&buff[i])
-	}
+	convertG1(buff, domain, false)
+}
+
+// ConvertG1Coset is ConvertG1 to the Lagrange basis of the coset u·<ω> of the domain,
+// u being domain.FrMultiplicativeGen as in fft.Domain.FFT
+func ConvertG1Coset(buff []bn254.G1Affine, domain *fft.Domain) {
+	convertG1(buff, domain, true)
+}
 
-	difFFTG1(jac, domain.TwiddlesInv, 0, maxSplits, nil)
-	bitReversePointsG1(jac)
-	var invBigint big.Int
-	domain.CardinalityInv.BigInt(&invBigint)
-	common.Parallelize(len(jac), func(start, end int) {
+func convertG1(buff []bn254.G1Affine, domain *fft.Domain, onCoset bool) {
+	jac := make([]bn254.G1Jac, len(buff))
+	common.Parallelize(len(buff), func(start, end int) {
+		var t fr.Element
 		for i := start; i < end; i++ {
-			jac[i].ScalarMultiplication(&jac[i], &invBigint)
+			jac[i].FromAffine(&buff[i])
+			// Lᵢ(X) of the coset is Lᵢ(X/u) of the domain, the scaling by 1/n is carried along
+			if onCoset {
+				s := newGLVScalar(t.Mul(&domain.CosetTableInv[i], &domain.CardinalityInv))
+				s.mulG1(&jac[i])
+			}
 		}
 	})
 
+	difFFTG1(jac, newTwiddles(domain, true, !onCoset))
+	toAffineG1(buff, jac, bitReverse(len(buff)), nil)
+}
+
+// ConvertG1Inverse is the inverse of ConvertG1, it converts the points [Lᵢ(τ)]₁ of the Lagrange basis
+// of the domain in place back to [τⁱ]₁ = Σⱼ ωⁱʲ·[Lⱼ(τ)]₁
+func ConvertG1Inverse(buff []bn254.G1Affine, domain *fft.Domain) {
+	convertG1Inverse(buff, domain, false)
+}
+
+// ConvertG1InverseCoset is the inverse of ConvertG1Coset, back to [τⁱ]₁ = Σⱼ (uω)ⁱʲ·[Lⱼ(τ)]₁
+func ConvertG1InverseCoset(buff []bn254.G1Affine, domain *fft.Domain) {
+	convertG1Inverse(buff, domain, true)
+}
+
+func convertG1Inverse(buff []bn254.G1Affine, domain *fft.Domain, onCoset bool) {
+	jac := make([]bn254.G1Jac, len(buff))
 	common.Parallelize(len(buff), func(start, end int) {
 		for i := start; i < end; i++ {
-			buff[i].FromJacobian(&jac[i])
+			jac[i].FromAffine(&buff[i])
 		}
 	})
+
+	difFFTG1(jac, newTwiddles(domain, false, false))
+	var scale func(i int, p *bn254.G1Jac)
+	if onCoset {
+		scale = func(i int, p *bn254.G1Jac) {
+			if i != 0 {
+				s := newGLVScalar(&domain.CosetTable[i])
+				s.mulG1(p)
+			}
+		}
+	}
+	toAffineG1(buff, jac, bitReverse(len(buff)), scale)
 }
@@ -1,12 +1,9 @@
 package lagrange
 
 import (
-	"math/big"
 	"math/bits"
-	"runtime"
 
 	"github.com/bnb-chain/zkbnb-setup/common"
-	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark-crypto/ecc/bn254"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
@@ -19,135 +16,144 @@ func butterflyG2(a *bn254.G2Jac, b *bn254.G2Jac) {
 	*b = t
 }
 
-// KerDIF8 is a kernel that process an FFT of size 8
-func kerDIF8G2(a []bn254.G2Jac, twiddles [][]fr.Element, stage int) {
-	butterflyG2(&a[0], &a[4])
-	butterflyG2(&a[1], &a[5])
-	butterflyG2(&a[2], &a[6])
-	butterflyG2(&a[3], &a[7])
-
-	var twiddle big.Int
-	twiddles[stage+0][1].BigInt(&twiddle)
-	a[5].ScalarMultiplication(&a[5], &twiddle)
-	twiddles[stage+0][2].BigInt(&twiddle)
-	a[6].ScalarMultiplication(&a[6], &twiddle)
-	twiddles[stage+0][3].BigInt(&twiddle)
-	a[7].ScalarMultiplication(&a[7], &twiddle)
-	butterflyG2(&a[0], &a[2])
-	butterflyG2(&a[1], &a[3])
-	butterflyG2(&a[4], &a[6])
-	butterflyG2(&a[5], &a[7])
-	twiddles[stage+1][1].BigInt(&twiddle)
-	a[3].ScalarMultiplication(&a[3], &twiddle)
-	twiddles[stage+1][1].BigInt(&twiddle)
-	a[7].ScalarMultiplication(&a[7], &twiddle)
-	butterflyG2(&a[0], &a[1])
-	butterflyG2(&a[2], &a[3])
-	butterflyG2(&a[4], &a[5])
-	butterflyG2(&a[6], &a[7])
-}
-
-func difFFTG2(a []bn254.G2Jac, twiddles [][]fr.Element, stage, maxSplits int, chDone chan struct{}) {
-	if chDone != nil {
-		defer close(chDone)
-	}
-
-	n := len(a)
+// difFFTG2 is difFFTG1 on G2
+func difFFTG2(a []bn254.G2Jac, tw *twiddles) {
+	n := tw.n
 	if n == 1 {
 		return
-	} else if n == 8 {
-		kerDIF8G2(a, twiddles, stage)
-		return
 	}
-	m := n >> 1
-
-	if (m > butterflyThreshold) && (stage < maxSplits) {
-		// 1 << stage == estimated used CPUs
-		numCPU := runtime.NumCPU() / (1 << (stage))
-		common.Parallelize(m, func(start, end int) {
-			var twiddle big.Int
-			for i := start; i < end; i++ {
-				butterflyG2(&a[i], &a[i+m])
-				twiddles[stage][i].BigInt(&twiddle)
-				a[i+m].ScalarMultiplication(&a[i+m], &twiddle)
+	normalized := tw.scaled != nil
+	stage := 0
+	if bits.TrailingZeros(uint(n))%2 == 1 {
+		m := n / 2
+		common.Parallelize(len(a)/2, func(start, end int) {
+			for g := start; g < end; g++ {
+				i := g % m
+				base := g/m*n + i
+				butterflyG2(&a[base], &a[base+m])
+				tw.mulG2(&a[base+m], i, normalized)
 			}
-		}, numCPU)
-	} else {
-		// i == 0
-		butterflyG2(&a[0], &a[m])
-		var twiddle big.Int
-		for i := 1; i < m; i++ {
-			butterflyG2(&a[i], &a[i+m])
-			twiddles[stage][i].BigInt(&twiddle)
-			a[i+m].ScalarMultiplication(&a[i+m], &twiddle)
-		}
+		})
+		stage++
 	}
 
-	if m == 1 {
-		return
+	for ; 1<<stage < n; stage += 2 {
+		size := n >> stage
+		q := size / 4
+		common.Parallelize(len(a)/4, func(start, end int) {
+			for g := start; g < end; g++ {
+				i := g % q
+				base := g/q*size + i
+				leading := normalized && base%n < size
+				p0, p1, p2, p3 := &a[base], &a[base+q], &a[base+2*q], &a[base+3*q]
+				butterflyG2(p0, p2)
+				tw.mulG2(p2, i<<stage, leading)
+				butterflyG2(p1, p3)
+				tw.mulG2(p3, (i+q)<<stage, leading)
+				butterflyG2(p0, p1)
+				tw.mulG2(p1, i<<(stage+1), leading)
+				butterflyG2(p2, p3)
+				tw.mulG2(p3, i<<(stage+1), false)
+			}
+		})
 	}
 
-	nextStage := stage + 1
-	if stage < maxSplits {
-		chDone := make(chan struct{}, 1)
-		go difFFTG2(a[m:n], twiddles, nextStage, maxSplits, chDone)
-		difFFTG2(a[0:m], twiddles, nextStage, maxSplits, nil)
-		<-chDone
-	} else {
-		difFFTG2(a[0:m], twiddles, nextStage, maxSplits, nil)
-		difFFTG2(a[m:n], twiddles, nextStage, maxSplits, nil)
+	// The first point only went through the top halves
+	if normalized {
+		for base := 0; base < len(a); base += n {
+			tw.scaled[0].mulG2(&a[base])
+		}
 	}
 }
 
-func bitReversePointsG2(a []bn254.G2Jac) {
-	n := uint64(len(a))
-	nn := uint64(64 - bits.TrailingZeros64(n))
-
-	numCPU := uint64(runtime.NumCPU())
-	chDone := make(chan Empty, numCPU)
-
-	for id := 0; id < int(numCPU); id++ {
-		start := n / numCPU * uint64(id)
-		end := n / numCPU * uint64(id+1)
-		if id == int(numCPU-1) {
-			end = n
+// toAffineG2 is toAffineG1 on G2
+func toAffineG2(res []bn254.G2Affine, jac []bn254.G2Jac, index func(i int) int, scale func(i int, p *bn254.G2Jac)) {
+	common.Parallelize(len(res), func(start, end int) {
+		// acc, 1/Z and 1/Z² in the coordinates of t
+		var t bn254.G2Jac
+		acc, zInv, zInv2 := &t.X, &t.Y, &t.Z
+		acc.SetOne()
+		for i := start; i < end; i++ {
+			p := &jac[index(i)]
+			if scale != nil {
+				scale(i, p)
+			}
+			res[i].X = *acc
+			if !p.Z.IsZero() {
+				acc.Mul(acc, &p.Z)
+			}
 		}
-		go func(start uint64, end uint64) {
-			for j := start; j < end; j++ {
-				irev := bits.Reverse64(j) >> nn
-				if irev > j {
-					a[j], a[irev] = a[irev], a[j]
-				}
+		acc.Inverse(acc)
+		for i := end - 1; i >= start; i-- {
+			p := &jac[index(i)]
+			if p.Z.IsZero() {
+				res[i].X.SetZero()
+				res[i].Y.SetZero()
+				continue
 			}
-			chDone <- Empty{}
-		}(start, end)
-	}
-	for i := 0; i < int(numCPU); i++ {
-		<-chDone
-	}
+			zInv.Mul(acc, &res[i].X)
+			acc.Mul(acc, &p.Z)
+			zInv2.Square(zInv)
+			res[i].X.Mul(&p.X, zInv2)
+			res[i].Y.Mul(&p.Y, zInv2).Mul(&res[i].Y, zInv)
+		}
+	})
 }
 
+// ConvertG2 is ConvertG1 on G2
 func ConvertG2(buff []bn254.G2Affine, domain *fft.Domain) {
-	numCPU := uint64(runtime.NumCPU())
-	maxSplits := bits.TrailingZeros64(ecc.NextPowerOfTwo(numCPU))
-	jac := make([]bn254.G2Jac, len(buff))
-	for i := 0; i < len(buff); i++ {
-		jac[i].FromAffine(&buff[i])
-	}
+	convertG2(buff, domain, false)
+}
+
+// ConvertG2Coset is ConvertG1Coset on G2
+func ConvertG2Coset(buff []bn254.G2Affine, domain *fft.Domain) {
+	convertG2(buff, domain, true)
+}
 
-	difFFTG2(jac, domain.TwiddlesInv, 0, maxSplits, nil)
-	bitReversePointsG2(jac)
-	var invBigint big.Int
-	domain.CardinalityInv.BigInt(&invBigint)
-	common.Parallelize(len(jac), func(start, end int) {
+func convertG2(buff []bn254.G2Affine, domain *fft.Domain, onCoset bool) {
+	jac := make([]bn254.G2Jac, len(buff))
+	common.Parallelize(len(buff), func(start, end int) {
+		var t fr.Element
 		for i := start; i < end; i++ {
-			jac[i].ScalarMultiplication(&jac[i], &invBigint)
+			jac[i].FromAffine(&buff[i])
+			if onCoset {
+				s := newGLVScalar(t.Mul(&domain.CosetTableInv[i], &domain.CardinalityInv))
+				s.mulG2(&jac[i])
+			}
 		}
 	})
 
+	difFFTG2(jac, newTwiddles(domain, true, !onCoset))
+	toAffineG2(buff, jac, bitReverse(len(buff)), nil)
+}
+
+// ConvertG2Inverse is ConvertG1Inverse on G2
+func ConvertG2Inverse(buff []bn254.G2Affine, domain *fft.Domain) {
+	convertG2Inverse(buff, domain, false)
+}
+
+// ConvertG2InverseCoset is ConvertG1InverseCoset on G2
+func ConvertG2InverseCoset(buff []bn254.G2Affine, domain *fft.Domain) {
+	convertG2Inverse(buff, domain, true)
+}
+
+func convertG2Inverse(buff []bn254.G2Affine, domain *fft.Domain, onCoset bool) {
+	jac := make([]bn254.G2Jac, len(buff))
 	common.Parallelize(len(buff), func(start, end int) {
 		for i := start; i < end; i++ {
-			buff[i].FromJacobian(&jac[i])
+			jac[i].FromAffine(&buff[i])
 		}
 	})
+
+	difFFTG2(jac, newTwiddles(domain, false, false))
+	var scale func(i int, p *bn254.G2Jac)
+	if onCoset {
+		scale = func(i int, p *bn254.G2Jac) {
+			if i != 0 {
+				s := newGLVScalar(&domain.CosetTable[i])
+				s.mulG2(p)
+			}
+		}
+	}
+	toAffineG2(buff, jac, bitReverse(len(buff)), scale)
 }
@@ -0,0 +1,231 @@
+package lagrange
+
+import (
+	"encoding/binary"
+	"math/big"
+	"math/bits"
+
+	"github.com/bnb-chain/zkbnb-setup/common"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+)
+
+// Endomorphism ϕ(x, y) = (ωx, y) of bn254, acting as [λ] on G1 and G2, as set up by gnark-crypto
+var (
+	thirdRootOneG1 fp.Element
+	thirdRootOneG2 fp.Element
+	glvBasis       ecc.Lattice
+)
+
+func init() {
+	thirdRootOneG1.SetString("2203960485148121921418603742825762020974279258880205651966")
+	thirdRootOneG2.Square(&thirdRootOneG1)
+	var lambda big.Int
+	lambda.SetString("4407920970296243842393367215006156084916469457145843978461", 10)
+	ecc.PrecomputeLattice(fr.Modulus(), &lambda, &glvBasis)
+}
+
+// glvScalar is a scalar s = k₁ + λk₂ split once for the GLV multiplication, so that multiplying many points
+// by it skips the conversion and the split. |k₁| and |k₂| are about half as long as s.
+type glvScalar struct {
+	k1, k2     [3]uint64 // little endian words
+	neg1, neg2 bool
+}
+
+const (
+	wnafWidth  = 5
+	wnafDigits = 3*64 + 1
+)
+
+func newGLVScalar(s *fr.Element) glvScalar {
+	var res glvScalar
+	res.set(s)
+	return res
+}
+
+func (s *glvScalar) set(e *fr.Element) {
+	var b big.Int
+	k := ecc.SplitScalar(e.BigInt(&b), &glvBasis)
+	s.neg1, s.neg2 = k[0].Sign() == -1, k[1].Sign() == -1
+	s.k1 = words(k[0].Abs(&k[0]))
+	s.k2 = words(k[1].Abs(&k[1]))
+}
+
+func words(k *big.Int) [3]uint64 {
+	var buf [24]byte
+	k.FillBytes(buf[:])
+	return [3]uint64{binary.BigEndian.Uint64(buf[16:]), binary.BigEndian.Uint64(buf[8:]), binary.BigEndian.Uint64(buf[:])}
+}
+
+// wnaf recodes k in width-w non-adjacent form, negated if neg, into the odd digits of digits, and returns their number
+func wnaf(k [3]uint64, neg bool, digits *[wnafDigits]int8) int {
+	const window, half = 1 << wnafWidth, 1 << (wnafWidth - 1)
+	nbDigits := 0
+	for i := 0; k[0]|k[1]|k[2] != 0; i++ {
+		var d int64
+		if k[0]&1 == 1 {
+			if d = int64(k[0] & (window - 1)); d >= half {
+				d -= window
+			}
+			// k -= d
+			if d > 0 {
+				var borrow uint64
+				k[0], borrow = bits.Sub64(k[0], uint64(d), 0)
+				k[1], borrow = bits.Sub64(k[1], 0, borrow)
+				k[2], _ = bits.Sub64(k[2], 0, borrow)
+			} else {
+				var carry uint64
+				k[0], carry = bits.Add64(k[0], uint64(-d), 0)
+				k[1], carry = bits.Add64(k[1], 0, carry)
+				k[2], _ = bits.Add64(k[2], 0, carry)
+			}
+			nbDigits = i + 1
+		}
+		if neg {
+			d = -d
+		}
+		digits[i] = int8(d)
+		k[0] = k[0]>>1 | k[1]<<63
+		k[1] = k[1]>>1 | k[2]<<63
+		k[2] >>= 1
+	}
+	return nbDigits
+}
+
+// mulG1 sets p to [s]p by a joint double-and-add on the wNAF digits of k₁ and k₂, on the odd multiples of p
+// and their images by ϕ
+func (s *glvScalar) mulG1(p *bn254.G1Jac) {
+	var d1, d2 [wnafDigits]int8
+	n1, n2 := wnaf(s.k1, s.neg1, &d1), wnaf(s.k2, s.neg2, &d2)
+	nbDigits := n1
+	if n2 > nbDigits {
+		nbDigits = n2
+	}
+
+	// table[j] = (2j+1)p, phi[j] = ϕ(table[j])
+	var table, phi [1 << (wnafWidth - 2)]bn254.G1Jac
+	var double bn254.G1Jac
+	table[0].Set(p)
+	double.Double(p)
+	for j := 1; j < len(table); j++ {
+		table[j].Set(&table[j-1]).AddAssign(&double)
+	}
+	for j := range phi {
+		phi[j].Set(&table[j])
+		phi[j].X.Mul(&phi[j].X, &thirdRootOneG1)
+	}
+
+	p.X.SetOne()
+	p.Y.SetOne()
+	p.Z.SetZero()
+	for i := nbDigits - 1; i >= 0; i-- {
+		if !p.Z.IsZero() {
+			p.DoubleAssign()
+		}
+		if d := d1[i]; d > 0 {
+			p.AddAssign(&table[d>>1])
+		} else if d < 0 {
+			p.SubAssign(&table[-d>>1])
+		}
+		if d := d2[i]; d > 0 {
+			p.AddAssign(&phi[d>>1])
+		} else if d < 0 {
+			p.SubAssign(&phi[-d>>1])
+		}
+	}
+}
+
+// mulG2 is mulG1 on G2
+func (s *glvScalar) mulG2(p *bn254.G2Jac) {
+	var d1, d2 [wnafDigits]int8
+	n1, n2 := wnaf(s.k1, s.neg1, &d1), wnaf(s.k2, s.neg2, &d2)
+	nbDigits := n1
+	if n2 > nbDigits {
+		nbDigits = n2
+	}
+
+	var table, phi [1 << (wnafWidth - 2)]bn254.G2Jac
+	var double bn254.G2Jac
+	table[0].Set(p)
+	double.Double(p)
+	for j := 1; j < len(table); j++ {
+		table[j].Set(&table[j-1]).AddAssign(&double)
+	}
+	for j := range phi {
+		phi[j].Set(&table[j])
+		phi[j].X.MulByElement(&phi[j].X, &thirdRootOneG2)
+	}
+
+	p.X.SetOne()
+	p.Y.SetOne()
+	p.Z.SetZero()
+	for i := nbDigits - 1; i >= 0; i-- {
+		if !p.Z.IsZero() {
+			p.DoubleAssign()
+		}
+		if d := d1[i]; d > 0 {
+			p.AddAssign(&table[d>>1])
+		} else if d < 0 {
+			p.SubAssign(&table[-d>>1])
+		}
+		if d := d2[i]; d > 0 {
+			p.AddAssign(&phi[d>>1])
+		} else if d < 0 {
+			p.SubAssign(&phi[-d>>1])
+		}
+	}
+}
+
+// twiddles holds the split powers ωⁱ for i < n/2, ω generating a domain of size n, or ω⁻ⁱ for the inverse FFT.
+// The twiddles of the stage s of the FFT are the ones of the first stage whose index is a multiple of 2ˢ.
+// If the FFT is normalized, scaled holds them divided by n as well.
+type twiddles struct {
+	n       int
+	scalars []glvScalar
+	scaled  []glvScalar
+}
+
+func newTwiddles(domain *fft.Domain, inverse, normalized bool) *twiddles {
+	tw := &twiddles{n: int(domain.Cardinality)}
+	if tw.n == 1 {
+		return tw
+	}
+	roots := domain.Twiddles[0]
+	if inverse {
+		roots = domain.TwiddlesInv[0]
+	}
+	tw.scalars = make([]glvScalar, tw.n/2)
+	if normalized {
+		tw.scaled = make([]glvScalar, tw.n/2)
+	}
+	common.Parallelize(len(tw.scalars), func(start, end int) {
+		var t fr.Element
+		for i := start; i < end; i++ {
+			tw.scalars[i].set(&roots[i])
+			if normalized {
+				tw.scaled[i].set(t.Mul(&roots[i], &domain.CardinalityInv))
+			}
+		}
+	})
+	return tw
+}
+
+// mulG1 multiplies p by the i-th twiddle, the first one being 1, or by the scaled one if leading
+func (tw *twiddles) mulG1(p *bn254.G1Jac, i int, leading bool) {
+	if leading {
+		tw.scaled[i].mulG1(p)
+	} else if i != 0 {
+		tw.scalars[i].mulG1(p)
+	}
+}
+
+func (tw *twiddles) mulG2(p *bn254.G2Jac, i int, leading bool) {
+	if leading {
+		tw.scaled[i].mulG2(p)
+	} else if i != 0 {
+		tw.scalars[i].mulG2(p)
+	}
+}
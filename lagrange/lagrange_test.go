@@ -0,0 +1,329 @@
+package lagrange
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+)
+
+func randomG1(n int) []bn254.G1Affine {
+	_, _, g1, _ := bn254.Generators()
+	points := make([]bn254.G1Affine, n)
+	var s fr.Element
+	var b big.Int
+	for i := range points {
+		s.SetRandom()
+		points[i].ScalarMultiplication(&g1, s.BigInt(&b))
+	}
+	return points
+}
+
+func randomG2(n int) []bn254.G2Affine {
+	_, _, _, g2 := bn254.Generators()
+	points := make([]bn254.G2Affine, n)
+	var s fr.Element
+	var b big.Int
+	for i := range points {
+		s.SetRandom()
+		points[i].ScalarMultiplication(&g2, s.BigInt(&b))
+	}
+	return points
+}
+
+// lagrangeScalars returns ω⁻ⁱʲ/n for i < n, or u⁻ⁱω⁻ⁱʲ/n on the coset, so that the j-th point of the Lagrange basis
+// is the MSM of the points by them
+func lagrangeScalars(domain *fft.Domain, j int, coset bool) []fr.Element {
+	n := int(domain.Cardinality)
+	scalars := make([]fr.Element, n)
+	var w fr.Element
+	w.Exp(domain.GeneratorInv, big.NewInt(int64(j)))
+	if coset {
+		w.Mul(&w, &domain.FrMultiplicativeGenInv)
+	}
+	scalars[0] = domain.CardinalityInv
+	for i := 1; i < n; i++ {
+		scalars[i].Mul(&scalars[i-1], &w)
+	}
+	return scalars
+}
+
+// naiveG1 evaluates the Lagrange basis by one MSM per point
+func naiveG1(t *testing.T, points []bn254.G1Affine, domain *fft.Domain, coset bool) []bn254.G1Affine {
+	res := make([]bn254.G1Affine, len(points))
+	for j := range res {
+		if _, err := res[j].MultiExp(points, lagrangeScalars(domain, j, coset), ecc.MultiExpConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res
+}
+
+func naiveG2(t *testing.T, points []bn254.G2Affine, domain *fft.Domain, coset bool) []bn254.G2Affine {
+	res := make([]bn254.G2Affine, len(points))
+	for j := range res {
+		if _, err := res[j].MultiExp(points, lagrangeScalars(domain, j, coset), ecc.MultiExpConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res
+}
+
+// Sizes with an even and an odd number of stages, the latter starting by a radix-2 stage
+var sizes = []int{2, 4, 8, 64, 128}
+
+func TestConvertG1(t *testing.T) {
+	for _, coset := range []bool{false, true} {
+		for _, n := range sizes {
+			domain := fft.NewDomain(uint64(n))
+			points := randomG1(n)
+			expected := naiveG1(t, points, domain, coset)
+			convert := ConvertG1
+			if coset {
+				convert = ConvertG1Coset
+			}
+			convert(points, domain)
+			for i := range points {
+				if !points[i].Equal(&expected[i]) {
+					t.Fatalf("point %d of the Lagrange basis of size %d differs, coset: %v", i, n, coset)
+				}
+			}
+		}
+	}
+}
+
+func TestConvertG2(t *testing.T) {
+	for _, coset := range []bool{false, true} {
+		for _, n := range sizes {
+			domain := fft.NewDomain(uint64(n))
+			points := randomG2(n)
+			expected := naiveG2(t, points, domain, coset)
+			convert := ConvertG2
+			if coset {
+				convert = ConvertG2Coset
+			}
+			convert(points, domain)
+			for i := range points {
+				if !points[i].Equal(&expected[i]) {
+					t.Fatalf("point %d of the Lagrange basis of size %d differs, coset: %v", i, n, coset)
+				}
+			}
+		}
+	}
+}
+
+// TestRoundTripG1 checks that ConvertG1Inverse undoes ConvertG1 and conversely, on the domain and its coset
+func TestRoundTripG1(t *testing.T) {
+	for _, coset := range []bool{false, true} {
+		for _, n := range append([]int{1}, sizes...) {
+			domain := fft.NewDomain(uint64(n))
+			points := randomG1(n)
+			res := append([]bn254.G1Affine{}, points...)
+			convert, inverse := ConvertG1, ConvertG1Inverse
+			if coset {
+				convert, inverse = ConvertG1Coset, ConvertG1InverseCoset
+			}
+			convert(res, domain)
+			inverse(res, domain)
+			for i := range points {
+				if !res[i].Equal(&points[i]) {
+					t.Fatalf("point %d of size %d isn't recovered from the Lagrange basis, coset: %v", i, n, coset)
+				}
+			}
+			inverse(res, domain)
+			convert(res, domain)
+			for i := range points {
+				if !res[i].Equal(&points[i]) {
+					t.Fatalf("point %d of size %d isn't recovered from the monomial basis, coset: %v", i, n, coset)
+				}
+			}
+		}
+	}
+}
+
+func TestRoundTripG2(t *testing.T) {
+	for _, coset := range []bool{false, true} {
+		for _, n := range append([]int{1}, sizes...) {
+			domain := fft.NewDomain(uint64(n))
+			points := randomG2(n)
+			res := append([]bn254.G2Affine{}, points...)
+			convert, inverse := ConvertG2, ConvertG2Inverse
+			if coset {
+				convert, inverse = ConvertG2Coset, ConvertG2InverseCoset
+			}
+			convert(res, domain)
+			inverse(res, domain)
+			for i := range points {
+				if !res[i].Equal(&points[i]) {
+					t.Fatalf("point %d of size %d isn't recovered from the Lagrange basis, coset: %v", i, n, coset)
+				}
+			}
+			inverse(res, domain)
+			convert(res, domain)
+			for i := range points {
+				if !res[i].Equal(&points[i]) {
+					t.Fatalf("point %d of size %d isn't recovered from the monomial basis, coset: %v", i, n, coset)
+				}
+			}
+		}
+	}
+}
+
+// TestConvertOnDisk checks the four-step FFT against the naive evaluation, with a block of one column or row
+// at a time, several ones, and all of them
+func TestConvertOnDisk(t *testing.T) {
+	const n = 128
+	dir := t.TempDir()
+	domain := fft.NewDomain(n)
+	pointsG1, pointsG2 := randomG1(n), randomG2(n)
+	expectedG1, expectedG2 := naiveG1(t, pointsG1, domain, false), naiveG2(t, pointsG2, domain, false)
+
+	// Points are read after and written after a prefix of a few bytes, as sections of a file
+	const offset = 7
+	write := func(name string, data []byte) *os.File {
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.WriteAt(data, offset); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	var srcG1, srcG2 []byte
+	for i := range pointsG1 {
+		b1, b2 := pointsG1[i].Bytes(), pointsG2[i].Bytes()
+		srcG1 = append(srcG1, b1[:]...)
+		srcG2 = append(srcG2, b2[:]...)
+	}
+	g1File, g2File := write("g1", srcG1), write("g2", srcG2)
+	defer g1File.Close()
+	defer g2File.Close()
+
+	for _, budget := range []int64{1, 64 * g2PointSize, 1 << 30} {
+		dst, err := os.Create(filepath.Join(dir, "dst"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ConvertG1OnDisk(g1File, offset, dst, offset, domain, dir, budget); err != nil {
+			t.Fatal(err)
+		}
+		if err := ConvertG2OnDisk(g2File, offset, dst, offset+n*bn254.SizeOfG1AffineCompressed, domain, dir, budget); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Seek(offset, 0); err != nil {
+			t.Fatal(err)
+		}
+		dec := bn254.NewDecoder(dst)
+		for i := range expectedG1 {
+			var p bn254.G1Affine
+			if err := dec.Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if !p.Equal(&expectedG1[i]) {
+				t.Fatalf("point %d of the Lagrange basis in G1 differs within %d bytes", i, budget)
+			}
+		}
+		for i := range expectedG2 {
+			var p bn254.G2Affine
+			if err := dec.Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if !p.Equal(&expectedG2[i]) {
+				t.Fatalf("point %d of the Lagrange basis in G2 differs within %d bytes", i, budget)
+			}
+		}
+		dst.Close()
+
+		if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+			t.Fatal("temporary files should be removed")
+		}
+	}
+}
+
+func TestGLVScalar(t *testing.T) {
+	_, _, g1, g2 := bn254.Generators()
+	var base1 bn254.G1Jac
+	var base2 bn254.G2Jac
+	base1.FromAffine(&g1)
+	base2.FromAffine(&g2)
+
+	scalars := make([]fr.Element, 64)
+	scalars[1].SetOne()
+	scalars[2].SetOne().Neg(&scalars[2])
+	scalars[3].SetUint64(3)
+	for i := 4; i < len(scalars); i++ {
+		scalars[i].SetRandom()
+	}
+	for i := range scalars {
+		var b big.Int
+		var expected1, p1 bn254.G1Jac
+		var expected2, p2 bn254.G2Jac
+		expected1.ScalarMultiplication(&base1, scalars[i].BigInt(&b))
+		expected2.ScalarMultiplication(&base2, &b)
+
+		s := newGLVScalar(&scalars[i])
+		p1.Set(&base1)
+		s.mulG1(&p1)
+		p2.Set(&base2)
+		s.mulG2(&p2)
+		if !p1.Equal(&expected1) || !p2.Equal(&expected2) {
+			t.Fatalf("[%s]G differs", scalars[i].String())
+		}
+	}
+}
+
+func BenchmarkConvertG1(b *testing.B) {
+	const n = 1 << 12
+	domain := fft.NewDomain(n)
+	points := randomG1(n)
+	buff := make([]bn254.G1Affine, n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(buff, points)
+		ConvertG1(buff, domain)
+	}
+}
+
+func BenchmarkConvertG2(b *testing.B) {
+	const n = 1 << 10
+	domain := fft.NewDomain(n)
+	points := randomG2(n)
+	buff := make([]bn254.G2Affine, n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(buff, points)
+		ConvertG2(buff, domain)
+	}
+}
+
+// BenchmarkScalarMultiplicationG1 and BenchmarkGLVScalarG1 compare a multiplication by a twiddle
+// before and after it is split once
+func BenchmarkScalarMultiplicationG1(b *testing.B) {
+	_, _, g1, _ := bn254.Generators()
+	var p bn254.G1Jac
+	p.FromAffine(&g1)
+	domain := fft.NewDomain(1 << 12)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var s big.Int
+		domain.TwiddlesInv[0][i%(1<<11)].BigInt(&s)
+		p.ScalarMultiplication(&p, &s)
+	}
+}
+
+func BenchmarkGLVScalarG1(b *testing.B) {
+	_, _, g1, _ := bn254.Generators()
+	var p bn254.G1Jac
+	p.FromAffine(&g1)
+	tw := newTwiddles(fft.NewDomain(1<<12), true, false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tw.scalars[i%(1<<11)].mulG1(&p)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/bnb-chain/zkbnb-setup/phase2"
 	"github.com/urfave/cli/v2"
 )
 
@@ -16,84 +17,335 @@ func main() {
 			/* --------------------------- Phase 1 Initialize --------------------------- */
 			{
 				Name:        "p1n",
-				Usage:       "p1n <power> <outputPath>",
+				Usage:       "p1n --ceremony <name> <power> <outputPath>",
 				Description: "initialize phase 1 of parameters generation for Groth16",
-				Action:      p1n,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "ceremony",
+						Usage:    "`NAME` of the ceremony the parameters are bound to",
+						Required: true,
+					},
+				},
+				Action: p1n,
 			},
 			/* --------------------------- Phase 1 Contribute --------------------------- */
 			{
 				Name:        "p1c",
-				Usage:       "p1c <inputPath> <outputPath>",
+				Usage:       "p1c [--parallel] <inputPath> <outputPath>",
 				Description: "contribute phase 1 randomness for Groth16",
-				Action:      p1c,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "parallel",
+						Usage: "process the sections of the parameters concurrently, sharing the cores among them",
+					},
+				},
+				Action: p1c,
 			},
 			/* ----------------------------- Phase 1 Verify ----------------------------- */
 			{
 				Name:        "p1v",
-				Usage:       "p1v <inputPath>",
+				Usage:       "p1v [--legacy] <inputPath>",
 				Description: "verify phase 1 contributions for Groth16",
-				Action:      p1v,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "legacy",
+						Usage: "accept files produced before binding phase 1 to a ceremony origin",
+					},
+				},
+				Action: p1v,
 			},
 			/* ------------------ Phase 1 Transform from PPoT Ceremony ------------------ */
 			{
 				Name:        "p1t",
-				Usage:       "p1t <inputPath> <outputPath> <originalPower> <reducedPower>",
+				Usage:       "p1t --ceremony <name> <inputPath> <outputPath> <originalPower> <reducedPower>",
 				Description: "transforms output of PPoT ceremony to be usable by zkBnB-setup",
-				Action:      p1t,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "ceremony",
+						Usage:    "`NAME` of the ceremony the parameters are bound to",
+						Required: true,
+					},
+				},
+				Action: p1t,
 			},
 			/* ------------------ Phase 1 Verify from transformed file ------------------ */
 			{
 				Name:        "p1vt",
-				Usage:       "p1vt <inputPath> <transformedPath",
+				Usage:       "p1vt [--legacy] <inputPath> <transformedPath>",
 				Description: "verify phase 1 contributions for Groth16 based on transformed PPoT ceremony file",
-				Action:      p1vt,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "legacy",
+						Usage: "accept files produced before binding phase 1 to a ceremony origin",
+					},
+				},
+				Action: p1vt,
+			},
+			/* ---------------------- Phase 1 Prepare Lagrange SRS ---------------------- */
+			{
+				Name:        "p1prepare",
+				Usage:       "p1prepare [--memory MiB] <phase1Path> <outputDir>",
+				Description: "convert phase 1 parameters to the Lagrange basis of every domain they support for phase 2 initialization",
+				Flags: []cli.Flag{
+					&cli.Int64Flag{
+						Name:  "memory",
+						Usage: "bound the points held at once while converting to `MiB`",
+						Value: phase2.DefaultMemoryBudget >> 20,
+					},
+				},
+				Action: p1prepare,
+			},
+			/* ---------------------- Phase 1 Verify Lagrange SRS ----------------------- */
+			{
+				Name:        "p1vprepare",
+				Usage:       "p1vprepare <phase1Path> <preparedPath>",
+				Description: "verify a Lagrange SRS prepared by p1prepare against phase 1 parameters at a random point",
+				Action:      p1vprepare,
 			},
 			/* --------------------------- Phase 2 Initialize --------------------------- */
 			{
 				Name:        "p2n",
-				Usage:       "p2n <phase1Path> <r1csPath> <phase2Path>",
+				Usage:       "p2n [--commitments commitments.json] [--memory MiB] [--prepared DIR] <phase1Path> <r1csPath> <phase2Path>",
 				Description: "initialize phase 2 for the given circuit",
-				Action:      p2n,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "commitments",
+						Usage: "read the Pedersen commitments of the circom circuit as JSON of gnark v0.9 Groth16Commitments from `FILE`",
+					},
+					&cli.Int64Flag{
+						Name:  "memory",
+						Usage: "bound the points held at once while evaluating the keys to `MiB`, the R1CS aside",
+						Value: phase2.DefaultMemoryBudget >> 20,
+					},
+					&cli.StringFlag{
+						Name:  "prepared",
+						Usage: "copy the Lagrange SRS prepared by p1prepare in `DIR` instead of converting it",
+					},
+				},
+				Action: p2n,
 			},
 			/* ------------------- Phase 2 Initialize from parted R1CS ------------------ */
 			{
 				Name:        "p2np",
-				Usage:       "p2np <phase1Path> <r1csPath> <outputPhase2> <#constraints> <#R1C> <batchSize>",
+				Usage:       "p2np [--memory MiB] [--prepared DIR] <phase1Path> <session> <outputPhase2>",
 				Description: "initialize phase 2 for the given circuit parted R1CS",
-				Action:      p2np,
+				Flags: []cli.Flag{
+					&cli.Int64Flag{
+						Name:  "memory",
+						Usage: "bound the points held at once while evaluating the keys to `MiB`, the R1CS aside",
+						Value: phase2.DefaultMemoryBudget >> 20,
+					},
+					&cli.StringFlag{
+						Name:  "prepared",
+						Usage: "copy the Lagrange SRS prepared by p1prepare in `DIR` instead of converting it",
+					},
+				},
+				Action: p2np,
 			},
 			/* --------------------------- Phase 2 Contribute --------------------------- */
 			{
 				Name:        "p2c",
 				Usage:       "p2c <inputPath> <outputPath>",
-				Description: "contribute phase 2 randomness for Groth16",
-				Action:      p2c,
+				Description: "contribute phase 2 randomness for Groth16 to a circuit or a bundle of circuits",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "yes",
+						Usage: "skip the confirmation of the circuit labels",
+					},
+				},
+				Action: p2c,
 			},
 			/* ----------------------------- Phase 2 Verify ----------------------------- */
 			{
 				Name:        "p2v",
-				Usage:       "p2v <inputPath> <originPath>",
-				Description: "verify phase 2 contributions for Groth16",
-				Action:      p2v,
+				Usage:       "p2v <inputPath> <originPath> | p2v --prev <prevPath> <nextPath>",
+				Description: "verify phase 2 contributions for Groth16 of a circuit or a bundle of circuits",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "prev",
+						Usage: "verify only the last contribution against the previous phase 2 `FILE`",
+					},
+				},
+				Action: p2v,
+			},
+			/* ----------------------------- Phase 2 Bundle ----------------------------- */
+			{
+				Name:        "p2b",
+				Usage:       "p2b <bundlePath> <phase2Path>...",
+				Description: "bundle phase 2 files of several circuits to contribute to them in one session",
+				Action:      p2b,
+			},
+			/* ---------------------------- Phase 2 Unbundle ---------------------------- */
+			{
+				Name:        "p2u",
+				Usage:       "p2u <bundlePath> <outputDir>",
+				Description: "extract phase 2 file of each circuit in the bundle as <outputDir>/<circuit>.ph2",
+				Action:      p2u,
+			},
+			/* ------------------------------ Phase 2 Audit ----------------------------- */
+			{
+				Name:        "p2audit",
+				Usage:       "p2audit [--commitments commitments.json] [--memory MiB] <phase1Path> <r1csPath> <phase2Path> <evalsPath> [lagrangePath]",
+				Description: "recompute phase 2 initialization and compare the section digests of the given files",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "commitments",
+						Usage: "read the Pedersen commitments of the circom circuit as JSON of gnark v0.9 Groth16Commitments from `FILE`",
+					},
+					&cli.Int64Flag{
+						Name:  "memory",
+						Usage: "bound the points held at once while evaluating the keys to `MiB`, the R1CS aside",
+						Value: phase2.DefaultMemoryBudget >> 20,
+					},
+				},
+				Action: p2audit,
 			},
 			/* ----------------------------- Keys Extraction ---------------------------- */
 			{
 				Name:        "key",
-				Usage:       "key <inputPath>",
+				Usage:       "key [--snarkjs verification_key.json] [--format gnark-fork|gnark-v0.9] <inputPath>",
 				Description: "extract proving and verifying keys",
-				Action:      extract,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "snarkjs",
+						Usage: "also export the verifying key as snarkjs verification_key.json to `FILE`",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "`FORMAT` of the keys: gnark-fork or gnark-v0.9",
+						Value: "gnark-fork",
+					},
+				},
+				Action: extract,
 			},
 			{
 				Name:        "keys",
-				Usage:       "keys <inputPath> <session>",
-				Description: "extract proving and verifying keys split",
-				Action:      extracts,
+				Usage:       "keys [--snarkjs verification_key.json] [--format gnark-fork|gnark-v0.9] <inputPath> <session>",
+				Description: "extract proving and verifying keys split, or as <session>.pk and <session>.vk for gnark-v0.9",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "snarkjs",
+						Usage: "also export the verifying key as snarkjs verification_key.json to `FILE`",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "`FORMAT` of the keys: gnark-fork or gnark-v0.9",
+						Value: "gnark-fork",
+					},
+				},
+				Action: extracts,
+				Subcommands: []*cli.Command{
+					/* ---------------------------- Keys Verification --------------------------- */
+					{
+						Name:        "verify",
+						Usage:       "keys verify <pkPath> <vkPath> [<phase2Path> [<evalsPath>]] | keys verify --session <session> [<phase2Path> [<evalsPath>]]",
+						Description: "verify that proving and verifying keys belong together, and to the phase 2 file and its evaluations if given",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "session",
+								Usage: "verify the split keys of `SESSION` extracted by keys",
+							},
+						},
+						Action: verifyKeys,
+					},
+					/* ------------------------------ snarkjs zkey ------------------------------ */
+					{
+						Name:        "zkey",
+						Usage:       "keys zkey [--evals evals] <phase2Path> <r1csPath> <outputPath>",
+						Description: "export the proving key as snarkjs zkey",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "evals",
+								Usage: "evaluations of the phase 2 file from `FILE`",
+								Value: "evals",
+							},
+						},
+						Action:      exportZKey,
+					},
+					/* ------------------------------ Go verifier ------------------------------- */
+					{
+						Name:        "gen-go",
+						Usage:       "keys gen-go [--package verifier] [--proof proof --witness public.wtns] [--evals evals] <vkPath|phase2Path|session> [outputPath]",
+						Description: "generate a Go package embedding the verifying key with a Verify function, and its test",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "package",
+								Usage: "`NAME` of the generated package",
+								Value: "verifier",
+							},
+							&cli.StringFlag{
+								Name:  "proof",
+								Usage: "sample proof the generated test verifies from `FILE`",
+							},
+							&cli.StringFlag{
+								Name:  "witness",
+								Usage: "public witness of the sample proof from `FILE`",
+							},
+							&cli.StringFlag{
+								Name:  "evals",
+								Usage: "evaluations of a phase 2 input from `FILE`",
+								Value: "evals",
+							},
+						},
+						Action: exportGo,
+					},
+				},
 			},
 			{
 				Name:        "sol",
-				Usage:       "sol <session>",
-				Description: "export verifier smart contract from verifying key",
-				Action:      exportSol,
+				Usage:       "sol [--name Verifier] [--pragma ^0.8.0] [--link] [--foundry Verifier.t.sol --proof proof --witness public.wtns] [--evals evals] <vkPath|phase2Path|session> [outputPath], or sol --multi <outputPath> <id>=<vkPath|session>...",
+				Description: "export verifier smart contract from a verifying key, a phase 2 file, or the split keys of a session, or one contract for several circuits keyed by ID",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "multi",
+						Usage: "export one contract verifying the proofs of several circuits, selected by the circuit ID",
+					},
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "`NAME` of the verifier contract",
+						Value: "Verifier",
+					},
+					&cli.StringFlag{
+						Name:  "pragma",
+						Usage: "solidity `VERSION` pragma",
+						Value: "^0.8.0",
+					},
+					&cli.BoolFlag{
+						Name:  "link",
+						Usage: "make the Pairing library functions public so the library is linked instead of inlined",
+					},
+					&cli.StringFlag{
+						Name:  "foundry",
+						Usage: "also write a Foundry test verifying --proof and --witness against the contract to `FILE`",
+					},
+					&cli.StringFlag{
+						Name:  "proof",
+						Usage: "sample proof of the Foundry test from `FILE`",
+					},
+					&cli.StringFlag{
+						Name:  "witness",
+						Usage: "public witness of the sample proof from `FILE`",
+					},
+					&cli.StringFlag{
+						Name:  "evals",
+						Usage: "evaluations of a phase 2 input from `FILE`",
+						Value: "evals",
+					},
+				},
+				Action: exportSol,
+			},
+			/* ---------------------------------- Prove --------------------------------- */
+			{
+				Name:        "prove",
+				Usage:       "prove <r1csPath> <pkPath> <vkPath> <witness.json> [outputDir]",
+				Description: "prove and verify a witness with the extracted keys, and write the proof, public inputs, and verifier calldata",
+				Action:      prove,
+			},
+			{
+				Name:        "provep",
+				Usage:       "provep <session> <vkPath> <witness.json> [outputDir]",
+				Description: "prove and verify a witness with the parted R1CS and split keys of session, and write the proof, public inputs, and verifier calldata",
+				Action:      provep,
 			},
 		},
 	}
@@ -1,7 +1,9 @@
 package phase1
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
 	"io"
 	"math"
 	"os"
@@ -109,12 +111,17 @@ func computeHash(c *Contribution) []byte {
 	return sha.Sum(nil)
 }
 
-func defaultContribution(transformedPath string) (Contribution, error) {
+// defaultContribution returns the origin of the parameters as a contribution challenging the first one,
+// the origin digest of the verified header is checked against the generators or the transformed file
+func defaultContribution(verified *Header, transformedPath string) (Contribution, error) {
 	var c Contribution
-	c.Hash = nil
+	c.Hash = verified.challenge()
 
 	// Initialize with generators
 	if transformedPath == "" {
+		if !verified.IsLegacy() && !bytes.Equal(verified.OriginDigest, generatorsDigest(verified.Power)) {
+			return c, errors.New("origin of parameters isn't the generators")
+		}
 		_, _, g1, g2 := bn254.Generators()
 		c.G1.Tau.Set(&g1)
 		c.G1.Alpha.Set(&g1)
@@ -137,10 +144,22 @@ func defaultContribution(transformedPath string) (Contribution, error) {
 		if err := header.ReadFrom(inputFile); err != nil {
 			return c, err
 		}
+		if !verified.SameOrigin(&header) {
+			return c, errors.New("there is a mismatch between the ceremony origin of the input and transformed files")
+		}
+		if !header.IsLegacy() {
+			digest, err := ParametersDigest(inputFile, &header)
+			if err != nil {
+				return c, err
+			}
+			if !bytes.Equal(header.OriginDigest, digest) {
+				return c, errors.New("origin digest doesn't match the parameters of the transformed file")
+			}
+		}
 
 		N := int(math.Pow(2, float64(header.Power)))
 
-		var posTauG1 int64 = 3 + G1CompressedSize
+		var posTauG1 int64 = header.Size() + G1CompressedSize
 		var posAlphaG1 int64 = posTauG1 + int64(2*N-2)*G1CompressedSize
 		var posBetaG1 int64 = posAlphaG1 + int64(N)*G1CompressedSize
 		var posTauG2 int64 = posBetaG1 + int64(N)*G1CompressedSize + G2CompressedSize
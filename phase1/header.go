@@ -1,33 +1,74 @@
 package phase1
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"io"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+)
+
+// headerMagic marks a header bound to a ceremony, it can't be confused with
+// the power which leads legacy headers as powers never exceed 28
+const headerMagic byte = 0xB1
+
+const (
+	legacyHeaderSize = 3
+	headerSize       = 1 + legacyHeaderSize + 32 + 32
 )
 
 type Header struct {
 	Power         byte
 	Contributions uint16
+	CeremonyID    []byte // SHA256 of the ceremony name, empty for legacy headers
+	OriginDigest  []byte // SHA256 of the origin of parameters, empty for legacy headers
 }
 
 func (p *Header) ReadFrom(reader io.Reader) error {
 	buffPower := make([]byte, 1)
 	// Read NConstraints
-	if _, err := reader.Read(buffPower); err != nil {
+	if _, err := io.ReadFull(reader, buffPower); err != nil {
 		return err
 	}
+	bound := buffPower[0] == headerMagic
+	if bound {
+		if _, err := io.ReadFull(reader, buffPower); err != nil {
+			return err
+		}
+	}
 	p.Power = buffPower[0]
 
 	// Read NContribution
 	buffContributions := make([]byte, 2)
-	if _, err := reader.Read(buffContributions); err != nil {
+	if _, err := io.ReadFull(reader, buffContributions); err != nil {
 		return err
 	}
 	p.Contributions = binary.BigEndian.Uint16(buffContributions)
+
+	p.CeremonyID = nil
+	p.OriginDigest = nil
+	if bound {
+		p.CeremonyID = make([]byte, 32)
+		if _, err := io.ReadFull(reader, p.CeremonyID); err != nil {
+			return err
+		}
+		p.OriginDigest = make([]byte, 32)
+		if _, err := io.ReadFull(reader, p.OriginDigest); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (p *Header) writeTo(writer io.Writer) error {
+	// Write Magic
+	if !p.IsLegacy() {
+		if _, err := writer.Write([]byte{headerMagic}); err != nil {
+			return err
+		}
+	}
+
 	// Write Power
 	if _, err := writer.Write([]byte{p.Power}); err != nil {
 		return err
@@ -40,5 +81,63 @@ func (p *Header) writeTo(writer io.Writer) error {
 		return err
 	}
 
+	// Write Ceremony ID and Origin Digest
+	if !p.IsLegacy() {
+		if _, err := writer.Write(p.CeremonyID); err != nil {
+			return err
+		}
+		if _, err := writer.Write(p.OriginDigest); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// IsLegacy reports whether the header predates binding phase 1 to a ceremony origin
+func (p *Header) IsLegacy() bool {
+	return len(p.CeremonyID) == 0
+}
+
+// Size returns the number of bytes of the header in the phase 1 file
+func (p *Header) Size() int64 {
+	if p.IsLegacy() {
+		return legacyHeaderSize
+	}
+	return headerSize
+}
+
+// SameOrigin reports whether both headers are bound to the same ceremony and origin
+func (p *Header) SameOrigin(p2 *Header) bool {
+	return bytes.Equal(p.CeremonyID, p2.CeremonyID) && bytes.Equal(p.OriginDigest, p2.OriginDigest)
+}
+
+// challenge returns the challenge of the first contribution which is derived from the ceremony and origin,
+// legacy headers keep the nil challenge
+func (p *Header) challenge() []byte {
+	if p.IsLegacy() {
+		return nil
+	}
+	sha := sha256.New()
+	sha.Write([]byte("zkbnb-setup phase 1"))
+	sha.Write(p.CeremonyID)
+	sha.Write(p.OriginDigest)
+	return sha.Sum(nil)
+}
+
+// ceremonyID derives the identifier of a ceremony from its name
+func ceremonyID(ceremony string) []byte {
+	h := sha256.Sum256([]byte(ceremony))
+	return h[:]
+}
+
+// generatorsDigest is the origin digest of parameters initialized with the generators
+func generatorsDigest(power byte) []byte {
+	_, _, g1, g2 := bn254.Generators()
+	sha := sha256.New()
+	sha.Write([]byte{power})
+	enc := bn254.NewEncoder(sha)
+	enc.Encode(&g1)
+	enc.Encode(&g2)
+	return sha.Sum(nil)
+}
@@ -0,0 +1,117 @@
+package phase1
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/bnb-chain/zkbnb-setup/common"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+// ContributeOptions tunes the contribution to phase 1
+type ContributeOptions struct {
+	// ParallelSections makes Contribute process TauG1, AlphaTauG1, BetaTauG1 and TauG2 concurrently, each one
+	// read and written at its offset, with the cores shared among them in proportion to their work. The output is the same.
+	ParallelSections bool
+}
+
+// g2Cost is about the cost of a G2 scalar multiplication in G1 ones
+const g2Cost = 3
+
+// offsetWriter writes sequentially to file from offset by positional writes, so sections are written concurrently
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// sectionCpus shares the cores among sections in proportion to their work, at least one each
+func sectionCpus(work []int) []int {
+	total := 0
+	for _, w := range work {
+		total += w
+	}
+	cpus := make([]int, len(work))
+	for i, w := range work {
+		cpus[i] = runtime.NumCPU() * w / total
+		if cpus[i] < 1 {
+			cpus[i] = 1
+		}
+	}
+	return cpus
+}
+
+// scaleSections processes the sections of the parameters at position of inputFile into the same position
+// of outputFile concurrently, and sets the first points of the contribution
+func scaleSections(inputFile, outputFile *os.File, position int64, N int, tau, alpha, beta *fr.Element, c *Contribution) error {
+	sections := []struct {
+		size  int // #Points
+		scale func(dec *bn254.Decoder, enc *bn254.Encoder, cpus int) error
+	}{
+		{2*N - 1, func(dec *bn254.Decoder, enc *bn254.Encoder, cpus int) error {
+			p, err := scaleG1(dec, enc, 2*N-1, tau, nil, cpus)
+			if err == nil {
+				c.G1.Tau.Set(p)
+			}
+			return err
+		}},
+		{N, func(dec *bn254.Decoder, enc *bn254.Encoder, cpus int) error {
+			p, err := scaleG1(dec, enc, N, tau, alpha, cpus)
+			if err == nil {
+				c.G1.Alpha.Set(p)
+			}
+			return err
+		}},
+		{N, func(dec *bn254.Decoder, enc *bn254.Encoder, cpus int) error {
+			p, err := scaleG1(dec, enc, N, tau, beta, cpus)
+			if err == nil {
+				c.G1.Beta.Set(p)
+			}
+			return err
+		}},
+		{N, func(dec *bn254.Decoder, enc *bn254.Encoder, cpus int) error {
+			p, err := scaleG2(dec, enc, N, tau, cpus)
+			if err == nil {
+				c.G2.Tau.Set(p)
+			}
+			return err
+		}},
+	}
+	cpus := sectionCpus([]int{2*N - 1, N, N, g2Cost * N})
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(sections))
+	for i, s := range sections {
+		pointSize := int64(bn254.SizeOfG1AffineCompressed)
+		if i == len(sections)-1 {
+			pointSize = bn254.SizeOfG2AffineCompressed
+		}
+		wg.Add(1)
+		go func(i int, position int64, scale func(*bn254.Decoder, *bn254.Encoder, int) error) {
+			defer wg.Done()
+			reader := bufio.NewReader(io.NewSectionReader(inputFile, position, 1<<62))
+			writer := bufio.NewWriter(&offsetWriter{file: outputFile, offset: position})
+			if errs[i] = scale(common.NewBatchDecoder(reader), bn254.NewEncoder(writer), cpus[i]); errs[i] == nil {
+				errs[i] = writer.Flush()
+			}
+		}(i, position, s.scale)
+		position += int64(s.size) * pointSize
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
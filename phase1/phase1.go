@@ -2,19 +2,22 @@ package phase1
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
 	"os"
+	"runtime"
 
 	"github.com/bnb-chain/zkbnb-setup/common"
 	"github.com/consensys/gnark-crypto/ecc/bn254"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 )
 
-func Transform(inputPath, outputPath string, inPower, outPower byte) error {
+func Transform(inputPath, outputPath, ceremony string, inPower, outPower byte) error {
 	// Input file is in uncompressed representation
 	const G1Size = 64
 	const G2Size = 128
@@ -34,11 +37,13 @@ func Transform(inputPath, outputPath string, inPower, outPower byte) error {
 	}
 	defer outputFile.Close()
 
-	// Write header
-	header := Header{Power: outPower, Contributions: 0}
+	// Write header, the origin digest is filled once the parameters are transformed
+	header := Header{Power: outPower, Contributions: 0, CeremonyID: ceremonyID(ceremony), OriginDigest: make([]byte, 32)}
 	if err := header.writeTo(outputFile); err != nil {
 		return err
 	}
+	sha := sha256.New()
+	output := io.MultiWriter(outputFile, sha)
 
 	inN := int(math.Pow(2, float64(inPower)))
 	outN := int(math.Pow(2, float64(outPower)))
@@ -51,38 +56,48 @@ func Transform(inputPath, outputPath string, inPower, outPower byte) error {
 
 	// Transform TauG1
 	fmt.Println("Transforming TauG1")
-	if err := transformG1(inputFile, outputFile, posTauG1, 2*outN-1); err != nil {
+	if err := transformG1(inputFile, output, posTauG1, 2*outN-1); err != nil {
 		return err
 	}
 
 	// Transform AlphaG1
 	fmt.Println("Transforming AlphaG1")
-	if err := transformG1(inputFile, outputFile, posAlphaG1, outN); err != nil {
+	if err := transformG1(inputFile, output, posAlphaG1, outN); err != nil {
 		return err
 	}
 
 	// Transform BetaG1
 	fmt.Println("Transforming BetaG1")
-	if err := transformG1(inputFile, outputFile, posBetaG1, outN); err != nil {
+	if err := transformG1(inputFile, output, posBetaG1, outN); err != nil {
 		return err
 	}
 
 	// Transform TauG2
 	fmt.Println("Transforming TauG2")
-	if err := transformG2(inputFile, outputFile, posTauG2, outN); err != nil {
+	if err := transformG2(inputFile, output, posTauG2, outN); err != nil {
 		return err
 	}
 
 	// Transform BetaG2
 	fmt.Println("Transforming BetaG2")
-	if err := transformG2(inputFile, outputFile, posBetaG2, 1); err != nil {
+	if err := transformG2(inputFile, output, posBetaG2, 1); err != nil {
 		return err
 	}
 
+	// Bind the transformed parameters as the origin of the ceremony
+	header.OriginDigest = sha.Sum(nil)
+	if _, err := outputFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := header.writeTo(outputFile); err != nil {
+		return err
+	}
+	fmt.Println("Origin Digest := ", hex.EncodeToString(header.OriginDigest))
+
 	return nil
 }
 
-func Initialize(power byte, outputPath string) error {
+func Initialize(power byte, ceremony, outputPath string) error {
 	_, _, g1, g2 := bn254.Generators()
 	// output outputFile
 	outputFile, err := os.Create(outputPath)
@@ -93,6 +108,8 @@ func Initialize(power byte, outputPath string) error {
 	var header Header
 
 	header.Power = power
+	header.CeremonyID = ceremonyID(ceremony)
+	header.OriginDigest = generatorsDigest(power)
 	N := int(math.Pow(2, float64(power)))
 	fmt.Printf("Power %d supports up to %d constraints\n", power, N)
 
@@ -148,7 +165,7 @@ func Initialize(power byte, outputPath string) error {
 	return nil
 }
 
-func Contribute(inputPath, outputPath string) error {
+func Contribute(inputPath, outputPath string, opts ContributeOptions) error {
 	// Input file
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
@@ -175,14 +192,6 @@ func Contribute(inputPath, outputPath string) error {
 		return err
 	}
 
-	// Use buffered IO to write parameters efficiently
-	reader := bufio.NewReader(inputFile)
-	writer := bufio.NewWriter(outputFile)
-	defer writer.Flush()
-
-	dec := bn254.NewDecoder(reader)
-	enc := bn254.NewEncoder(writer)
-
 	// Sample toxic parameters
 	fmt.Println("Sampling toxic parameters Tau, Alpha, and Beta")
 	var tau, alpha, beta, one fr.Element
@@ -192,36 +201,31 @@ func Contribute(inputPath, outputPath string) error {
 	one.SetOne()
 
 	var contribution Contribution
-	var firstG1 *bn254.G1Affine
-	var firstG2 *bn254.G2Affine
-
-	// Process Tau section
-	fmt.Println("Processing TauG1")
-	if firstG1, err = scaleG1(dec, enc, 2*N-1, &tau, nil); err != nil {
-		return err
-	}
-	contribution.G1.Tau.Set(firstG1)
-
-	// Process AlphaTauG1 section
-	fmt.Println("Processing AlphaTauG1")
-	if firstG1, err = scaleG1(dec, enc, N, &tau, &alpha); err != nil {
-		return err
-	}
-	contribution.G1.Alpha.Set(firstG1)
+	var reader *bufio.Reader
+	var writer *bufio.Writer
+	if opts.ParallelSections {
+		fmt.Println("Processing TauG1, AlphaTauG1, BetaTauG1, and TauG2 in parallel")
+		position := header.Size()
+		if err := scaleSections(inputFile, outputFile, position, N, &tau, &alpha, &beta, &contribution); err != nil {
+			return err
+		}
 
-	// Process BetaTauG1 section
-	fmt.Println("Processing BetaTauG1")
-	if firstG1, err = scaleG1(dec, enc, N, &tau, &beta); err != nil {
-		return err
+		// The remaining sections follow TauG2
+		position += parametersSize(header.Power) - 64
+		reader = bufio.NewReader(io.NewSectionReader(inputFile, position, 1<<62))
+		writer = bufio.NewWriter(&offsetWriter{file: outputFile, offset: position})
+	} else {
+		// Use buffered IO to write parameters efficiently
+		reader = bufio.NewReader(inputFile)
+		writer = bufio.NewWriter(outputFile)
+		if err := scaleSequentially(common.NewBatchDecoder(reader), bn254.NewEncoder(writer), N, &tau, &alpha, &beta, &contribution); err != nil {
+			return err
+		}
 	}
-	contribution.G1.Beta.Set(firstG1)
+	defer writer.Flush()
 
-	// Process TauG2 section
-	fmt.Println("Processing TauG2")
-	if firstG2, err = scaleG2(dec, enc, N, &tau); err != nil {
-		return err
-	}
-	contribution.G2.Tau.Set(firstG2)
+	dec := bn254.NewDecoder(reader)
+	enc := bn254.NewEncoder(writer)
 
 	// Process BetaG2 section
 	fmt.Println("Processing BetaG2")
@@ -249,10 +253,10 @@ func Contribute(inputPath, outputPath string) error {
 		}
 	}
 
-	// Get hash of previous contribution
+	// Get hash of previous contribution, the first contribution is challenged by the ceremony origin
 	var prevHash []byte
 	if nExistingContributions == 0 {
-		prevHash = nil
+		prevHash = header.challenge()
 	} else {
 		prevHash = c.Hash
 	}
@@ -272,7 +276,54 @@ func Contribute(inputPath, outputPath string) error {
 	return nil
 }
 
+// scaleSequentially processes the sections of the parameters one after another
+func scaleSequentially(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau, alpha, beta *fr.Element, c *Contribution) error {
+	cpus := runtime.NumCPU()
+
+	// Process Tau section
+	fmt.Println("Processing TauG1")
+	firstG1, err := scaleG1(dec, enc, 2*N-1, tau, nil, cpus)
+	if err != nil {
+		return err
+	}
+	c.G1.Tau.Set(firstG1)
+
+	// Process AlphaTauG1 section
+	fmt.Println("Processing AlphaTauG1")
+	if firstG1, err = scaleG1(dec, enc, N, tau, alpha, cpus); err != nil {
+		return err
+	}
+	c.G1.Alpha.Set(firstG1)
+
+	// Process BetaTauG1 section
+	fmt.Println("Processing BetaTauG1")
+	if firstG1, err = scaleG1(dec, enc, N, tau, beta, cpus); err != nil {
+		return err
+	}
+	c.G1.Beta.Set(firstG1)
+
+	// Process TauG2 section
+	fmt.Println("Processing TauG2")
+	firstG2, err := scaleG2(dec, enc, N, tau, cpus)
+	if err != nil {
+		return err
+	}
+	c.G2.Tau.Set(firstG2)
+	return nil
+}
+
+// Verify verifies the contributions of a phase 1 file bound to a ceremony origin,
+// transformedPath is the transformed PPoT file the ceremony started from or empty if it started from the generators
 func Verify(inputPath, transformedPath string) error {
+	return verify(inputPath, transformedPath, false)
+}
+
+// VerifyLegacy verifies the contributions of a phase 1 file which may predate binding to a ceremony origin
+func VerifyLegacy(inputPath, transformedPath string) error {
+	return verify(inputPath, transformedPath, true)
+}
+
+func verify(inputPath, transformedPath string, legacy bool) error {
 	// Input file
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
@@ -286,12 +337,19 @@ func Verify(inputPath, transformedPath string) error {
 		return err
 	}
 	fmt.Printf("Power := %d and  #Contributions := %d\n", header.Power, header.Contributions)
+	if header.IsLegacy() {
+		if !legacy {
+			return errors.New("phase 1 file isn't bound to a ceremony origin, use legacy verification for files of earlier versions")
+		}
+	} else {
+		fmt.Printf("Ceremony ID := %s\nOrigin Digest := %s\n", hex.EncodeToString(header.CeremonyID), hex.EncodeToString(header.OriginDigest))
+	}
 	N := int(math.Pow(2, float64(header.Power)))
 
 	// Use buffered IO to write parameters efficiently
 	buffSize := int(math.Pow(2, 20))
 	reader := bufio.NewReaderSize(inputFile, buffSize)
-	dec := bn254.NewDecoder(reader)
+	dec := common.NewBatchDecoder(reader)
 
 	fmt.Println("Processing TauG1")
 	tau1L1, tau1L2, err := linearCombinationG1(dec, 2*N-1)
@@ -319,20 +377,21 @@ func Verify(inputPath, transformedPath string) error {
 
 	fmt.Println("Processing BetaG2")
 	var betaG2 bn254.G2Affine
-	if err = dec.Decode(&betaG2); err != nil {
+	if err = bn254.NewDecoder(reader).Decode(&betaG2); err != nil {
 		return err
 	}
 
-	// Verify contributions
+	// Verify contributions, their pairing checks and those of the parameters are batched into a single one
+	var batch common.RatioBatch
 	var current Contribution
-	prev, err := defaultContribution(transformedPath)
-	if err!=nil {
+	prev, err := defaultContribution(&header, transformedPath)
+	if err != nil {
 		return err
 	}
 	for i := 0; i < int(header.Contributions); i++ {
 		current.ReadFrom(reader)
 		fmt.Printf("Verifying contribution %d with Hash := %s\n", i+1, hex.EncodeToString(current.Hash))
-		if err := verifyContribution(current, prev); err != nil {
+		if err := verifyContribution(current, prev, i+1, &batch); err != nil {
 			return err
 		}
 		prev = current
@@ -340,28 +399,13 @@ func Verify(inputPath, transformedPath string) error {
 
 	// Verify consistency of parameters update
 	_, _, g1, g2 := bn254.Generators()
-	// Read and verify TauG1
-	fmt.Println("Verifying powers of TauG1")
-	if !common.SameRatio(tau1L1, tau1L2, current.G2.Tau, g2) {
-		return errors.New("failed pairing check")
-	}
-
-	// Read and verify AlphaTauG1
-	fmt.Println("Verifying powers of AlphaTauG1")
-	if !common.SameRatio(alphaTau1L1, alphaTau1L2, current.G2.Tau, g2) {
-		return errors.New("failed pairing check")
-	}
-
-	// Read and verify BetaTauG1
-	fmt.Println("Verifying powers of BetaTauG1")
-	if !common.SameRatio(betaTau1L1, betaTau1L2, current.G2.Tau, g2) {
-		return errors.New("failed pairing check")
-	}
-
-	// Read and verify TauG2
-	fmt.Println("Verifying powers of TauG2")
-	if !common.SameRatio(g1, current.G1.Tau, tau2L1, tau2L2) {
-		return errors.New("failed pairing check")
+	fmt.Println("Verifying powers of TauG1, AlphaTauG1, BetaTauG1, and TauG2")
+	batch.Add(tau1L1, tau1L2, current.G2.Tau, g2, errors.New("failed pairing check of powers of TauG1"))
+	batch.Add(alphaTau1L1, alphaTau1L2, current.G2.Tau, g2, errors.New("failed pairing check of powers of AlphaTauG1"))
+	batch.Add(betaTau1L1, betaTau1L2, current.G2.Tau, g2, errors.New("failed pairing check of powers of BetaTauG1"))
+	batch.Add(g1, current.G1.Tau, tau2L1, tau2L2, errors.New("failed pairing check of powers of TauG2"))
+	if err := batch.Verify(); err != nil {
+		return err
 	}
 
 	// Verify BetaG2
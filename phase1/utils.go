@@ -3,7 +3,9 @@ package phase1
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"math/big"
@@ -36,7 +38,9 @@ func batchMul(a []fr.Element, b *fr.Element) {
 	})
 }
 
-func scaleG1(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau, multiplicand *fr.Element) (*bn254.G1Affine, error) {
+// scaleG1 multiplies the N points read from dec by the powers of τ, times multiplicand if it isn't nil, on cpus cores,
+// writes them to enc and returns [τ], or [α] or [β] for a multiplicand
+func scaleG1(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau, multiplicand *fr.Element, cpus int) (*bn254.G1Affine, error) {
 	// Allocate batch with smallest of (N, batchSize)
 	var initialSize = int(math.Min(float64(N), float64(batchSize)))
 	buff := make([]bn254.G1Affine, initialSize)
@@ -54,6 +58,9 @@ func scaleG1(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau, multiplicand *f
 				return nil, err
 			}
 		}
+		if err := common.CheckG1(buff[:readCount]); err != nil {
+			return nil, err
+		}
 
 		// Compute powers for the current batch
 		scalars = powers(&startPower, tau, readCount)
@@ -73,7 +80,7 @@ func scaleG1(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau, multiplicand *f
 				scalars[i].BigInt(&tmpBi)
 				buff[i].ScalarMultiplication(&buff[i], &tmpBi)
 			}
-		})
+		}, cpus)
 
 		// Write the batch
 		for i := 0; i < readCount; i++ {
@@ -99,7 +106,8 @@ func scaleG1(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau, multiplicand *f
 	return &firstPoint, nil
 }
 
-func scaleG2(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau *fr.Element) (*bn254.G2Affine, error) {
+// scaleG2 is scaleG1 on G2 without multiplicand
+func scaleG2(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau *fr.Element, cpus int) (*bn254.G2Affine, error) {
 	// Allocate batch with smallest of (N, batchSize)
 	var initialSize = int(math.Min(float64(N), float64(batchSize)))
 	buff := make([]bn254.G2Affine, initialSize)
@@ -117,6 +125,9 @@ func scaleG2(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau *fr.Element) (*b
 				return nil, err
 			}
 		}
+		if err := common.CheckG2(buff[:readCount]); err != nil {
+			return nil, err
+		}
 
 		// Compute powers for the current batch
 		scalars = powers(&startPower, tau, readCount)
@@ -131,7 +142,7 @@ func scaleG2(dec *bn254.Decoder, enc *bn254.Encoder, N int, tau *fr.Element) (*b
 				scalars[i].BigInt(&tmpBi)
 				buff[i].ScalarMultiplication(&buff[i], &tmpBi)
 			}
-		})
+		}, cpus)
 
 		// Write the batch
 		for i := 0; i < readCount; i++ {
@@ -161,6 +172,7 @@ func randomize(r []fr.Element) {
 	})
 }
 
+// linearCombinationG1 returns random combinations of the first and last N-1 of the N points read from dec
 func linearCombinationG1(dec *bn254.Decoder, N int) (bn254.G1Affine, bn254.G1Affine, error) {
 	// Allocate batch with smallest of (N, batchSize)
 	var initialSize = int(math.Min(float64(N), float64(batchSize)))
@@ -177,6 +189,9 @@ func linearCombinationG1(dec *bn254.Decoder, N int) (bn254.G1Affine, bn254.G1Aff
 				return L1, L2, err
 			}
 		}
+		if err := common.CheckG1(buff[:readCount]); err != nil {
+			return L1, L2, err
+		}
 
 		// Generate randomness
 		randomize(r)
@@ -193,6 +208,7 @@ func linearCombinationG1(dec *bn254.Decoder, N int) (bn254.G1Affine, bn254.G1Aff
 	return L1, L2, nil
 }
 
+// linearCombinationG2 is linearCombinationG1 on G2
 func linearCombinationG2(dec *bn254.Decoder, N int) (bn254.G2Affine, bn254.G2Affine, error) {
 	// Allocate batch with smallest of (N, batchSize)
 	var initialSize = int(math.Min(float64(N), float64(batchSize)))
@@ -209,6 +225,9 @@ func linearCombinationG2(dec *bn254.Decoder, N int) (bn254.G2Affine, bn254.G2Aff
 				return L1, L2, err
 			}
 		}
+		if err := common.CheckG2(buff[:readCount]); err != nil {
+			return L1, L2, err
+		}
 
 		// Generate randomness
 		randomize(r)
@@ -225,39 +244,33 @@ func linearCombinationG2(dec *bn254.Decoder, N int) (bn254.G2Affine, bn254.G2Aff
 	return L1, L2, nil
 }
 
-func verifyContribution(current, prev Contribution) error {
+// verifyContribution checks the hash of the current contribution, the index-th one, and adds the checks of its update
+// of the previous one to batch
+func verifyContribution(current, prev Contribution, index int, batch *common.RatioBatch) error {
 	// Compute SP for τ, α, β
 	tauSP := common.GenSP(current.PublicKeys.Tau.S, current.PublicKeys.Tau.SX, prev.Hash[:], 1)
 	alphaSP := common.GenSP(current.PublicKeys.Alpha.S, current.PublicKeys.Alpha.SX, prev.Hash[:], 2)
 	betaSP := common.GenSP(current.PublicKeys.Beta.S, current.PublicKeys.Beta.SX, prev.Hash[:], 3)
 
 	// Check for knowledge of toxic parameters
-	if !common.SameRatio(current.PublicKeys.Tau.S, current.PublicKeys.Tau.SX, current.PublicKeys.Tau.SPX, tauSP) {
-		return errors.New("couldn't verify knowledge of Tau")
-	}
-	if !common.SameRatio(current.PublicKeys.Alpha.S, current.PublicKeys.Alpha.SX, current.PublicKeys.Alpha.SPX, alphaSP) {
-		return errors.New("couldn't verify knowledge of Alpha")
-	}
-	if !common.SameRatio(current.PublicKeys.Beta.S, current.PublicKeys.Beta.SX, current.PublicKeys.Beta.SPX, betaSP) {
-		return errors.New("couldn't verify knowledge of Beta")
-	}
+	batch.Add(current.PublicKeys.Tau.S, current.PublicKeys.Tau.SX, current.PublicKeys.Tau.SPX, tauSP,
+		fmt.Errorf("couldn't verify knowledge of Tau in contribution %d", index))
+	batch.Add(current.PublicKeys.Alpha.S, current.PublicKeys.Alpha.SX, current.PublicKeys.Alpha.SPX, alphaSP,
+		fmt.Errorf("couldn't verify knowledge of Alpha in contribution %d", index))
+	batch.Add(current.PublicKeys.Beta.S, current.PublicKeys.Beta.SX, current.PublicKeys.Beta.SPX, betaSP,
+		fmt.Errorf("couldn't verify knowledge of Beta in contribution %d", index))
 
 	// Check for valid updates using previous parameters
-	if !common.SameRatio(current.G1.Tau, prev.G1.Tau, tauSP, current.PublicKeys.Tau.SPX) {
-		return errors.New("couldn't verify that TauG1 is based on previous contribution")
-	}
-	if !common.SameRatio(current.G1.Alpha, prev.G1.Alpha, alphaSP, current.PublicKeys.Alpha.SPX) {
-		return errors.New("couldn't verify that AlphaTauG1 is based on previous contribution")
-	}
-	if !common.SameRatio(current.G1.Beta, prev.G1.Beta, betaSP, current.PublicKeys.Beta.SPX) {
-		return errors.New("couldn't verify that BetaTauG1 is based on previous contribution")
-	}
-	if !common.SameRatio(current.PublicKeys.Tau.S, current.PublicKeys.Tau.SX, current.G2.Tau, prev.G2.Tau) {
-		return errors.New("couldn't verify that TauG2 is based on previous contribution")
-	}
-	if !common.SameRatio(current.PublicKeys.Beta.S, current.PublicKeys.Beta.SX, current.G2.Beta, prev.G2.Beta) {
-		return errors.New("couldn't verify that BetaG2 is based on previous contribution")
-	}
+	batch.Add(current.G1.Tau, prev.G1.Tau, tauSP, current.PublicKeys.Tau.SPX,
+		fmt.Errorf("couldn't verify that TauG1 is based on previous contribution in contribution %d", index))
+	batch.Add(current.G1.Alpha, prev.G1.Alpha, alphaSP, current.PublicKeys.Alpha.SPX,
+		fmt.Errorf("couldn't verify that AlphaTauG1 is based on previous contribution in contribution %d", index))
+	batch.Add(current.G1.Beta, prev.G1.Beta, betaSP, current.PublicKeys.Beta.SPX,
+		fmt.Errorf("couldn't verify that BetaTauG1 is based on previous contribution in contribution %d", index))
+	batch.Add(current.PublicKeys.Tau.S, current.PublicKeys.Tau.SX, current.G2.Tau, prev.G2.Tau,
+		fmt.Errorf("couldn't verify that TauG2 is based on previous contribution in contribution %d", index))
+	batch.Add(current.PublicKeys.Beta.S, current.PublicKeys.Beta.SX, current.G2.Beta, prev.G2.Beta,
+		fmt.Errorf("couldn't verify that BetaG2 is based on previous contribution in contribution %d", index))
 
 	// Check hash of the contribution
 	h := computeHash(&current)
@@ -268,13 +281,13 @@ func verifyContribution(current, prev Contribution) error {
 	return nil
 }
 
-func transformG1(inputFile, outputFile *os.File, position int64, size int) error {
+func transformG1(inputFile *os.File, output io.Writer, position int64, size int) error {
 	var g1 bn254.G1Affine
 	if _, err := inputFile.Seek(position, io.SeekStart); err != nil {
 		return err
 	}
 	reader := bufio.NewReader(inputFile)
-	writer := bufio.NewWriter(outputFile)
+	writer := bufio.NewWriter(output)
 	defer writer.Flush()
 
 	dec := bn254.NewDecoder(reader)
@@ -291,13 +304,13 @@ func transformG1(inputFile, outputFile *os.File, position int64, size int) error
 	return nil
 }
 
-func transformG2(inputFile, outputFile *os.File, position int64, size int) error {
+func transformG2(inputFile *os.File, output io.Writer, position int64, size int) error {
 	var g2 bn254.G2Affine
 	if _, err := inputFile.Seek(position, io.SeekStart); err != nil {
 		return err
 	}
 	reader := bufio.NewReader(inputFile)
-	writer := bufio.NewWriter(outputFile)
+	writer := bufio.NewWriter(output)
 	defer writer.Flush()
 
 	dec := bn254.NewDecoder(reader)
@@ -313,3 +326,21 @@ func transformG2(inputFile, outputFile *os.File, position int64, size int) error
 	}
 	return nil
 }
+
+// parametersSize returns the size of the compressed parameters following the header
+func parametersSize(power byte) int64 {
+	N := int64(math.Pow(2, float64(power)))
+	return 32*(2*N-1) + 32*N + 32*N + 64*N + 64
+}
+
+// ParametersDigest returns SHA256 of the parameters of a phase 1 file, which the SRS of phase 2 is derived from
+func ParametersDigest(file *os.File, header *Header) ([]byte, error) {
+	if _, err := file.Seek(header.Size(), io.SeekStart); err != nil {
+		return nil, err
+	}
+	sha := sha256.New()
+	if _, err := io.CopyN(sha, file, parametersSize(header.Power)); err != nil {
+		return nil, err
+	}
+	return sha.Sum(nil), nil
+}
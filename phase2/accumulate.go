@@ -0,0 +1,198 @@
+package phase2
+
+import (
+	"math/big"
+	"runtime"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// wireTerms is the transpose of the linear expressions of a batch of constraints, restricted to a chunk of wires.
+// The terms of the w-th wire of the chunk are rows[offsets[w]:offsets[w+1]], the constraints relative to the batch,
+// with the coefficients coeffs[offsets[w]:offsets[w+1]]. Terms with a zero coefficient are dropped.
+type wireTerms struct {
+	offsets []int
+	cursors []int
+	rows    []uint32
+	coeffs  []uint32
+	nbTasks int // cores the wires are sharded across, all of them if zero
+}
+
+// build lists the terms picked by terms of the batch of constraints on the wires [from, from+nbWires)
+func (wt *wireTerms) build(constraints []constraint.R1C, terms func(*constraint.R1C) constraint.LinearExpression, from, nbWires int) {
+	to := from + nbWires
+	if cap(wt.offsets) < nbWires+1 {
+		wt.offsets = make([]int, nbWires+1)
+		wt.cursors = make([]int, nbWires)
+	}
+	wt.offsets = wt.offsets[:nbWires+1]
+	wt.cursors = wt.cursors[:nbWires]
+	for w := range wt.offsets {
+		wt.offsets[w] = 0
+	}
+
+	// Count the terms of each wire, then lay them out wire after wire
+	for i := range constraints {
+		for _, t := range terms(&constraints[i]) {
+			if w := int(t.WireID()); w >= from && w < to && t.CoeffID() != constraint.CoeffIdZero {
+				wt.offsets[w-from+1]++
+			}
+		}
+	}
+	for w := 0; w < nbWires; w++ {
+		wt.offsets[w+1] += wt.offsets[w]
+	}
+	copy(wt.cursors, wt.offsets)
+	nbTerms := wt.offsets[nbWires]
+	if cap(wt.rows) < nbTerms {
+		wt.rows = make([]uint32, nbTerms)
+		wt.coeffs = make([]uint32, nbTerms)
+	}
+	wt.rows = wt.rows[:nbTerms]
+	wt.coeffs = wt.coeffs[:nbTerms]
+	for i := range constraints {
+		for _, t := range terms(&constraints[i]) {
+			if w := int(t.WireID()); w >= from && w < to && t.CoeffID() != constraint.CoeffIdZero {
+				k := wt.cursors[w-from]
+				wt.rows[k] = uint32(i)
+				wt.coeffs[k] = uint32(t.CoeffID())
+				wt.cursors[w-from]++
+			}
+		}
+	}
+}
+
+// shard runs work on ranges of wires holding about the same number of terms, one per core.
+// Wires holding more terms than a core's share are left to heavy, which is called on them afterwards.
+func (wt *wireTerms) shard(work func(from, to int), heavy func(w int)) {
+	nbTasks := wt.nbTasks
+	if nbTasks == 0 {
+		nbTasks = runtime.NumCPU()
+	}
+	nbWires := len(wt.offsets) - 1
+	share := wt.offsets[nbWires]/nbTasks + 1
+
+	var wg sync.WaitGroup
+	var heavyWires []int
+	from, nbTerms := 0, 0
+	for w := 0; w < nbWires; w++ {
+		count := wt.offsets[w+1] - wt.offsets[w]
+		if count > share {
+			heavyWires = append(heavyWires, w)
+		} else {
+			nbTerms += count
+		}
+		if nbTerms >= share || count > share || w == nbWires-1 {
+			to := w + 1
+			if count > share {
+				to = w
+			}
+			if from < to {
+				wg.Add(1)
+				go func(from, to int) {
+					work(from, to)
+					wg.Done()
+				}(from, to)
+			}
+			from, nbTerms = w+1, 0
+		}
+	}
+	wg.Wait()
+
+	for _, w := range heavyWires {
+		heavy(w)
+	}
+}
+
+// scalars returns the coefficients of the terms of the w-th wire
+func (wt *wireTerms) scalars(r1cs *cs_bn254.R1CS, w int) []fr.Element {
+	scalars := make([]fr.Element, wt.offsets[w+1]-wt.offsets[w])
+	for k := range scalars {
+		scalars[k] = r1cs.Coefficients[wt.coeffs[wt.offsets[w]+k]]
+	}
+	return scalars
+}
+
+// accumulateG1Batch adds to res[w] the sparse multi-scalar multiplication of the w-th wire of wt over points.
+// Light wires are accumulated in Jacobian coordinates on their own core, heavy ones are multi-exponentiated on all cores.
+func accumulateG1Batch(r1cs *cs_bn254.R1CS, wt *wireTerms, points []bn254.G1Affine, res []bn254.G1Jac) error {
+	var msmErr error
+	wt.shard(func(from, to int) {
+		var neg bn254.G1Affine
+		var tmp bn254.G1Jac
+		var coeff big.Int
+		for w := from; w < to; w++ {
+			for k := wt.offsets[w]; k < wt.offsets[w+1]; k++ {
+				p := &points[wt.rows[k]]
+				switch int(wt.coeffs[k]) {
+				case constraint.CoeffIdOne:
+					res[w].AddMixed(p)
+				case constraint.CoeffIdMinusOne:
+					res[w].AddMixed(neg.Neg(p))
+				case constraint.CoeffIdTwo:
+					res[w].AddMixed(p).AddMixed(p)
+				default:
+					r1cs.Coefficients[wt.coeffs[k]].BigInt(&coeff)
+					res[w].AddAssign(tmp.ScalarMultiplicationAffine(p, &coeff))
+				}
+			}
+		}
+	}, func(w int) {
+		if msmErr != nil {
+			return
+		}
+		bases := make([]bn254.G1Affine, wt.offsets[w+1]-wt.offsets[w])
+		for k := range bases {
+			bases[k] = points[wt.rows[wt.offsets[w]+k]]
+		}
+		var tmp bn254.G1Jac
+		if _, msmErr = tmp.MultiExp(bases, wt.scalars(r1cs, w), ecc.MultiExpConfig{}); msmErr == nil {
+			res[w].AddAssign(&tmp)
+		}
+	})
+	return msmErr
+}
+
+// accumulateG2Batch is accumulateG1Batch on G2
+func accumulateG2Batch(r1cs *cs_bn254.R1CS, wt *wireTerms, points []bn254.G2Affine, res []bn254.G2Jac) error {
+	var msmErr error
+	wt.shard(func(from, to int) {
+		var neg bn254.G2Affine
+		var tmp bn254.G2Jac
+		var coeff big.Int
+		for w := from; w < to; w++ {
+			for k := wt.offsets[w]; k < wt.offsets[w+1]; k++ {
+				p := &points[wt.rows[k]]
+				switch int(wt.coeffs[k]) {
+				case constraint.CoeffIdOne:
+					res[w].AddMixed(p)
+				case constraint.CoeffIdMinusOne:
+					res[w].AddMixed(neg.Neg(p))
+				case constraint.CoeffIdTwo:
+					res[w].AddMixed(p).AddMixed(p)
+				default:
+					r1cs.Coefficients[wt.coeffs[k]].BigInt(&coeff)
+					res[w].AddAssign(tmp.FromAffine(p).ScalarMultiplication(&tmp, &coeff))
+				}
+			}
+		}
+	}, func(w int) {
+		if msmErr != nil {
+			return
+		}
+		bases := make([]bn254.G2Affine, wt.offsets[w+1]-wt.offsets[w])
+		for k := range bases {
+			bases[k] = points[wt.rows[wt.offsets[w]+k]]
+		}
+		var tmp bn254.G2Jac
+		if _, msmErr = tmp.MultiExp(bases, wt.scalars(r1cs, w), ecc.MultiExpConfig{}); msmErr == nil {
+			res[w].AddAssign(&tmp)
+		}
+	})
+	return msmErr
+}
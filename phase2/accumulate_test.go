@@ -0,0 +1,192 @@
+package phase2
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// randomR1CS returns nbConstraints constraints on nbWires wires with terms of every kind of coefficient,
+// the constant wire is used by every constraint so it is a heavy wire
+func randomR1CS(rng *rand.Rand, nbConstraints, nbWires int) *cs_bn254.R1CS {
+	r1cs := cs_bn254.NewR1CS(nbConstraints)
+	coeffs := []int64{0, 1, 2, -1, -2, 3, 5, -7}
+	term := func(wire int) constraint.Term {
+		var c fr.Element
+		if i := rng.Intn(len(coeffs) + 1); i < len(coeffs) {
+			c.SetInt64(coeffs[i])
+		} else {
+			c.SetRandom()
+		}
+		var coeff constraint.Coeff
+		copy(coeff[:], c[:])
+		return r1cs.MakeTerm(&coeff, wire)
+	}
+	expression := func() constraint.LinearExpression {
+		e := constraint.LinearExpression{term(0)}
+		for j := rng.Intn(4); j > 0; j-- {
+			e = append(e, term(rng.Intn(nbWires)))
+		}
+		return e
+	}
+	for i := 0; i < nbConstraints; i++ {
+		r1cs.Constraints = append(r1cs.Constraints, constraint.R1C{L: expression(), R: expression(), O: expression()})
+	}
+	return r1cs
+}
+
+func randomG1(n int) []bn254.G1Affine {
+	_, _, g1, _ := bn254.Generators()
+	points := make([]bn254.G1Affine, n)
+	var s fr.Element
+	var b big.Int
+	for i := range points {
+		s.SetRandom()
+		points[i].ScalarMultiplication(&g1, s.BigInt(&b))
+	}
+	return points
+}
+
+func randomG2(n int) []bn254.G2Affine {
+	_, _, _, g2 := bn254.Generators()
+	points := make([]bn254.G2Affine, n)
+	var s fr.Element
+	var b big.Int
+	for i := range points {
+		s.SetRandom()
+		points[i].ScalarMultiplication(&g2, s.BigInt(&b))
+	}
+	return points
+}
+
+// accumulateG1 adds the term of value to res in affine coordinates, as the initialization did term after term
+func accumulateG1(r1cs *cs_bn254.R1CS, res *bn254.G1Affine, t constraint.Term, value *bn254.G1Affine) {
+	cID := t.CoeffID()
+	switch cID {
+	case constraint.CoeffIdZero:
+		return
+	case constraint.CoeffIdOne:
+		res.Add(res, value)
+	case constraint.CoeffIdMinusOne:
+		res.Sub(res, value)
+	case constraint.CoeffIdTwo:
+		res.Add(res, value).Add(res, value)
+	default:
+		var tmp bn254.G1Affine
+		var vBi big.Int
+		r1cs.Coefficients[cID].BigInt(&vBi)
+		tmp.ScalarMultiplication(value, &vBi)
+		res.Add(res, &tmp)
+	}
+}
+
+// accumulateG2 is accumulateG1 on G2
+func accumulateG2(r1cs *cs_bn254.R1CS, res *bn254.G2Affine, t constraint.Term, value *bn254.G2Affine) {
+	cID := t.CoeffID()
+	switch cID {
+	case constraint.CoeffIdZero:
+		return
+	case constraint.CoeffIdOne:
+		res.Add(res, value)
+	case constraint.CoeffIdMinusOne:
+		res.Sub(res, value)
+	case constraint.CoeffIdTwo:
+		res.Add(res, value).Add(res, value)
+	default:
+		var tmp bn254.G2Affine
+		var vBi big.Int
+		r1cs.Coefficients[cID].BigInt(&vBi)
+		tmp.ScalarMultiplication(value, &vBi)
+		res.Add(res, &tmp)
+	}
+}
+
+// TestAccumulateBatch checks the sparse multi-scalar multiplications against the term after term accumulation,
+// for a chunk of wires accumulated over several batches of constraints, sharded so that the constant wire is heavy
+func TestAccumulateBatch(t *testing.T) {
+	const nbConstraints, nbWires, batchSize = 600, 150, 256
+	rng := rand.New(rand.NewSource(1))
+	r1cs := randomR1CS(rng, nbConstraints, nbWires)
+	srsG1 := randomG1(nbConstraints)
+	srsG2 := randomG2(nbConstraints)
+
+	expectedG1 := make([]bn254.G1Affine, nbWires)
+	expectedG2 := make([]bn254.G2Affine, nbWires)
+	for i, c := range r1cs.Constraints {
+		for _, t := range c.R {
+			accumulateG1(r1cs, &expectedG1[t.WireID()], t, &srsG1[i])
+			accumulateG2(r1cs, &expectedG2[t.WireID()], t, &srsG2[i])
+		}
+	}
+
+	for _, chunk := range [][2]int{{0, nbWires}, {40, 90}} {
+		from, to := chunk[0], chunk[1]
+		wt := wireTerms{nbTasks: 4}
+		resG1 := make([]bn254.G1Jac, to-from)
+		resG2 := make([]bn254.G2Jac, to-from)
+		for start := 0; start < nbConstraints; start += batchSize {
+			count := minInt(batchSize, nbConstraints-start)
+			wt.build(r1cs.Constraints[start:start+count], right, from, to-from)
+			if err := accumulateG1Batch(r1cs, &wt, srsG1[start:start+count], resG1); err != nil {
+				t.Fatal(err)
+			}
+			if err := accumulateG2Batch(r1cs, &wt, srsG2[start:start+count], resG2); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for w := from; w < to; w++ {
+			var p1 bn254.G1Affine
+			var p2 bn254.G2Affine
+			p1.FromJacobian(&resG1[w-from])
+			p2.FromJacobian(&resG2[w-from])
+			if !p1.Equal(&expectedG1[w]) {
+				t.Fatalf("[B]₁ of wire %d differs", w)
+			}
+			if !p2.Equal(&expectedG2[w]) {
+				t.Fatalf("[B]₂ of wire %d differs", w)
+			}
+		}
+	}
+}
+
+func benchmarkR1CS() (*cs_bn254.R1CS, []bn254.G1Affine) {
+	const nbConstraints, nbWires = 1 << 14, 1 << 14
+	rng := rand.New(rand.NewSource(1))
+	return randomR1CS(rng, nbConstraints, nbWires), randomG1(nbConstraints)
+}
+
+func BenchmarkAccumulateG1(b *testing.B) {
+	r1cs, srs := benchmarkR1CS()
+	nbWires := 1 << 14
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		res := make([]bn254.G1Affine, nbWires)
+		for i, c := range r1cs.Constraints {
+			for _, t := range c.L {
+				accumulateG1(r1cs, &res[t.WireID()], t, &srs[i])
+			}
+		}
+	}
+}
+
+func BenchmarkAccumulateG1Batch(b *testing.B) {
+	r1cs, srs := benchmarkR1CS()
+	nbWires := 1 << 14
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var wt wireTerms
+		res := make([]bn254.G1Jac, nbWires)
+		wt.build(r1cs.Constraints, left, 0, nbWires)
+		if err := accumulateG1Batch(r1cs, &wt, srs, res); err != nil {
+			b.Fatal(err)
+		}
+		keys := make([]bn254.G1Affine, nbWires)
+		fromJacobianG1(keys, res)
+	}
+}
@@ -0,0 +1,214 @@
+package phase2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// section of a file identified by its name and size, a negative size spans until the end of file
+type section struct {
+	name string
+	size int64
+}
+
+func phase2Sections(header *Header) []section {
+	return []section{
+		{"[δ]₁", 32},
+		{"[δ]₂", 64},
+		{"Z", 32 * int64(header.Domain-1)},
+		{"PKK", 32 * int64(header.Witness)},
+		{"Contributions", -1},
+	}
+}
+
+func evaluationsSections(header *Header) []section {
+	return []section{
+		{"[α]₁", 32},
+		{"[β]₁", 32},
+		{"[β]₂", 64},
+		{"[A]₁", 4 + 32*int64(header.Wires)},
+		{"[B]₁", 4 + 32*int64(header.Wires)},
+		{"[B]₂", 4 + 64*int64(header.Wires)},
+		{"VKK", 4 + 32*int64(header.Public)},
+		{"CKK", 4 + 32*int64(header.PrivateCommitted)},
+		{"CommitmentInfo", -1},
+	}
+}
+
+func lagrangeSections(header *Header) []section {
+	return []section{
+		{"Lagrange [τ]₁", 4 + 32*int64(header.Domain)},
+		{"Lagrange [ατ]₁", 4 + 32*int64(header.Domain)},
+		{"Lagrange [βτ]₁", 4 + 32*int64(header.Domain)},
+		{"Lagrange [τ]₂", 4 + 64*int64(header.Domain)},
+	}
+}
+
+// Audit deterministically recomputes the initialization of phase 2 from the phase 1 file and the R1CS,
+// then compares the digests of each section of the given initial phase 2 and evaluations files.
+// If lagPath isn't empty, the Lagrange SRS is compared as well, and commitmentsPath and opts are passed as to Initialize.
+// The Lagrange SRS is converted from the phase 1 file rather than copied from opts.PreparedDir.
+// Each section is compared on its own, located in each file by the header of its phase 2 state,
+// so that every mismatch is reported, even if the headers differ.
+func Audit(phase1Path, r1csPath, phase2Path, evalsPath, lagPath, commitmentsPath string, opts Options) error {
+	opts.setDefaults()
+	opts.PreparedDir = ""
+	tmpDir, err := os.MkdirTemp("", "p2audit")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fmt.Println("Recomputing initialization of phase 2")
+	expPhase2Path := filepath.Join(tmpDir, "0.ph2")
+	expLagPath := filepath.Join(tmpDir, lagrangePath)
+	expEvalsPath := filepath.Join(tmpDir, evaluationsPath)
+	if err := initialize(phase1Path, r1csPath, commitmentsPath, expPhase2Path, expLagPath, expEvalsPath, &opts); err != nil {
+		return err
+	}
+
+	fmt.Println("Comparing section digests")
+	var mismatches []string
+
+	// Phase 2 header and parameters
+	expHeader, actHeader, m, err := auditPhase2(expPhase2Path, phase2Path)
+	if err != nil {
+		return err
+	}
+	mismatches = append(mismatches, m...)
+
+	// Evaluations
+	m, err = auditFile(expEvalsPath, evalsPath, evaluationsSections(expHeader), evaluationsSections(actHeader))
+	if err != nil {
+		return err
+	}
+	mismatches = append(mismatches, m...)
+
+	// Lagrange SRS
+	if lagPath != "" {
+		m, err = auditFile(expLagPath, lagPath, lagrangeSections(expHeader), lagrangeSections(actHeader))
+		if err != nil {
+			return err
+		}
+		mismatches = append(mismatches, m...)
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("audit failed, mismatch in sections: %s", strings.Join(mismatches, ", "))
+	}
+	fmt.Println("Audit has been successful")
+	return nil
+}
+
+// auditPhase2 compares the header and the sections of the phase 2 files, and returns their headers
+func auditPhase2(expectedPath, actualPath string) (*Header, *Header, []string, error) {
+	expectedFile, err := os.Open(expectedPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer expectedFile.Close()
+	actualFile, err := os.Open(actualPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer actualFile.Close()
+	expectedReader := bufio.NewReader(expectedFile)
+	actualReader := bufio.NewReader(actualFile)
+
+	var expected, actual Header
+	if err := expected.Read(expectedReader); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := actual.Read(actualReader); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var mismatches []string
+	expDigest, err := headerDigest(&expected)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	actDigest, err := headerDigest(&actual)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !reportDigest("Header", expDigest, actDigest) {
+		mismatches = append(mismatches, "Header")
+	}
+
+	m, err := compareSections(expectedReader, actualReader, phase2Sections(&expected), phase2Sections(&actual))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &expected, &actual, append(mismatches, m...), nil
+}
+
+func auditFile(expectedPath, actualPath string, expected, actual []section) ([]string, error) {
+	expectedFile, err := os.Open(expectedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer expectedFile.Close()
+	actualFile, err := os.Open(actualPath)
+	if err != nil {
+		return nil, err
+	}
+	defer actualFile.Close()
+
+	return compareSections(bufio.NewReader(expectedFile), bufio.NewReader(actualFile), expected, actual)
+}
+
+// compareSections compares the digests of the sections read from each reader, located by the sizes each file gives them
+func compareSections(expectedReader, actualReader io.Reader, expected, actual []section) ([]string, error) {
+	var mismatches []string
+	for i, s := range expected {
+		expDigest, err := sectionDigest(expectedReader, s.size)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+		actDigest, err := sectionDigest(actualReader, actual[i].size)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+		if !reportDigest(s.name, expDigest, actDigest) {
+			mismatches = append(mismatches, s.name)
+		}
+	}
+	return mismatches, nil
+}
+
+func sectionDigest(reader io.Reader, size int64) ([]byte, error) {
+	sha := sha256.New()
+	if size < 0 {
+		if _, err := io.Copy(sha, reader); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.CopyN(sha, reader, size); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return sha.Sum(nil), nil
+}
+
+func headerDigest(header *Header) ([]byte, error) {
+	sha := sha256.New()
+	if err := header.write(sha); err != nil {
+		return nil, err
+	}
+	return sha.Sum(nil), nil
+}
+
+func reportDigest(name string, expected, actual []byte) bool {
+	if bytes.Equal(expected, actual) {
+		fmt.Printf("%-16s OK        %s\n", name, hex.EncodeToString(actual))
+		return true
+	}
+	fmt.Printf("%-16s MISMATCH  expected %s got %s\n", name, hex.EncodeToString(expected), hex.EncodeToString(actual))
+	return false
+}
@@ -0,0 +1,365 @@
+package phase2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const bundleMagic = "ph2b"
+
+// Manifest lists the circuits of a bundle in the order their phase 2 states are stored
+type Manifest struct {
+	Circuits []string
+}
+
+func (m *Manifest) read(reader io.Reader) error {
+	magic := make([]byte, len(bundleMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return err
+	}
+	if string(magic) != bundleMagic {
+		return errors.New("input isn't a phase 2 bundle")
+	}
+	dec := gob.NewDecoder(reader)
+	if err := dec.Decode(m); err != nil {
+		return err
+	}
+	if len(m.Circuits) == 0 {
+		return errors.New("bundle has no circuits")
+	}
+	return nil
+}
+
+func (m *Manifest) write(writer io.Writer) error {
+	if _, err := writer.Write([]byte(bundleMagic)); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(writer)
+	return enc.Encode(*m)
+}
+
+func (m *Manifest) Equal(m2 *Manifest) bool {
+	if len(m.Circuits) != len(m2.Circuits) {
+		return false
+	}
+	for i := range m.Circuits {
+		if m.Circuits[i] != m2.Circuits[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsBundle reports whether the file at path is a phase 2 bundle
+func IsBundle(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	magic := make([]byte, len(bundleMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(magic) == bundleMagic, nil
+}
+
+// Labels returns the label of the circuit of a phase 2 file, or of every circuit of a bundle
+func Labels(path string) ([]string, error) {
+	isBundle, err := IsBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	nbCircuits := 1
+	if isBundle {
+		var manifest Manifest
+		if err := manifest.read(reader); err != nil {
+			return nil, err
+		}
+		nbCircuits = len(manifest.Circuits)
+	}
+
+	labels := make([]string, nbCircuits)
+	for i := range labels {
+		var header Header
+		if err := header.Read(reader); err != nil {
+			return nil, err
+		}
+		labels[i] = header.Label
+		if i+1 < nbCircuits {
+			if _, err := reader.Discard(int(stateSize(&header))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return labels, nil
+}
+
+// NewBundle packs the given phase 2 states into a single bundle, each circuit is
+// labelled by the name of its file without extension
+func NewBundle(bundlePath string, phase2Paths []string) error {
+	if len(phase2Paths) == 0 {
+		return errors.New("please provide at least one phase 2 file")
+	}
+	var manifest Manifest
+	seen := make(map[string]bool)
+	for _, p := range phase2Paths {
+		label := circuitLabel(p)
+		if seen[label] {
+			return fmt.Errorf("circuit %s is duplicated in the bundle", label)
+		}
+		seen[label] = true
+		manifest.Circuits = append(manifest.Circuits, label)
+	}
+
+	bundleFile, err := os.Create(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer bundleFile.Close()
+	writer := bufio.NewWriter(bundleFile)
+	defer writer.Flush()
+
+	if err := manifest.write(writer); err != nil {
+		return err
+	}
+	for i, p := range phase2Paths {
+		fmt.Printf("Adding circuit %s\n", manifest.Circuits[i])
+		if err := copyFile(writer, p); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Bundle of %d circuits has been created successfully\n", len(phase2Paths))
+	return nil
+}
+
+// ExtractBundle writes the phase 2 state of each circuit in the bundle to <outputDir>/<circuit>.ph2
+func ExtractBundle(bundlePath, outputDir string) error {
+	bundleFile, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer bundleFile.Close()
+	reader := bufio.NewReader(bundleFile)
+
+	var manifest Manifest
+	if err := manifest.read(reader); err != nil {
+		return err
+	}
+
+	for _, label := range manifest.Circuits {
+		outputPath := filepath.Join(outputDir, label+".ph2")
+		fmt.Printf("Extracting circuit %s to %s\n", label, outputPath)
+		if err := extractState(reader, outputPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContributeBundle contributes to every circuit of the bundle using an independent δ per circuit
+func ContributeBundle(inputPath, outputPath string) error {
+	// Input file
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+	reader := bufio.NewReader(inputFile)
+
+	// Output file
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	// Read/Write manifest
+	var manifest Manifest
+	if err := manifest.read(reader); err != nil {
+		return err
+	}
+	if err := manifest.write(writer); err != nil {
+		return err
+	}
+
+	hashes := make([][]byte, len(manifest.Circuits))
+	for i, label := range manifest.Circuits {
+		fmt.Printf("Contributing to circuit %s\n", label)
+		contribution, err := contribute(reader, writer)
+		if err != nil {
+			return fmt.Errorf("circuit %s: %w", label, err)
+		}
+		hashes[i] = contribution.Hash
+		fmt.Printf("Circuit %s Contribution Hash := %s\n", label, hex.EncodeToString(contribution.Hash))
+	}
+
+	fmt.Println("Contirbution has been successful!")
+	fmt.Println("Bundle Contribution Hash := ", hex.EncodeToString(bundleHash(hashes)))
+
+	return nil
+}
+
+// VerifyBundle verifies every circuit of the bundle against its origin in the origin bundle
+func VerifyBundle(inputPath, originPath string) error {
+	// Input file
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	// Origin bundle of the initial phase 2 states
+	originFile, err := os.Open(originPath)
+	if err != nil {
+		return err
+	}
+	defer originFile.Close()
+
+	inputReader := bufio.NewReader(inputFile)
+	originReader := bufio.NewReader(originFile)
+
+	var curManifest, orgManifest Manifest
+	if err := curManifest.read(inputReader); err != nil {
+		return err
+	}
+	if err := orgManifest.read(originReader); err != nil {
+		return err
+	}
+	if !curManifest.Equal(&orgManifest) {
+		return errors.New("there is a mismatch between origin and current bundle manifests")
+	}
+
+	// hashes[i][j] is the hash of contribution j to circuit i
+	hashes := make([][][]byte, len(curManifest.Circuits))
+	for i, label := range curManifest.Circuits {
+		fmt.Printf("Verifying circuit %s\n", label)
+		if hashes[i], err = verify(inputReader, originReader); err != nil {
+			return fmt.Errorf("circuit %s: %w", label, err)
+		}
+		if len(hashes[i]) != len(hashes[0]) {
+			return fmt.Errorf("circuit %s has %d contributions but circuit %s has %d", label, len(hashes[i]), curManifest.Circuits[0], len(hashes[0]))
+		}
+	}
+
+	// Output the combined hash of each contribution session
+	for j := range hashes[0] {
+		session := make([][]byte, len(hashes))
+		for i := range hashes {
+			session[i] = hashes[i][j]
+		}
+		fmt.Printf("Bundle contribution %d with Hash := %s\n", j+1, hex.EncodeToString(bundleHash(session)))
+	}
+
+	fmt.Println("Contributions verification has been successful")
+	return nil
+}
+
+// VerifyBundleTransition checks that every circuit of the bundle in nextPath is updated
+// from the bundle in prevPath by exactly one valid contribution
+func VerifyBundleTransition(prevPath, nextPath string) error {
+	// Previous bundle
+	prevFile, err := os.Open(prevPath)
+	if err != nil {
+		return err
+	}
+	defer prevFile.Close()
+
+	// Next bundle
+	nextFile, err := os.Open(nextPath)
+	if err != nil {
+		return err
+	}
+	defer nextFile.Close()
+
+	prevReader := bufio.NewReader(prevFile)
+	nextReader := bufio.NewReader(nextFile)
+
+	var prevManifest, nextManifest Manifest
+	if err := prevManifest.read(prevReader); err != nil {
+		return err
+	}
+	if err := nextManifest.read(nextReader); err != nil {
+		return err
+	}
+	if !nextManifest.Equal(&prevManifest) {
+		return errors.New("there is a mismatch between previous and next bundle manifests")
+	}
+
+	hashes := make([][]byte, len(nextManifest.Circuits))
+	for i, label := range nextManifest.Circuits {
+		fmt.Printf("Verifying circuit %s\n", label)
+		c, err := verifyTransition(prevReader, nextReader)
+		if err != nil {
+			return fmt.Errorf("circuit %s: %w", label, err)
+		}
+		hashes[i] = c.Hash
+	}
+
+	fmt.Println("Bundle Contribution Hash := ", hex.EncodeToString(bundleHash(hashes)))
+	fmt.Println("Contribution verification has been successful")
+	return nil
+}
+
+// bundleHash combines the hashes of the contributions made in one session to all circuits of a bundle
+func bundleHash(hashes [][]byte) []byte {
+	sha := sha256.New()
+	sha.Write(bytes.Join(hashes, nil))
+	return sha.Sum(nil)
+}
+
+// stateSize returns the size of a phase 2 state following its header
+func stateSize(header *Header) int64 {
+	return 32 + 64 + 32*int64(header.Domain-1) + 32*int64(header.Witness) + ContributionSize*int64(header.Contributions)
+}
+
+func extractState(reader *bufio.Reader, outputPath string) error {
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	var header Header
+	if err := header.Read(reader); err != nil {
+		return err
+	}
+	if err := header.write(writer); err != nil {
+		return err
+	}
+	_, err = io.CopyN(writer, reader, stateSize(&header))
+	return err
+}
+
+func copyFile(writer io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(writer, file)
+	return err
+}
@@ -0,0 +1,204 @@
+package phase2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// Sections of the circom R1CS binary format
+const (
+	circomHeader      uint32 = 1
+	circomConstraints uint32 = 2
+)
+
+var circomMagic = []byte("r1cs")
+
+// circomR1CSHeader is the header section of circom R1CS.
+// Wires are ordered as: one, public outputs, public inputs, private inputs, internal wires
+type circomR1CSHeader struct {
+	Wires       uint32
+	PubOut      uint32
+	PubIn       uint32
+	PrvIn       uint32
+	Labels      uint64
+	Constraints uint32
+}
+
+// ReadR1CS reads the constraint system at r1csPath, which is either gnark R1CS or circom R1CS detected by its magic
+func ReadR1CS(r1csPath string) (*cs_bn254.R1CS, error) {
+	circom, err := isCircomR1CS(r1csPath)
+	if err != nil {
+		return nil, err
+	}
+	r1csFile, err := os.Open(r1csPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r1csFile.Close()
+	if circom {
+		return readCircomR1CS(r1csFile)
+	}
+
+	var r1cs cs_bn254.R1CS
+	if _, err := r1cs.ReadFrom(r1csFile); err != nil {
+		return nil, err
+	}
+	return &r1cs, nil
+}
+
+// isCircomR1CS tells whether the constraint system at r1csPath is circom R1CS by its magic
+func isCircomR1CS(r1csPath string) (bool, error) {
+	r1csFile, err := os.Open(r1csPath)
+	if err != nil {
+		return false, err
+	}
+	defer r1csFile.Close()
+
+	magic := make([]byte, len(circomMagic))
+	if _, err := io.ReadFull(r1csFile, magic); err != nil {
+		return false, err
+	}
+	return bytes.Equal(magic, circomMagic), nil
+}
+
+// readCircomR1CS converts circom R1CS into gnark R1CS.
+// Both systems put the constant wire first followed by the public then private wires,
+// so wire IDs are kept as they are and public outputs are treated as public inputs.
+func readCircomR1CS(r1csFile *os.File) (*cs_bn254.R1CS, error) {
+	// Locate the sections, they can be in any order
+	var version, nbSections uint32
+	if _, err := r1csFile.Seek(int64(len(circomMagic)), io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r1csFile, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported circom R1CS version %d", version)
+	}
+	if err := binary.Read(r1csFile, binary.LittleEndian, &nbSections); err != nil {
+		return nil, err
+	}
+	sections := make(map[uint32]int64)
+	for i := uint32(0); i < nbSections; i++ {
+		var sectionType uint32
+		var size uint64
+		if err := binary.Read(r1csFile, binary.LittleEndian, &sectionType); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r1csFile, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+		pos, err := r1csFile.Seek(int64(size), io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		sections[sectionType] = pos - int64(size)
+	}
+	headerPos, ok := sections[circomHeader]
+	if !ok {
+		return nil, errors.New("circom R1CS has no header section")
+	}
+	constraintsPos, ok := sections[circomConstraints]
+	if !ok {
+		return nil, errors.New("circom R1CS has no constraints section")
+	}
+
+	// Read the header
+	if _, err := r1csFile.Seek(headerPos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(r1csFile)
+	var n8 uint32
+	if err := binary.Read(reader, binary.LittleEndian, &n8); err != nil {
+		return nil, err
+	}
+	prime := make([]byte, n8)
+	if _, err := io.ReadFull(reader, prime); err != nil {
+		return nil, err
+	}
+	if n8 != fr.Bytes || new(big.Int).SetBytes(reverse(prime)).Cmp(fr.Modulus()) != 0 {
+		return nil, errors.New("circom R1CS isn't defined over the scalar field of bn254")
+	}
+	var header circomR1CSHeader
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	nbPublic := 1 + header.PubOut + header.PubIn
+	if header.Wires < nbPublic+header.PrvIn {
+		return nil, errors.New("circom R1CS has inconsistent number of wires")
+	}
+
+	r1cs := cs_bn254.NewR1CS(int(header.Constraints))
+	r1cs.Public = make([]string, nbPublic)
+	r1cs.Secret = make([]string, header.PrvIn)
+	r1cs.NbInternalVariables = int(header.Wires - nbPublic - header.PrvIn)
+	for i := range r1cs.Public {
+		r1cs.Public[i] = fmt.Sprintf("w%d", i)
+	}
+	for i := range r1cs.Secret {
+		r1cs.Secret[i] = fmt.Sprintf("w%d", int(nbPublic)+i)
+	}
+
+	// Read the constraints A·B = C
+	if _, err := r1csFile.Seek(constraintsPos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader.Reset(r1csFile)
+	for i := uint32(0); i < header.Constraints; i++ {
+		var r1c constraint.R1C
+		for _, l := range []*constraint.LinearExpression{&r1c.L, &r1c.R, &r1c.O} {
+			var err error
+			if *l, err = readCircomLinearExpression(reader, r1cs, header.Wires); err != nil {
+				return nil, err
+			}
+		}
+		r1cs.AddConstraint(r1c)
+	}
+	return r1cs, nil
+}
+
+func readCircomLinearExpression(reader io.Reader, r1cs *cs_bn254.R1CS, nbWires uint32) (constraint.LinearExpression, error) {
+	var nbTerms uint32
+	if err := binary.Read(reader, binary.LittleEndian, &nbTerms); err != nil {
+		return nil, err
+	}
+	l := make(constraint.LinearExpression, nbTerms)
+	value := make([]byte, fr.Bytes)
+	for j := range l {
+		var wireID uint32
+		if err := binary.Read(reader, binary.LittleEndian, &wireID); err != nil {
+			return nil, err
+		}
+		if wireID >= nbWires {
+			return nil, fmt.Errorf("circom R1CS refers to wire %d out of %d", wireID, nbWires)
+		}
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		var e fr.Element
+		e.SetBytes(reverse(value))
+		var coeff constraint.Coeff
+		copy(coeff[:], e[:])
+		l[j] = r1cs.MakeTerm(&coeff, int(wireID))
+	}
+	return l, nil
+}
+
+// reverse reverses b in place to convert little-endian to big-endian and returns it
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
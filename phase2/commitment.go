@@ -0,0 +1,125 @@
+package phase2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// Commitment is a Pedersen commitment of the circuit, laid out as Groth16Commitment of consensys/gnark v0.9
+// so that circuits calling api.Commit several times can be described.
+type Commitment struct {
+	PublicAndCommitmentCommitted []int // public wires, then the wires of previous commitments, committed to
+	PrivateCommitted             []int // private wires committed to
+	CommitmentIndex              int   // wire of the commitment
+	NbPublicCommitted            int
+}
+
+// NbPrivateCommitted returns the total number of private wires committed to by commitments
+func NbPrivateCommitted(commitments []Commitment) int {
+	nb := 0
+	for i := range commitments {
+		nb += len(commitments[i].PrivateCommitted)
+	}
+	return nb
+}
+
+// CommitmentsFromInfo returns the commitment of the gnark fork CommitmentInfo if any
+func CommitmentsFromInfo(info constraint.Commitment) []Commitment {
+	if !info.Is() {
+		return nil
+	}
+	nbPublic := info.NbPublicCommitted()
+	return []Commitment{{
+		PublicAndCommitmentCommitted: info.Committed[:nbPublic],
+		PrivateCommitted:             info.PrivateCommitted(),
+		CommitmentIndex:              info.CommitmentIndex,
+		NbPublicCommitted:            nbPublic,
+	}}
+}
+
+// readCommitments returns the commitments of r1cs read from r1csPath. gnark R1CS holds its commitment in CommitmentInfo,
+// which the gnark fork limits to one. circom R1CS holds none, so the commitments of a circuit compiled by
+// consensys/gnark v0.9 and exported to circom R1CS, however many, are given as the JSON of its Groth16Commitments
+// at commitmentsPath.
+func readCommitments(r1cs *cs_bn254.R1CS, r1csPath, commitmentsPath string) ([]Commitment, error) {
+	circom, err := isCircomR1CS(r1csPath)
+	if err != nil {
+		return nil, err
+	}
+	if !circom {
+		if commitmentsPath != "" {
+			return nil, errors.New("the commitments of gnark R1CS are read from the constraint system, JSON is only read for circom R1CS")
+		}
+		return r1csCommitments(r1cs)
+	}
+	if commitmentsPath == "" {
+		return nil, nil
+	}
+
+	var commitments []Commitment
+	data, err := os.ReadFile(commitmentsPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &commitments); err != nil {
+		return nil, err
+	}
+	if err := checkCommitments(r1cs, commitments); err != nil {
+		return nil, err
+	}
+	return commitments, nil
+}
+
+// r1csCommitments returns the commitments held by the CommitmentInfo of gnark R1CS
+func r1csCommitments(r1cs *cs_bn254.R1CS) ([]Commitment, error) {
+	commitments := CommitmentsFromInfo(r1cs.CommitmentInfo)
+	if err := checkCommitments(r1cs, commitments); err != nil {
+		return nil, err
+	}
+	return commitments, nil
+}
+
+// checkCommitments checks the wires of commitments against r1cs.
+// Commitment wires are private to the prover and increasing, as the frontend allocates them
+func checkCommitments(r1cs *cs_bn254.R1CS, commitments []Commitment) error {
+	nbPublic := r1cs.GetNbPublicVariables()
+	nbWires := nbPublic + r1cs.GetNbSecretVariables() + r1cs.NbInternalVariables
+	for i, c := range commitments {
+		if c.CommitmentIndex < nbPublic || c.CommitmentIndex >= nbWires || (i > 0 && c.CommitmentIndex <= commitments[i-1].CommitmentIndex) {
+			return fmt.Errorf("commitment %d has invalid wire %d", i, c.CommitmentIndex)
+		}
+		if c.NbPublicCommitted > len(c.PublicAndCommitmentCommitted) {
+			return fmt.Errorf("commitment %d has invalid #PublicCommitted", i)
+		}
+		for _, w := range c.PublicAndCommitmentCommitted[:c.NbPublicCommitted] {
+			if w < 0 || w >= nbPublic {
+				return fmt.Errorf("commitment %d commits to wire %d which isn't public", i, w)
+			}
+		}
+		for _, w := range c.PublicAndCommitmentCommitted[c.NbPublicCommitted:] {
+			if !isCommitmentWire(commitments[:i], w) {
+				return fmt.Errorf("commitment %d commits to wire %d which isn't a previous commitment", i, w)
+			}
+		}
+		for j, w := range c.PrivateCommitted {
+			if w < nbPublic || w >= nbWires || (j > 0 && w <= c.PrivateCommitted[j-1]) || isCommitmentWire(commitments, w) {
+				return fmt.Errorf("commitment %d commits to wire %d which isn't private", i, w)
+			}
+		}
+	}
+	return nil
+}
+
+func isCommitmentWire(commitments []Commitment, wire int) bool {
+	for i := range commitments {
+		if commitments[i].CommitmentIndex == wire {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package phase2
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"io"
 
@@ -8,6 +9,8 @@ import (
 	"github.com/consensys/gnark-crypto/ecc/bn254"
 )
 
+const ContributionSize = 192
+
 type Contribution struct {
 	Delta     bn254.G1Affine
 	PublicKey common.PublicKey
@@ -32,7 +35,7 @@ func (c *Contribution) writeTo(writer io.Writer) (int64, error) {
 	return int64(nBytes), err
 }
 
-func (c *Contribution) readFrom(reader io.Reader) (int64, error) {
+func (c *Contribution) ReadFrom(reader io.Reader) (int64, error) {
 	toDecode := []interface{}{
 		&c.Delta,
 		&c.PublicKey.S,
@@ -47,10 +50,18 @@ func (c *Contribution) readFrom(reader io.Reader) (int64, error) {
 		}
 	}
 	c.Hash = make([]byte, 32)
-	nBytes, err := reader.Read(c.Hash)
+	nBytes, err := io.ReadFull(reader, c.Hash)
 	return int64(nBytes), err
 }
 
+func (c *Contribution) equal(c2 *Contribution) bool {
+	return c.Delta.Equal(&c2.Delta) &&
+		c.PublicKey.S.Equal(&c2.PublicKey.S) &&
+		c.PublicKey.SX.Equal(&c2.PublicKey.SX) &&
+		c.PublicKey.SPX.Equal(&c2.PublicKey.SPX) &&
+		bytes.Equal(c.Hash, c2.Hash)
+}
+
 func computeHash(c *Contribution) []byte {
 	sha := sha256.New()
 	toEncode := []interface{}{
@@ -1,6 +1,9 @@
 package phase2
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"io"
 )
@@ -13,6 +16,9 @@ type Header struct {
 	Constraints      int
 	Domain           int
 	Contributions    int
+	Phase1Digest     []byte // SHA256 of the parameters of the phase 1 file the state is initialized from
+	R1CSDigest       []byte // SHA256 of the circuit R1CS
+	Label            string // Human readable name of the circuit
 }
 
 func (h *Header) Read(reader io.Reader) error {
@@ -37,8 +43,33 @@ func (h *Header) Equal(h2 *Header) bool {
 		h.Public == h2.Public &&
 		h.PrivateCommitted == h2.PrivateCommitted &&
 		h.Constraints == h2.Constraints &&
-		h.Domain == h2.Domain {
+		h.Domain == h2.Domain &&
+		bytes.Equal(h.Phase1Digest, h2.Phase1Digest) &&
+		bytes.Equal(h.R1CSDigest, h2.R1CSDigest) &&
+		h.Label == h2.Label {
 		return true
 	}
 	return false
 }
+
+// IsLegacy reports whether the header predates binding phase 2 to its phase 1 source and circuit
+func (h *Header) IsLegacy() bool {
+	return len(h.Phase1Digest) == 0 && len(h.R1CSDigest) == 0
+}
+
+// challenge returns the challenge of the first contribution which is derived from the initial state,
+// so a contribution can't be replayed across circuits or ceremonies.
+// Legacy headers keep the nil challenge
+func (h *Header) challenge() []byte {
+	if h.IsLegacy() {
+		return nil
+	}
+	sha := sha256.New()
+	for _, v := range []int{h.Wires, h.Witness, h.Public, h.PrivateCommitted, h.Constraints, h.Domain} {
+		binary.Write(sha, binary.BigEndian, uint64(v))
+	}
+	sha.Write(h.Phase1Digest)
+	sha.Write(h.R1CSDigest)
+	sha.Write([]byte(h.Label))
+	return sha.Sum(nil)
+}
@@ -2,15 +2,23 @@ package phase2
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/bnb-chain/zkbnb-setup/lagrange"
 	"github.com/consensys/gnark-crypto/ecc/bn254"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
 )
 
-func lagrangeG1(phase1File, lagFile *os.File, position int64, domain *fft.Domain) error {
+func lagrangeG1(phase1File, lagFile *os.File, position int64, domain *fft.Domain, memoryBudget int64) error {
+	if int64(domain.Cardinality)*(g1JacSize+g1Size+twiddleSize) > memoryBudget {
+		return convertOnDisk(lagFile, int(domain.Cardinality), bn254.SizeOfG1AffineCompressed, func(offset int64) error {
+			return lagrange.ConvertG1OnDisk(phase1File, position, lagFile, offset, domain, filepath.Dir(lagFile.Name()), memoryBudget)
+		})
+	}
+
 	if _, err := phase1File.Seek(position, io.SeekStart); err != nil {
 		return err
 	}
@@ -37,7 +45,13 @@ func lagrangeG1(phase1File, lagFile *os.File, position int64, domain *fft.Domain
 	return nil
 }
 
-func lagrangeG2(phase1File, lagFile *os.File, position int64, domain *fft.Domain) error {
+func lagrangeG2(phase1File, lagFile *os.File, position int64, domain *fft.Domain, memoryBudget int64) error {
+	if int64(domain.Cardinality)*(g2JacSize+g2Size+twiddleSize) > memoryBudget {
+		return convertOnDisk(lagFile, int(domain.Cardinality), bn254.SizeOfG2AffineCompressed, func(offset int64) error {
+			return lagrange.ConvertG2OnDisk(phase1File, position, lagFile, offset, domain, filepath.Dir(lagFile.Name()), memoryBudget)
+		})
+	}
+
 	// Seek to position
 	if _, err := phase1File.Seek(position, io.SeekStart); err != nil {
 		return err
@@ -64,3 +78,22 @@ func lagrangeG2(phase1File, lagFile *os.File, position int64, domain *fft.Domain
 	}
 	return nil
 }
+
+// convertOnDisk appends to lagFile the length of the slice of n points of size bytes, then the points which
+// convert writes at the given offset, for domains whose conversion doesn't fit in the memory budget
+func convertOnDisk(lagFile *os.File, n, size int, convert func(offset int64) error) error {
+	fmt.Println("Converting on disk within the memory budget")
+	offset, err := lagFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err := writeSliceLength(lagFile, n); err != nil {
+		return err
+	}
+	offset += 4
+	if err := convert(offset); err != nil {
+		return err
+	}
+	_, err = lagFile.Seek(offset+int64(n*size), io.SeekStart)
+	return err
+}
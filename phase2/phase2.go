@@ -12,7 +12,43 @@ import (
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 )
 
-func Initialize(phase1Path, r1csPath, phase2Path string) error {
+// Files generated by the initialization and used at the end of phase 2 for keys extraction
+const (
+	lagrangePath    = "srs.lag"
+	evaluationsPath = "evals"
+)
+
+// Options tunes the initialization of phase 2
+type Options struct {
+	// MemoryBudget bounds in bytes the points the initialization holds at once, the R1CS aside, DefaultMemoryBudget if zero.
+	// Domains which don't fit are converted to the Lagrange basis on disk, the keys which don't fit are accumulated
+	// by chunks of wires, one pass on the Lagrange SRS per chunk, and Z is spilled to a temporary file next to the phase 2 file.
+	MemoryBudget int64
+
+	// PreparedDir is the directory of the Lagrange SRS prepared by Prepare. If it holds the one of the domain
+	// of the circuit, prepared from the same phase 1 parameters, the initialization copies it instead of converting.
+	PreparedDir string
+}
+
+func (opts *Options) setDefaults() {
+	if opts.MemoryBudget == 0 {
+		opts.MemoryBudget = DefaultMemoryBudget
+	}
+}
+
+// Initialize initializes phase 2 for the circuit at r1csPath. If commitmentsPath isn't empty,
+// it holds the JSON of the Pedersen commitments of the circuit, which is only read for circom R1CS, see readCommitments.
+func Initialize(phase1Path, r1csPath, phase2Path, commitmentsPath string, opts Options) error {
+	opts.setDefaults()
+	if err := initialize(phase1Path, r1csPath, commitmentsPath, phase2Path, lagrangePath, evaluationsPath, &opts); err != nil {
+		return err
+	}
+
+	fmt.Println("Phase 2 has been initialized successfully")
+	return nil
+}
+
+func initialize(phase1Path, r1csPath, commitmentsPath, phase2Path, lagPath, evalsPath string, opts *Options) error {
 	phase1File, err := os.Open(phase1Path)
 	if err != nil {
 		return err
@@ -26,32 +62,31 @@ func Initialize(phase1Path, r1csPath, phase2Path string) error {
 	defer phase2File.Close()
 
 	// 1. Process Headers
-	header1, header2, err := processHeader(r1csPath, phase1File, phase2File)
+	header1, header2, commitments, err := processHeader(r1csPath, commitmentsPath, phase1File, phase2File)
 	if err != nil {
 		return err
 	}
 
 	// 2. Convert phase 1 SRS to Lagrange basis
-	if err := processLagrange(header1, header2, phase1File, phase2File); err != nil {
+	if err := processLagrange(header1, header2, phase1File, lagPath, opts); err != nil {
 		return err
 	}
 
 	// 3. Process evaluation
-	if err := processEvaluations(header1, header2, r1csPath, phase1File); err != nil {
+	if err := processEvaluations(header1, header2, r1csPath, phase1File, lagPath, evalsPath, opts); err != nil {
 		return err
 	}
 
 	// Evaluate Delta and Z
-	if err := processDeltaAndZ(header1, header2, phase1File, phase2File); err != nil {
+	if err := processDeltaAndZ(header1, header2, phase1File, phase2File, opts); err != nil {
 		return err
 	}
 
 	// Process parameters
-	if err := processPVCKK(header1, header2, r1csPath, phase2File); err != nil {
+	if err := processPVCKK(header1, header2, r1csPath, commitments, phase2File, lagPath, evalsPath, opts); err != nil {
 		return err
 	}
 
-	fmt.Println("Phase 2 has been initialized successfully")
 	return nil
 }
 
@@ -63,7 +98,6 @@ func Contribute(inputPath, outputPath string) error {
 	}
 	defer inputFile.Close()
 	reader := bufio.NewReader(inputFile)
-	dec := bn254.NewDecoder(reader)
 
 	// Output file
 	outputFile, err := os.Create(outputPath)
@@ -73,17 +107,34 @@ func Contribute(inputPath, outputPath string) error {
 	defer outputFile.Close()
 	writer := bufio.NewWriter(outputFile)
 	defer writer.Flush()
+
+	contribution, err := contribute(reader, writer)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Contirbution has been successful!")
+	fmt.Println("Contribution Hash := ", hex.EncodeToString(contribution.Hash))
+
+	return nil
+}
+
+func contribute(reader *bufio.Reader, writer *bufio.Writer) (*Contribution, error) {
+	dec := bn254.NewDecoder(reader)
 	enc := bn254.NewEncoder(writer)
 
 	// Read/Write header with extra contribution
 	var header Header
 	if err := header.Read(reader); err != nil {
-		return err
+		return nil, err
+	}
+	if header.Label != "" {
+		fmt.Printf("Circuit := %s\n", header.Label)
 	}
 	fmt.Printf("Current #Contributions := %d\n", header.Contributions)
 	header.Contributions++
 	if err := header.write(writer); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Sample toxic parameters
@@ -101,49 +152,50 @@ func Contribute(inputPath, outputPath string) error {
 	fmt.Println("Processing DeltaG1 and DeltaG2")
 	var delta1 bn254.G1Affine
 	if err := dec.Decode(&delta1); err != nil {
-		return err
+		return nil, err
 	}
 	delta1.ScalarMultiplication(&delta1, &deltaBI)
 	if err := enc.Encode(&delta1); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Process δ₂
 	var delta2 bn254.G2Affine
 	if err := dec.Decode(&delta2); err != nil {
-		return err
+		return nil, err
 	}
 	delta2.ScalarMultiplication(&delta2, &deltaBI)
 	if err := enc.Encode(&delta2); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Process Z using δ⁻¹
-	if err = scale(dec, enc, header.Domain-1, &deltaInvBI); err != nil {
-		return err
+	// Process Z using δ⁻¹, the points in batches are checked per batch
+	batchDec := common.NewBatchDecoder(reader)
+	if err := scale(batchDec, enc, header.Domain-1, &deltaInvBI); err != nil {
+		return nil, err
 	}
 
 	// Process PKK using δ⁻¹
-	if err = scale(dec, enc, header.Witness, &deltaInvBI); err != nil {
-		return err
+	if err := scale(batchDec, enc, header.Witness, &deltaInvBI); err != nil {
+		return nil, err
 	}
 
 	// Copy old contributions
 	nExistingContributions := header.Contributions - 1
 	var c Contribution
 	for i := 0; i < nExistingContributions; i++ {
-		if _, err := c.readFrom(reader); err != nil {
-			return err
+		if _, err := c.ReadFrom(reader); err != nil {
+			return nil, err
 		}
 		if _, err := c.writeTo(writer); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	// Get hash of previous contribution
+	// Get hash of previous contribution, the first contribution is challenged by the initial state
 	var prevHash []byte
 	if nExistingContributions == 0 {
-		prevHash = nil
+		prevHash = header.challenge()
 	} else {
 		prevHash = c.Hash
 	}
@@ -154,12 +206,11 @@ func Contribute(inputPath, outputPath string) error {
 	contribution.Hash = computeHash(&contribution)
 
 	// Write the contribution
-	contribution.writeTo(writer)
-
-	fmt.Println("Contirbution has been successful!")
-	fmt.Println("Contribution Hash := ", hex.EncodeToString(contribution.Hash))
+	if _, err := contribution.writeTo(writer); err != nil {
+		return nil, err
+	}
 
-	return nil
+	return &contribution, nil
 }
 
 func Verify(inputPath, originPath string) error {
@@ -178,82 +229,222 @@ func Verify(inputPath, originPath string) error {
 	defer originFile.Close()
 
 	inputReader := bufio.NewReader(inputFile)
-	inputDec := bn254.NewDecoder(inputReader)
 	originReader := bufio.NewReader(originFile)
+
+	if _, err := verify(inputReader, originReader); err != nil {
+		return err
+	}
+
+	fmt.Println("Contributions verification has been successful")
+	return nil
+}
+
+// verify checks the phase 2 state read from inputReader against its origin and
+// returns the hashes of its contributions in order
+func verify(inputReader, originReader *bufio.Reader) ([][]byte, error) {
+	inputDec := bn254.NewDecoder(inputReader)
 	originDec := bn254.NewDecoder(originReader)
 
 	// Read curHeader
 	var curHeader, orgHeader Header
 	if err := curHeader.Read(inputReader); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := orgHeader.Read(originReader); err != nil {
-		return err
+		return nil, err
 	}
 	if curHeader.Contributions == 0 {
-		return fmt.Errorf("there are no contributions to verify")
+		return nil, fmt.Errorf("there are no contributions to verify")
 	}
 	if !curHeader.Equal(&orgHeader) {
-		return fmt.Errorf("there is a mismatch between origin and curren headers for phase 2")
+		return nil, fmt.Errorf("there is a mismatch between origin and curren headers for phase 2")
 	}
 
 	// Read [δ]₁ and [δ]₂
 	var d1, g1 bn254.G1Affine
 	var d2, g2 bn254.G2Affine
 	if err := originDec.Decode(&g1); err != nil {
-		return err
+		return nil, err
 	}
 	if err := originDec.Decode(&g2); err != nil {
-		return err
+		return nil, err
 	}
 	if err := inputDec.Decode(&d1); err != nil {
-		return err
+		return nil, err
 	}
 	if err := inputDec.Decode(&d2); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Check δ₁ and δ₂ are consistent
 	if !common.SameRatio(g1, d1, d2, g2) {
-		return fmt.Errorf("deltaG1 and deltaG2 aren't consistent")
+		return nil, fmt.Errorf("deltaG1 and deltaG2 aren't consistent")
 	}
 
-	// Check Z is updated correctly from origin to the latest state
+	// Check Z is updated correctly from origin to the latest state, the points in batches are checked per batch
 	fmt.Println("Verifying update of Z")
+	inputDec = common.NewBatchDecoder(inputReader)
+	originDec = common.NewBatchDecoder(originReader)
 	if err := verifyParameter(&d2, &g2, inputDec, originDec, curHeader.Domain-1, "Z"); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Check PKK is updated correctly from origin to the latest state
 	fmt.Println("Verifying update of PKK")
 	if err := verifyParameter(&d2, &g2, inputDec, originDec, curHeader.Witness, "PKK"); err != nil {
-		return err
+		return nil, err
+	}
+
+	// Skip contributions of origin, if any, so the next state in the stream can be read
+	var c Contribution
+	for i := 0; i < orgHeader.Contributions; i++ {
+		if _, err := c.ReadFrom(originReader); err != nil {
+			return nil, err
+		}
 	}
 
 	// Verify contributions
 	fmt.Printf("#Contributions := %d\n", curHeader.Contributions)
 	var prevDelta = g1
-	var prevHash []byte = nil
-	var c Contribution
+	var prevHash = curHeader.challenge()
+	hashes := make([][]byte, curHeader.Contributions)
 	for i := 0; i < curHeader.Contributions; i++ {
-		if _, err := c.readFrom(inputReader); err != nil {
-			return err
+		if _, err := c.ReadFrom(inputReader); err != nil {
+			return nil, err
 		}
 		fmt.Printf("Verifying contribution %d with Hash := %s\n", i+1, hex.EncodeToString(c.Hash))
 		if err := verifyContribution(&c, prevDelta, prevHash); err != nil {
-			return err
+			return nil, err
 		}
 		prevDelta = c.Delta
 		prevHash = c.Hash
+		hashes[i] = c.Hash
 	}
 
 	// Verify last contribution has the same delta in parameters
 	fmt.Println("Verifying Delta of last contribution")
 	if !c.Delta.Equal(&d1) {
-		return fmt.Errorf("delta of last contribution delta isn't the same as in parameters")
+		return nil, fmt.Errorf("delta of last contribution delta isn't the same as in parameters")
 	}
 
-	fmt.Println("Contributions verification has been successful")
+	return hashes, nil
+}
+
+// VerifyTransition checks that the state in nextPath is the state in prevPath
+// updated by exactly one valid contribution. The state in prevPath is assumed to be verified already.
+func VerifyTransition(prevPath, nextPath string) error {
+	// Previous state
+	prevFile, err := os.Open(prevPath)
+	if err != nil {
+		return err
+	}
+	defer prevFile.Close()
+
+	// Next state
+	nextFile, err := os.Open(nextPath)
+	if err != nil {
+		return err
+	}
+	defer nextFile.Close()
+
+	prevReader := bufio.NewReader(prevFile)
+	nextReader := bufio.NewReader(nextFile)
+
+	c, err := verifyTransition(prevReader, nextReader)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Contribution Hash := ", hex.EncodeToString(c.Hash))
+	fmt.Println("Contribution verification has been successful")
 	return nil
 }
+
+// verifyTransition checks the state read from nextReader is the state read from prevReader
+// scaled by the δ of one new contribution, and returns that contribution
+func verifyTransition(prevReader, nextReader *bufio.Reader) (*Contribution, error) {
+	prevDec := bn254.NewDecoder(prevReader)
+	nextDec := bn254.NewDecoder(nextReader)
+
+	// Read headers
+	var prevHeader, nextHeader Header
+	if err := prevHeader.Read(prevReader); err != nil {
+		return nil, err
+	}
+	if err := nextHeader.Read(nextReader); err != nil {
+		return nil, err
+	}
+	if !nextHeader.Equal(&prevHeader) {
+		return nil, fmt.Errorf("there is a mismatch between previous and next headers for phase 2")
+	}
+	if nextHeader.Contributions != prevHeader.Contributions+1 {
+		return nil, fmt.Errorf("next state has %d contributions, expected %d", nextHeader.Contributions, prevHeader.Contributions+1)
+	}
+
+	// Read [δ]₁ and [δ]₂
+	var prevD1, nextD1 bn254.G1Affine
+	var prevD2, nextD2 bn254.G2Affine
+	if err := prevDec.Decode(&prevD1); err != nil {
+		return nil, err
+	}
+	if err := prevDec.Decode(&prevD2); err != nil {
+		return nil, err
+	}
+	if err := nextDec.Decode(&nextD1); err != nil {
+		return nil, err
+	}
+	if err := nextDec.Decode(&nextD2); err != nil {
+		return nil, err
+	}
+
+	// Check δ₁ and δ₂ are scaled by the same δ
+	if !common.SameRatio(prevD1, nextD1, nextD2, prevD2) {
+		return nil, fmt.Errorf("deltaG1 and deltaG2 aren't consistent")
+	}
+
+	// Check Z is scaled by δ⁻¹, the points in batches are checked per batch
+	fmt.Println("Verifying update of Z")
+	prevDec = common.NewBatchDecoder(prevReader)
+	nextDec = common.NewBatchDecoder(nextReader)
+	if err := verifyParameter(&nextD2, &prevD2, nextDec, prevDec, nextHeader.Domain-1, "Z"); err != nil {
+		return nil, err
+	}
+
+	// Check PKK is scaled by δ⁻¹
+	fmt.Println("Verifying update of PKK")
+	if err := verifyParameter(&nextD2, &prevD2, nextDec, prevDec, nextHeader.Witness, "PKK"); err != nil {
+		return nil, err
+	}
+
+	// Check history of next is the history of previous
+	fmt.Printf("#Contributions := %d\n", nextHeader.Contributions)
+	var prevHash = prevHeader.challenge()
+	var prevC, nextC Contribution
+	for i := 0; i < prevHeader.Contributions; i++ {
+		if _, err := prevC.ReadFrom(prevReader); err != nil {
+			return nil, err
+		}
+		if _, err := nextC.ReadFrom(nextReader); err != nil {
+			return nil, err
+		}
+		if !nextC.equal(&prevC) {
+			return nil, fmt.Errorf("contribution %d differs from the previous state", i+1)
+		}
+		prevHash = prevC.Hash
+	}
+
+	// Verify the new contribution
+	if _, err := nextC.ReadFrom(nextReader); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Verifying contribution %d with Hash := %s\n", nextHeader.Contributions, hex.EncodeToString(nextC.Hash))
+	if err := verifyContribution(&nextC, prevD1, prevHash); err != nil {
+		return nil, err
+	}
+	if !nextC.Delta.Equal(&nextD1) {
+		return nil, fmt.Errorf("delta of last contribution delta isn't the same as in parameters")
+	}
+
+	return &nextC, nil
+}
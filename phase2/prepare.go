@@ -0,0 +1,358 @@
+package phase2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/bnb-chain/zkbnb-setup/phase1"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+)
+
+// A prepared Lagrange SRS is the digest of the phase 1 parameters it was converted from and the domain,
+// followed by the sections of the Lagrange SRS file
+const preparedHeaderSize = sha256.Size + 4
+
+type preparedHeader struct {
+	ParametersDigest []byte
+	Domain           int
+}
+
+func (h *preparedHeader) read(reader io.Reader) error {
+	h.ParametersDigest = make([]byte, sha256.Size)
+	if _, err := io.ReadFull(reader, h.ParametersDigest); err != nil {
+		return err
+	}
+	var domain uint32
+	if err := binary.Read(reader, binary.BigEndian, &domain); err != nil {
+		return err
+	}
+	h.Domain = int(domain)
+	return nil
+}
+
+func (h *preparedHeader) write(writer io.Writer) error {
+	if _, err := writer.Write(h.ParametersDigest); err != nil {
+		return err
+	}
+	return binary.Write(writer, binary.BigEndian, uint32(h.Domain))
+}
+
+// preparedPath returns the path of the Lagrange SRS of the domain prepared in dir
+func preparedPath(dir string, domain int) string {
+	return filepath.Join(dir, fmt.Sprintf("srs.%d.lag", domain))
+}
+
+// preparedSize returns the size of the Lagrange SRS of the domain prepared
+func preparedSize(domain int) int64 {
+	return preparedHeaderSize + lagrangeOffset(&Header{Domain: domain}, lagTauG2) + 4 + 64*int64(domain)
+}
+
+// Prepare converts the SRS of the phase 1 file to the Lagrange basis of every domain it supports,
+// into srs.<domain>.lag files of outputDir, so the initialization of phase 2 doesn't convert it again
+// given outputDir as its PreparedDir. Only the memory budget of opts applies.
+func Prepare(phase1Path, outputDir string, opts Options) error {
+	opts.setDefaults()
+	phase1File, err := os.Open(phase1Path)
+	if err != nil {
+		return err
+	}
+	defer phase1File.Close()
+
+	var header1 phase1.Header
+	if err := header1.ReadFrom(phase1File); err != nil {
+		return err
+	}
+	digest, err := phase1.ParametersDigest(phase1File, &header1)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+
+	for power := 0; power <= int(header1.Power); power++ {
+		domain := 1 << power
+		fmt.Printf("Preparing the Lagrange SRS of domain %d\n", domain)
+		if err := prepare(&header1, &preparedHeader{ParametersDigest: digest, Domain: domain}, phase1File, preparedPath(outputDir, domain), opts.MemoryBudget); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Lagrange SRS has been prepared successfully")
+	return nil
+}
+
+func prepare(header1 *phase1.Header, header *preparedHeader, phase1File *os.File, path string, memoryBudget int64) error {
+	lagFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer lagFile.Close()
+	if err := header.write(lagFile); err != nil {
+		return err
+	}
+	return convertLagrange(header1, header.Domain, phase1File, lagFile, memoryBudget)
+}
+
+// copyPrepared copies the Lagrange SRS of the domain prepared in dir to lagPath,
+// and reports whether dir holds one prepared from the parameters of the phase 1 file
+func copyPrepared(header1 *phase1.Header, domain int, phase1File *os.File, lagPath, dir string) (bool, error) {
+	path := preparedPath(dir, domain)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("No Lagrange SRS prepared at %s\n", path)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	header, err := readPreparedHeader(file)
+	if err != nil {
+		return false, err
+	}
+	digest, err := phase1.ParametersDigest(phase1File, header1)
+	if err != nil {
+		return false, err
+	}
+	if header.Domain != domain || !bytes.Equal(header.ParametersDigest, digest) {
+		fmt.Printf("Lagrange SRS at %s is prepared from other phase 1 parameters\n", path)
+		return false, nil
+	}
+
+	fmt.Printf("Copying the Lagrange SRS prepared at %s\n", path)
+	lagFile, err := os.Create(lagPath)
+	if err != nil {
+		return false, err
+	}
+	defer lagFile.Close()
+	if _, err := io.Copy(lagFile, io.NewSectionReader(file, preparedHeaderSize, preparedSize(domain)-preparedHeaderSize)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// readPreparedHeader reads the header of a prepared Lagrange SRS and checks the size of the file
+func readPreparedHeader(file *os.File) (*preparedHeader, error) {
+	var header preparedHeader
+	if err := header.read(file); err != nil {
+		return nil, err
+	}
+	if header.Domain == 0 || header.Domain&(header.Domain-1) != 0 {
+		return nil, fmt.Errorf("%s holds an invalid domain %d", file.Name(), header.Domain)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != preparedSize(header.Domain) {
+		return nil, fmt.Errorf("%s has %d bytes, but the Lagrange SRS of domain %d has %d", file.Name(), info.Size(), header.Domain, preparedSize(header.Domain))
+	}
+	return &header, nil
+}
+
+// VerifyPrepared checks the Lagrange SRS prepared at preparedPath against the phase 1 file. Its tag must match
+// the parameters, and for a random r, each section Lᵢ(τ) must satisfy Σᵢ (rⁿ-1)/(rωⁱ-1)·[Lᵢ(τ)] = Σⱼ rʲ·[τʲ],
+// since τʲ = Σᵢ ωⁱʲ·Lᵢ(τ) for j < n.
+func VerifyPrepared(phase1Path, preparedPath string) error {
+	phase1File, err := os.Open(phase1Path)
+	if err != nil {
+		return err
+	}
+	defer phase1File.Close()
+	lagFile, err := os.Open(preparedPath)
+	if err != nil {
+		return err
+	}
+	defer lagFile.Close()
+
+	var header1 phase1.Header
+	if err := header1.ReadFrom(phase1File); err != nil {
+		return err
+	}
+	header, err := readPreparedHeader(lagFile)
+	if err != nil {
+		return err
+	}
+	digest, err := phase1.ParametersDigest(phase1File, &header1)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(header.ParametersDigest, digest) {
+		return errors.New("the Lagrange SRS isn't prepared from the parameters of the phase 1 file")
+	}
+	N := int(math.Pow(2, float64(header1.Power)))
+	if header.Domain > N {
+		return fmt.Errorf("phase 1 parameters support domains up to %d, but the Lagrange SRS has domain %d", N, header.Domain)
+	}
+
+	// r must not be a root of unity of the domain
+	domain := fft.NewDomain(uint64(header.Domain))
+	var r, rn fr.Element
+	for rn.IsOne() || r.IsZero() {
+		if _, err := r.SetRandom(); err != nil {
+			return err
+		}
+		rn.Exp(r, big.NewInt(int64(header.Domain)))
+	}
+
+	fmt.Printf("Verifying the Lagrange SRS of domain %d\n", header.Domain)
+	header2 := &Header{Domain: header.Domain}
+	sections := []struct {
+		name     string
+		position int64 // in the phase 1 file
+		section  int
+	}{
+		{"TauG1", header1.Size(), lagTauG1},
+		{"AlphaTauG1", header1.Size() + 32*(2*int64(N)-1), lagAlphaTauG1},
+		{"BetaTauG1", header1.Size() + 32*(3*int64(N)-1), lagBetaTauG1},
+		{"TauG2", header1.Size() + 32*(4*int64(N)-1), lagTauG2},
+	}
+	for _, s := range sections {
+		position := preparedHeaderSize + lagrangeOffset(header2, s.section)
+		if err := checkSliceLength(lagFile, position, header.Domain); err != nil {
+			return err
+		}
+		var ok bool
+		if s.section == lagTauG2 {
+			ok, err = sameEvaluationG2(phase1File, s.position, lagFile, position+4, domain, &r)
+		} else {
+			ok, err = sameEvaluationG1(phase1File, s.position, lagFile, position+4, domain, &r)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s of the Lagrange SRS doesn't match the phase 1 parameters", s.name)
+		}
+	}
+
+	fmt.Println("Lagrange SRS has been verified successfully")
+	return nil
+}
+
+func checkSliceLength(file *os.File, position int64, length int) error {
+	var l uint32
+	if err := binary.Read(io.NewSectionReader(file, position, 4), binary.BigEndian, &l); err != nil {
+		return err
+	}
+	if int(l) != length {
+		return fmt.Errorf("section at %d of %s holds %d points instead of %d", position, file.Name(), l, length)
+	}
+	return nil
+}
+
+// monomialScalars fills batches, in order, with the powers rʲ
+func monomialScalars(r *fr.Element) func([]fr.Element) {
+	var power fr.Element
+	power.SetOne()
+	return func(s []fr.Element) {
+		for i := range s {
+			s[i] = power
+			power.Mul(&power, r)
+		}
+	}
+}
+
+// lagrangeScalars fills batches, in order, with (rⁿ-1)/(rωⁱ-1), the sums Σⱼ rʲωⁱʲ for j < n
+func lagrangeScalars(r *fr.Element, domain *fft.Domain) func([]fr.Element) {
+	var numerator, one, rw fr.Element
+	one.SetOne()
+	numerator.Exp(*r, big.NewInt(int64(domain.Cardinality))).Sub(&numerator, &one)
+	rw.Set(r)
+	return func(s []fr.Element) {
+		for i := range s {
+			s[i].Sub(&rw, &one)
+			rw.Mul(&rw, &domain.Generator)
+		}
+		inv := fr.BatchInvert(s)
+		for i := range s {
+			s[i].Mul(&inv[i], &numerator)
+		}
+	}
+}
+
+// sameEvaluationG1 reports whether the monomial SRS at monomialPos of phase1File and the Lagrange one at
+// lagrangePos of lagFile evaluate the same at r
+func sameEvaluationG1(phase1File *os.File, monomialPos int64, lagFile *os.File, lagrangePos int64, domain *fft.Domain, r *fr.Element) (bool, error) {
+	n := int(domain.Cardinality)
+	monomial, err := multiExpG1(phase1File, monomialPos, n, monomialScalars(r))
+	if err != nil {
+		return false, err
+	}
+	lagrange, err := multiExpG1(lagFile, lagrangePos, n, lagrangeScalars(r, domain))
+	if err != nil {
+		return false, err
+	}
+	return monomial.Equal(&lagrange), nil
+}
+
+func sameEvaluationG2(phase1File *os.File, monomialPos int64, lagFile *os.File, lagrangePos int64, domain *fft.Domain, r *fr.Element) (bool, error) {
+	n := int(domain.Cardinality)
+	monomial, err := multiExpG2(phase1File, monomialPos, n, monomialScalars(r))
+	if err != nil {
+		return false, err
+	}
+	lagrange, err := multiExpG2(lagFile, lagrangePos, n, lagrangeScalars(r, domain))
+	if err != nil {
+		return false, err
+	}
+	return monomial.Equal(&lagrange), nil
+}
+
+// multiExpG1 returns Σᵢ sᵢ·[Pᵢ] over the n compressed points at position of file, batch after batch
+func multiExpG1(file *os.File, position int64, n int, scalars func([]fr.Element)) (bn254.G1Affine, error) {
+	batchSize := minInt(n, srsBatchSize)
+	reader := newG1Reader(file, position, batchSize)
+	s := make([]fr.Element, batchSize)
+	var res, tmp bn254.G1Jac
+	for start := 0; start < n; start += batchSize {
+		count := minInt(batchSize, n-start)
+		points, err := reader.next(count)
+		if err != nil {
+			return bn254.G1Affine{}, err
+		}
+		scalars(s[:count])
+		if _, err := tmp.MultiExp(points, s[:count], ecc.MultiExpConfig{}); err != nil {
+			return bn254.G1Affine{}, err
+		}
+		res.AddAssign(&tmp)
+	}
+	var p bn254.G1Affine
+	p.FromJacobian(&res)
+	return p, nil
+}
+
+func multiExpG2(file *os.File, position int64, n int, scalars func([]fr.Element)) (bn254.G2Affine, error) {
+	batchSize := minInt(n, srsBatchSize)
+	reader := newG2Reader(file, position, batchSize)
+	s := make([]fr.Element, batchSize)
+	var res, tmp bn254.G2Jac
+	for start := 0; start < n; start += batchSize {
+		count := minInt(batchSize, n-start)
+		points, err := reader.next(count)
+		if err != nil {
+			return bn254.G2Affine{}, err
+		}
+		scalars(s[:count])
+		if _, err := tmp.MultiExp(points, s[:count], ecc.MultiExpConfig{}); err != nil {
+			return bn254.G2Affine{}, err
+		}
+		res.AddAssign(&tmp)
+	}
+	var p bn254.G2Affine
+	p.FromJacobian(&res)
+	return p, nil
+}
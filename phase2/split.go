@@ -0,0 +1,167 @@
+package phase2
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// SplitR1CS is an R1CS split by SplitDumpBinary of the gnark fork. Only the parts describing the system,
+// the lazy constraints and the coefficients are loaded, the constraints are read one part at a time.
+type SplitR1CS struct {
+	Session       string
+	NbConstraints int // #Constraints, including the lazy ones
+	NbR1C         int // #Constraints stored in the parts
+	BatchSize     int // #Constraints per part
+
+	r1cs  *cs_bn254.R1CS
+	parts []splitPart // constraint parts, in order
+}
+
+// splitPart holds the constraints [from, to)
+type splitPart struct {
+	path     string
+	from, to int
+}
+
+// ReadSplitR1CS reads the metadata of the R1CS split into the parts of session. #R1C and the batch size
+// are read from the ranges of constraints the parts are named after, #Constraints from the lazy constraints.
+func ReadSplitR1CS(session string) (*SplitR1CS, error) {
+	split := &SplitR1CS{Session: session, r1cs: &cs_bn254.R1CS{}}
+	if err := split.readParts(); err != nil {
+		return nil, err
+	}
+
+	// System, without the levels and the hints which the setup doesn't need
+	file, err := os.Open(session + ".r1cs.E1.save")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&split.r1cs.R1CSCore.System); err != nil {
+		return nil, err
+	}
+
+	// Lazy constraints, their indexes, then the coefficients and the static constraints they're built from
+	for _, part := range []string{"E2", "E3", "E4"} {
+		var cs cs_bn254.R1CS
+		file, err := os.Open(fmt.Sprintf("%s.r1cs.%s.save", session, part))
+		if err != nil {
+			return nil, err
+		}
+		_, err = cs.ReadFrom(bufio.NewReader(file))
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		switch part {
+		case "E2":
+			split.r1cs.LazyCons = cs.LazyCons
+		case "E3":
+			split.r1cs.LazyConsMap = cs.LazyConsMap
+		case "E4":
+			split.r1cs.CoeffTable = cs.CoeffTable
+			split.r1cs.StaticConstraints = cs.StaticConstraints
+		}
+	}
+
+	// As R1CSCore.GetNbConstraints counts them
+	split.NbConstraints = split.NbR1C
+	if len(split.r1cs.LazyConsMap) != 0 {
+		split.NbConstraints += split.r1cs.LazyCons.GetConstraintsAll()
+	}
+	return split, nil
+}
+
+// partPaths returns the paths of the parts of the split R1CS in a fixed order, E1 to E4 then the constraint parts,
+// leaving out any other file of the session
+func (split *SplitR1CS) partPaths() []string {
+	var paths []string
+	for _, part := range []string{"E1", "E2", "E3", "E4"} {
+		paths = append(paths, fmt.Sprintf("%s.r1cs.%s.save", split.Session, part))
+	}
+	for _, p := range split.parts {
+		paths = append(paths, p.path)
+	}
+	return paths
+}
+
+// readParts lists the constraint parts, named <session>.r1cs.Cons.<from>.<to>.save, and checks they cover [0, #R1C)
+func (split *SplitR1CS) readParts() error {
+	prefix := split.Session + ".r1cs.Cons."
+	paths, err := filepath.Glob(prefix + "*.save")
+	if err != nil {
+		return err
+	}
+	var parts []splitPart
+	for _, path := range paths {
+		var p splitPart
+		if _, err := fmt.Sscanf(strings.TrimPrefix(filepath.Base(path), filepath.Base(prefix)), "%d.%d.save", &p.from, &p.to); err != nil {
+			return fmt.Errorf("invalid constraint part %s", path)
+		}
+		p.path = path
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("no constraint part found for %s", split.Session)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].from < parts[j].from })
+
+	split.BatchSize = parts[0].to - parts[0].from
+	for i, p := range parts {
+		if p.from != split.NbR1C || p.to <= p.from || (i < len(parts)-1 && p.to-p.from != split.BatchSize) {
+			return fmt.Errorf("constraint parts of %s aren't contiguous at %s", split.Session, p.path)
+		}
+		split.NbR1C = p.to
+	}
+	split.parts = parts
+	return nil
+}
+
+// circuit streams the constraints part after part, then the lazy constraints built from the static ones
+func (split *SplitR1CS) circuit(memoryBudget int64) *circuit {
+	return &circuit{
+		r1cs:          split.r1cs,
+		nbConstraints: split.NbConstraints,
+		memoryBudget:  memoryBudget,
+		batches: func(process func([]constraint.R1C) error) error {
+			for _, p := range split.parts {
+				var part cs_bn254.R1CS
+				file, err := os.Open(p.path)
+				if err != nil {
+					return err
+				}
+				err = gob.NewDecoder(bufio.NewReader(file)).Decode(&part)
+				file.Close()
+				if err != nil {
+					return err
+				}
+				if len(part.Constraints) != p.to-p.from {
+					return fmt.Errorf("%s holds %d constraints", p.path, len(part.Constraints))
+				}
+				if err := inMemory(&part, memoryBudget).batches(process); err != nil {
+					return err
+				}
+			}
+
+			batch := make([]constraint.R1C, 0, minInt(split.NbConstraints-split.NbR1C, srsBatchSize))
+			for i := split.NbR1C; i < split.NbConstraints; i++ {
+				batch = append(batch, split.r1cs.GetConstraintToSolve(i))
+				if len(batch) == cap(batch) || i == split.NbConstraints-1 {
+					if err := process(batch); err != nil {
+						return err
+					}
+					batch = batch[:0]
+				}
+			}
+			return nil
+		},
+	}
+}
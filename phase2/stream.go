@@ -0,0 +1,354 @@
+package phase2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bnb-chain/zkbnb-setup/common"
+	"github.com/bnb-chain/zkbnb-setup/phase1"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// DefaultMemoryBudget is the memory budget of the initialization when Options doesn't set one
+const DefaultMemoryBudget int64 = 8 << 30
+
+const (
+	srsBatchSize = 1048576 // 2^20 points of the Lagrange SRS are decompressed at once
+	g1Size       = 64      // in memory size of bn254.G1Affine
+	g2Size       = 128     // in memory size of bn254.G2Affine
+	g1JacSize    = 96      // in memory size of bn254.G1Jac
+	g2JacSize    = 192     // in memory size of bn254.G2Jac
+	twiddleSize  = 56      // in memory size of the twiddles of the Lagrange conversion, per point
+
+	// Memory taken per wire of a chunk: the key in Jacobian and affine coordinates, and its offsets in wireTerms
+	g1KeySize = g1JacSize + g1Size + 16
+	g2KeySize = g2JacSize + g2Size + 16
+)
+
+// Offsets of the sections of the Lagrange SRS file, each one a slice of Domain compressed points
+func lagrangeOffset(header2 *Header, section int) int64 {
+	return int64(section) * (4 + 32*int64(header2.Domain))
+}
+
+const (
+	lagTauG1 = iota
+	lagAlphaTauG1
+	lagBetaTauG1
+	lagTauG2
+)
+
+// g1Reader decompresses in parallel batches of consecutive compressed G1 points
+type g1Reader struct {
+	reader *bufio.Reader
+	buf    []byte
+	points []bn254.G1Affine
+}
+
+func newG1Reader(file *os.File, position int64, batchSize int) *g1Reader {
+	return &g1Reader{
+		reader: bufio.NewReader(io.NewSectionReader(file, position, 1<<62)),
+		buf:    make([]byte, batchSize*bn254.SizeOfG1AffineCompressed),
+		points: make([]bn254.G1Affine, batchSize),
+	}
+}
+
+// next returns the next count points, the slice is reused by the following call
+func (r *g1Reader) next(count int) ([]bn254.G1Affine, error) {
+	const size = bn254.SizeOfG1AffineCompressed
+	if _, err := io.ReadFull(r.reader, r.buf[:count*size]); err != nil {
+		return nil, err
+	}
+	errs := make([]error, count)
+	common.Parallelize(count, func(start, end int) {
+		for i := start; i < end; i++ {
+			_, errs[i] = r.points[i].SetBytes(r.buf[i*size : (i+1)*size])
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.points[:count], nil
+}
+
+// g2Reader is g1Reader for G2 points
+type g2Reader struct {
+	reader *bufio.Reader
+	buf    []byte
+	points []bn254.G2Affine
+}
+
+func newG2Reader(file *os.File, position int64, batchSize int) *g2Reader {
+	return &g2Reader{
+		reader: bufio.NewReader(io.NewSectionReader(file, position, 1<<62)),
+		buf:    make([]byte, batchSize*bn254.SizeOfG2AffineCompressed),
+		points: make([]bn254.G2Affine, batchSize),
+	}
+}
+
+func (r *g2Reader) next(count int) ([]bn254.G2Affine, error) {
+	const size = bn254.SizeOfG2AffineCompressed
+	if _, err := io.ReadFull(r.reader, r.buf[:count*size]); err != nil {
+		return nil, err
+	}
+	errs := make([]error, count)
+	common.Parallelize(count, func(start, end int) {
+		for i := start; i < end; i++ {
+			_, errs[i] = r.points[i].SetBytes(r.buf[i*size : (i+1)*size])
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.points[:count], nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// circuit streams the constraints of an R1CS, whose coefficients are held by r1cs
+type circuit struct {
+	r1cs          *cs_bn254.R1CS
+	nbConstraints int
+	memoryBudget  int64
+	// batches calls process on consecutive batches of at most srsBatchSize constraints, in order
+	batches func(process func(constraints []constraint.R1C) error) error
+}
+
+// inMemory streams the constraints of a fully loaded R1CS
+func inMemory(r1cs *cs_bn254.R1CS, memoryBudget int64) *circuit {
+	return &circuit{
+		r1cs:          r1cs,
+		nbConstraints: len(r1cs.Constraints),
+		memoryBudget:  memoryBudget,
+		batches: func(process func([]constraint.R1C) error) error {
+			for start := 0; start < len(r1cs.Constraints); start += srsBatchSize {
+				if err := process(r1cs.Constraints[start:minInt(start+srsBatchSize, len(r1cs.Constraints))]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// chunkSize returns how many keys of keySize bytes are accumulated per pass within the memory budget,
+// next to a batch of SRS points taking srsSize bytes decompressed and compressed
+func (c *circuit) chunkSize(nbWires int, keySize, srsSize int64) int {
+	available := c.memoryBudget - int64(minInt(c.nbConstraints, srsBatchSize))*srsSize
+	size := available / keySize
+	if size < 1 {
+		size = 1
+	}
+	if size > int64(nbWires) {
+		size = int64(nbWires)
+	}
+	if int(size) < nbWires {
+		fmt.Printf("Accumulating in %d passes\n", (nbWires+int(size)-1)/int(size))
+	}
+	return int(size)
+}
+
+// Picks the linear expressions of a constraint
+func left(c *constraint.R1C) constraint.LinearExpression   { return c.L }
+func right(c *constraint.R1C) constraint.LinearExpression  { return c.R }
+func output(c *constraint.R1C) constraint.LinearExpression { return c.O }
+
+// accumulateG1Chunk adds to res[w-from], for the wires w in [from, from+len(res)), the points of the Lagrange
+// SRS section at position weighted by the coefficients of w in the linear expressions picked by terms
+func (c *circuit) accumulateG1Chunk(lagFile *os.File, position int64, terms func(*constraint.R1C) constraint.LinearExpression, from int, res []bn254.G1Jac) error {
+	var wt wireTerms
+	reader := newG1Reader(lagFile, position+4, minInt(c.nbConstraints, srsBatchSize))
+	return c.batches(func(constraints []constraint.R1C) error {
+		srs, err := reader.next(len(constraints))
+		if err != nil {
+			return err
+		}
+		wt.build(constraints, terms, from, len(res))
+		return accumulateG1Batch(c.r1cs, &wt, srs, res)
+	})
+}
+
+// accumulateG2Chunk is accumulateG1Chunk for a G2 section
+func (c *circuit) accumulateG2Chunk(lagFile *os.File, position int64, terms func(*constraint.R1C) constraint.LinearExpression, from int, res []bn254.G2Jac) error {
+	var wt wireTerms
+	reader := newG2Reader(lagFile, position+4, minInt(c.nbConstraints, srsBatchSize))
+	return c.batches(func(constraints []constraint.R1C) error {
+		srs, err := reader.next(len(constraints))
+		if err != nil {
+			return err
+		}
+		wt.build(constraints, terms, from, len(res))
+		return accumulateG2Batch(c.r1cs, &wt, srs, res)
+	})
+}
+
+// writeChunkedG1 writes as one slice the keys of all wires accumulated chunk after chunk,
+// on the Lagrange SRS section at position for the linear expressions picked by terms
+func (c *circuit) writeChunkedG1(header2 *Header, lagFile *os.File, position int64, terms func(*constraint.R1C) constraint.LinearExpression, writer io.Writer) error {
+	if err := writeSliceLength(writer, header2.Wires); err != nil {
+		return err
+	}
+	enc := bn254.NewEncoder(writer)
+	size := c.chunkSize(header2.Wires, g1KeySize, g1Size+bn254.SizeOfG1AffineCompressed)
+	buff := make([]bn254.G1Jac, size)
+	keys := make([]bn254.G1Affine, size)
+	for from := 0; from < header2.Wires; from += size {
+		chunk := buff[:minInt(size, header2.Wires-from)]
+		for i := range chunk {
+			chunk[i] = bn254.G1Jac{}
+		}
+		if err := c.accumulateG1Chunk(lagFile, position, terms, from, chunk); err != nil {
+			return err
+		}
+		fromJacobianG1(keys, chunk)
+		for i := range chunk {
+			if err := enc.Encode(&keys[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeChunkedG2 is writeChunkedG1 for a G2 section
+func (c *circuit) writeChunkedG2(header2 *Header, lagFile *os.File, position int64, terms func(*constraint.R1C) constraint.LinearExpression, writer io.Writer) error {
+	if err := writeSliceLength(writer, header2.Wires); err != nil {
+		return err
+	}
+	enc := bn254.NewEncoder(writer)
+	size := c.chunkSize(header2.Wires, g2KeySize, g2Size+bn254.SizeOfG2AffineCompressed)
+	buff := make([]bn254.G2Jac, size)
+	keys := make([]bn254.G2Affine, size)
+	for from := 0; from < header2.Wires; from += size {
+		chunk := buff[:minInt(size, header2.Wires-from)]
+		for i := range chunk {
+			chunk[i] = bn254.G2Jac{}
+		}
+		if err := c.accumulateG2Chunk(lagFile, position, terms, from, chunk); err != nil {
+			return err
+		}
+		common.Parallelize(len(chunk), func(start, end int) {
+			for i := start; i < end; i++ {
+				keys[i].FromJacobian(&chunk[i])
+			}
+		})
+		for i := range chunk {
+			if err := enc.Encode(&keys[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeEvaluations writes [α]₁, [β]₁, [β]₂ read from phase 1, then [A]₁, [B]₁, [B]₂ of all wires to the evaluations file
+func (c *circuit) writeEvaluations(header1 *phase1.Header, header2 *Header, phase1File *os.File, lagPath, evalsPath string) error {
+	lagFile, err := os.Open(lagPath)
+	if err != nil {
+		return err
+	}
+	defer lagFile.Close()
+
+	evalFile, err := os.Create(evalsPath)
+	if err != nil {
+		return err
+	}
+	defer evalFile.Close()
+	writer := bufio.NewWriter(evalFile)
+	defer writer.Flush()
+
+	// Read [α]₁ , [β]₁ , [β]₂  from phase1 (Check Phase 1 file format for reference)
+	alpha, beta1, beta2, err := readPhase1(phase1File, header1)
+	if err != nil {
+		return err
+	}
+
+	// Write [α]₁ , [β]₁ , [β]₂
+	enc := bn254.NewEncoder(writer)
+	if err := enc.Encode(alpha); err != nil {
+		return err
+	}
+	if err := enc.Encode(beta1); err != nil {
+		return err
+	}
+	if err := enc.Encode(beta2); err != nil {
+		return err
+	}
+
+	// Accumulate and serialize {[A]₁} and {[B]₁} on Lagrange SRS TauG1
+	if err := c.writeChunkedG1(header2, lagFile, lagrangeOffset(header2, lagTauG1), left, writer); err != nil {
+		return err
+	}
+	if err := c.writeChunkedG1(header2, lagFile, lagrangeOffset(header2, lagTauG1), right, writer); err != nil {
+		return err
+	}
+
+	// Accumulate and serialize {[B]₂} on Lagrange SRS TauG2
+	return c.writeChunkedG2(header2, lagFile, lagrangeOffset(header2, lagTauG2), right, writer)
+}
+
+// writePVCKK writes PKK to the phase 2 file, then VKK, CKK, and the commitments to the evaluations file
+func (c *circuit) writePVCKK(header2 *Header, commitments []Commitment, phase2File *os.File, lagPath, evalsPath string) error {
+	lagFile, err := os.Open(lagPath)
+	if err != nil {
+		return err
+	}
+	defer lagFile.Close()
+
+	writer := bufio.NewWriter(phase2File)
+	defer writer.Flush()
+	kk := newKKWriter(header2, commitments, bn254.NewEncoder(writer))
+
+	// L = O(TauG1) + R(AlphaTauG1) + L(BetaTauG1), accumulated chunk after chunk
+	size := c.chunkSize(header2.Wires, g1KeySize, g1Size+bn254.SizeOfG1AffineCompressed)
+	buff := make([]bn254.G1Jac, size)
+	keys := make([]bn254.G1Affine, size)
+	for from := 0; from < header2.Wires; from += size {
+		chunk := buff[:minInt(size, header2.Wires-from)]
+		for i := range chunk {
+			chunk[i] = bn254.G1Jac{}
+		}
+		if err := c.accumulateG1Chunk(lagFile, lagrangeOffset(header2, lagTauG1), output, from, chunk); err != nil {
+			return err
+		}
+		if err := c.accumulateG1Chunk(lagFile, lagrangeOffset(header2, lagAlphaTauG1), right, from, chunk); err != nil {
+			return err
+		}
+		if err := c.accumulateG1Chunk(lagFile, lagrangeOffset(header2, lagBetaTauG1), left, from, chunk); err != nil {
+			return err
+		}
+		fromJacobianG1(keys, chunk)
+		if err := kk.write(keys[:len(chunk)]); err != nil {
+			return err
+		}
+	}
+
+	return kk.finish(c.r1cs.CommitmentInfo, evalsPath)
+}
+
+// fromJacobianG1 converts the points of jac to the first len(jac) points of res
+func fromJacobianG1(res []bn254.G1Affine, jac []bn254.G1Jac) {
+	common.Parallelize(len(jac), func(start, end int) {
+		for i := start; i < end; i++ {
+			res[i].FromJacobian(&jac[i])
+		}
+	})
+}
+
+// writeSliceLength writes the length prefix bn254.Encoder writes before a slice
+func writeSliceLength(writer io.Writer, length int) error {
+	return binary.Write(writer, binary.BigEndian, uint32(length))
+}
@@ -3,13 +3,17 @@ package phase2
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"math/big"
+	"math/bits"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/bnb-chain/zkbnb-setup/common"
 	"github.com/bnb-chain/zkbnb-setup/phase1"
@@ -18,7 +22,6 @@ import (
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
 	"github.com/consensys/gnark/constraint"
-	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
 )
 
 func nextPowerofTwo(number int) int {
@@ -34,199 +37,147 @@ func nextPowerofTwo(number int) int {
 	panic("the power is beyond 28")
 }
 
-func processHeader(r1csPath string, phase1File, phase2File *os.File) (*phase1.Header, *Header, error) {
+func processHeader(r1csPath, commitmentsPath string, phase1File, phase2File *os.File) (*phase1.Header, *Header, []Commitment, error) {
 	fmt.Println("Processing the headers ...")
 
 	var header2 Header
 	var header1 phase1.Header
 
 	// Read the #Constraints
-	r1csFile, err := os.Open(r1csPath)
+	r1cs, err := ReadR1CS(r1csPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	defer r1csFile.Close()
-	var r1cs cs_bn254.R1CS
-	if _, err := r1cs.ReadFrom(r1csFile); err != nil {
-		return nil, nil, err
+	commitments, err := readCommitments(r1cs, r1csPath, commitmentsPath)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 	header2.Constraints = r1cs.GetNbConstraints()
 	header2.Domain = nextPowerofTwo(header2.Constraints)
 
-	// Check if phase 1 power can support the current #Constraints
+	// Bind the state to its phase 1 source and circuit
 	if err := header1.ReadFrom(phase1File); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
+	if header2.Phase1Digest, err = phase1.ParametersDigest(phase1File, &header1); err != nil {
+		return nil, nil, nil, err
+	}
+	r1csFile, err := os.Open(r1csPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer r1csFile.Close()
+	if header2.R1CSDigest, err = digest(r1csFile); err != nil {
+		return nil, nil, nil, err
+	}
+	header2.Label = circuitLabel(r1csPath)
+
+	// Check if phase 1 power can support the current #Constraints
 	N := int(math.Pow(2, float64(header1.Power)))
 	if N < header2.Constraints {
-		return nil, nil, fmt.Errorf("phase 1 parameters can support up to %d, but the circuit #Constraints are %d", N, header2.Constraints)
+		return nil, nil, nil, fmt.Errorf("phase 1 parameters can support up to %d, but the circuit #Constraints are %d", N, header2.Constraints)
 	}
 	// Initialize Domain, #Wires, #Witness, #Public, #PrivateCommitted
 	header2.Wires = r1cs.NbInternalVariables + r1cs.GetNbPublicVariables() + r1cs.GetNbSecretVariables()
-	header2.PrivateCommitted = r1cs.CommitmentInfo.NbPrivateCommitted
-	header2.Public = r1cs.GetNbPublicVariables()
-	header2.Witness = r1cs.GetNbSecretVariables() + r1cs.NbInternalVariables - header2.PrivateCommitted
-
-	if r1cs.CommitmentInfo.Is() { // the commitment itself is defined by a hint so the prover considers it private
-		header2.Public++  // but the verifier will need to inject the value itself so on the groth16
-		header2.Witness-- // level it must be considered public
-	}
+	header2.PrivateCommitted = NbPrivateCommitted(commitments)
+	// Each commitment is defined by a hint so the prover considers it private,
+	// but the verifier will need to inject the value itself so on the groth16 level it must be considered public
+	header2.Public = r1cs.GetNbPublicVariables() + len(commitments)
+	header2.Witness = r1cs.GetNbSecretVariables() + r1cs.NbInternalVariables - header2.PrivateCommitted - len(commitments)
 
 	// Write header of phase 2
 	if err := header2.write(phase2File); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	fmt.Printf("Circuit Info: #Constraints:=%d\n#Wires:=%d\n#Public:=%d\n#Witness:=%d\n#PrivateCommitted:=%d\n",
 		header2.Constraints, header2.Wires, header2.Public, header2.Witness, header2.PrivateCommitted)
-	return &header1, &header2, nil
+	return &header1, &header2, commitments, nil
 }
 
-func processLagrange(header1 *phase1.Header, header2 *Header, phase1File, phase2File *os.File) error {
-	fmt.Println("Converting to Lagrange basis ...")
-	domain := fft.NewDomain(uint64(header2.Domain))
-	N := int(math.Pow(2, float64(header1.Power)))
+// digest returns SHA256 of the whole file and rewinds it
+func digest(file *os.File) ([]byte, error) {
+	sha := sha256.New()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(sha, file); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return sha.Sum(nil), nil
+}
 
-	lagFile, err := os.Create("srs.lag")
+// circuitLabel names the circuit by its file name without extension
+func circuitLabel(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+func processLagrange(header1 *phase1.Header, header2 *Header, phase1File *os.File, lagPath string, opts *Options) error {
+	if opts.PreparedDir != "" {
+		found, err := copyPrepared(header1, header2.Domain, phase1File, lagPath, opts.PreparedDir)
+		if err != nil || found {
+			return err
+		}
+	}
+
+	fmt.Println("Converting to Lagrange basis ...")
+	lagFile, err := os.Create(lagPath)
 	if err != nil {
 		return err
 	}
 	defer lagFile.Close()
+	return convertLagrange(header1, header2.Domain, phase1File, lagFile, opts.MemoryBudget)
+}
+
+// convertLagrange appends to lagFile the sections of the phase 1 SRS converted to the Lagrange basis of the domain
+func convertLagrange(header1 *phase1.Header, size int, phase1File, lagFile *os.File, memoryBudget int64) error {
+	domain := fft.NewDomain(uint64(size))
+	N := int(math.Pow(2, float64(header1.Power)))
 
 	// TauG1
 	fmt.Println("Converting TauG1")
-	pos := int64(3)
-	if err := lagrangeG1(phase1File, lagFile, pos, domain); err != nil {
+	pos := header1.Size()
+	if err := lagrangeG1(phase1File, lagFile, pos, domain, memoryBudget); err != nil {
 		return err
 	}
 	// AlphaTauG1
 	fmt.Println("Converting AlphaTauG1")
 	pos += 32 * (2*int64(N) - 1)
-	if err := lagrangeG1(phase1File, lagFile, pos, domain); err != nil {
+	if err := lagrangeG1(phase1File, lagFile, pos, domain, memoryBudget); err != nil {
 		return err
 	}
 
 	// BetaTauG1
 	fmt.Println("Converting BetaTauG1")
 	pos += 32 * int64(N)
-	if err := lagrangeG1(phase1File, lagFile, pos, domain); err != nil {
+	if err := lagrangeG1(phase1File, lagFile, pos, domain, memoryBudget); err != nil {
 		return err
 	}
 
 	// TauG2
 	fmt.Println("Converting TauG2")
 	pos += 32 * int64(N)
-	if err := lagrangeG2(phase1File, lagFile, pos, domain); err != nil {
+	if err := lagrangeG2(phase1File, lagFile, pos, domain, memoryBudget); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func processEvaluations(header1 *phase1.Header, header2 *Header, r1csPath string, phase1File *os.File) error {
+func processEvaluations(header1 *phase1.Header, header2 *Header, r1csPath string, phase1File *os.File, lagPath, evalsPath string, opts *Options) error {
 	fmt.Println("Processing evaluation of [A]₁, [B]₁, [B]₂")
 
-	lagFile, err := os.Open("srs.lag")
-	if err != nil {
-		return err
-	}
-	defer lagFile.Close()
-
-	evalFile, err := os.Create("evals")
-	if err != nil {
-		return err
-	}
-	defer evalFile.Close()
-
-	// Read [α]₁ , [β]₁ , [β]₂  from phase1 (Check Phase 1 file format for reference)
-	alpha, beta1, beta2, err := readPhase1(phase1File, header1.Power)
-	if err != nil {
-		return err
-	}
-
-	// Write [α]₁ , [β]₁ , [β]₂
-	enc := bn254.NewEncoder(evalFile)
-	if err := enc.Encode(alpha); err != nil {
-		return err
-	}
-	if err := enc.Encode(beta1); err != nil {
-		return err
-	}
-	if err := enc.Encode(beta2); err != nil {
-		return err
-	}
-
-	var tauG1 []bn254.G1Affine
-
 	// Read R1CS File
-	r1csFile, err := os.Open(r1csPath)
+	r1cs, err := ReadR1CS(r1csPath)
 	if err != nil {
 		return err
 	}
-	defer r1csFile.Close()
-	var r1cs cs_bn254.R1CS
-	if _, err := r1cs.ReadFrom(r1csFile); err != nil {
-		return err
-	}
-
-	// Deserialize Lagrange SRS TauG1
-	dec := bn254.NewDecoder(lagFile)
-	if err := dec.Decode(&tauG1); err != nil {
-		return err
-	}
-
-	// Accumlate {[A]₁}
-	buff := make([]bn254.G1Affine, header2.Wires)
-	for i, c := range r1cs.Constraints {
-		for _, t := range c.L {
-			accumulateG1(&r1cs, &buff[t.WireID()], t, &tauG1[i])
-		}
-	}
-	// Serialize {[A]₁}
-	if err := enc.Encode(buff); err != nil {
-		return err
-	}
-
-	// Reset buff
-	buff = make([]bn254.G1Affine, header2.Wires)
-	// Accumlate {[B]₁}
-	for i, c := range r1cs.Constraints {
-		for _, t := range c.R {
-			accumulateG1(&r1cs, &buff[t.WireID()], t, &tauG1[i])
-		}
-	}
-	// Serialize {[B]₁}
-	if err := enc.Encode(buff); err != nil {
-		return err
-	}
-
-	var tauG2 []bn254.G2Affine
-	buff2 := make([]bn254.G2Affine, header2.Wires)
-
-	// Seek to Lagrange SRS TauG2 by skipping AlphaTau and BetaTau
-	pos := 2*32*int64(header2.Domain) + 2*4
-	if _, err := lagFile.Seek(pos, io.SeekCurrent); err != nil {
-		return err
-	}
-
-	// Deserialize Lagrange SRS TauG2
-	if err := dec.Decode(&tauG2); err != nil {
-		return err
-	}
-	// Accumlate {[B]₂}
-	for i, c := range r1cs.Constraints {
-		for _, t := range c.R {
-			accumulateG2(&r1cs, &buff2[t.WireID()], t, &tauG2[i])
-		}
-	}
-	// Serialize {[B]₂}
-	if err := enc.Encode(buff2); err != nil {
-		return err
-	}
-
-	return nil
+	return inMemory(r1cs, opts.MemoryBudget).writeEvaluations(header1, header2, phase1File, lagPath, evalsPath)
 }
 
-func processDeltaAndZ(header1 *phase1.Header, header2 *Header, phase1File, phase2File *os.File) error {
+func processDeltaAndZ(header1 *phase1.Header, header2 *Header, phase1File, phase2File *os.File, opts *Options) error {
 	fmt.Println("Processing Delta and Z")
 	writer := bufio.NewWriter(phase2File)
 	defer writer.Flush()
@@ -241,177 +192,123 @@ func processDeltaAndZ(header1 *phase1.Header, header2 *Header, phase1File, phase
 		return err
 	}
 
-	// Seek to TauG1
-	var pos int64 = 3
-	if _, err := phase1File.Seek(pos, io.SeekStart); err != nil {
-		return err
-	}
-	reader := bufio.NewReader(phase1File)
-	dec := bn254.NewDecoder(reader)
-
+	// Z[i] = [τⁿ⁺ⁱ]₁ - [τⁱ]₁ for i < n-1, streamed from TauG1 by two readers
 	n := header2.Domain
-	tauG1 := make([]bn254.G1Affine, 2*n-1)
-	for i := 0; i < len(tauG1); i++ {
-		if err := dec.Decode(&tauG1[i]); err != nil {
-			return err
+	batchSize := minInt(n-1, srsBatchSize)
+	low := newG1Reader(phase1File, header1.Size(), batchSize)
+	high := newG1Reader(phase1File, header1.Size()+32*int64(n), batchSize)
+	z := func(process func(start int, Z []bn254.G1Affine) error) error {
+		for start := 0; start < n-1; start += batchSize {
+			count := minInt(batchSize, n-1-start)
+			tauLow, err := low.next(count)
+			if err != nil {
+				return err
+			}
+			tauHigh, err := high.next(count)
+			if err != nil {
+				return err
+			}
+			common.Parallelize(count, func(start, end int) {
+				for i := start; i < end; i++ {
+					tauHigh[i].Sub(&tauHigh[i], &tauLow[i])
+				}
+			})
+			if err := process(start, tauHigh); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
-	// Calculate Z
-	Z := make([]bn254.G1Affine, n)
-	for i := 0; i < n-1; i++ {
-		Z[i].Sub(&tauG1[i+n], &tauG1[i])
-	}
-	common.BitReverseG1(Z)
-	Z = Z[:n-1]
-
-	// Write Z
-	for i := 0; i < len(Z); i++ {
-		if err := enc.Encode(&Z[i]); err != nil {
+	// Z is written in bit reversed order, without its last point which is zero
+	if int64(n)*g1Size+2*int64(batchSize)*(g1Size+bn254.SizeOfG1AffineCompressed) <= opts.MemoryBudget {
+		Z := make([]bn254.G1Affine, n)
+		if err := z(func(start int, batch []bn254.G1Affine) error {
+			copy(Z[start:], batch)
+			return nil
+		}); err != nil {
 			return err
 		}
+		common.BitReverseG1(Z)
+		Z = Z[:n-1]
+		for i := 0; i < len(Z); i++ {
+			if err := enc.Encode(&Z[i]); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	return nil
-}
-
-func processPVCKK(header1 *phase1.Header, header2 *Header, r1csPath string, phase2File *os.File) error {
-	fmt.Println("Processing PKK, VKK, and CKK")
-	lagFile, err := os.Open("srs.lag")
-	if err != nil {
-		return err
-	}
-	defer lagFile.Close()
 
-	// Read R1CS File
-	r1csFile, err := os.Open(r1csPath)
+	fmt.Println("Spilling Z to disk")
+	spillFile, err := os.CreateTemp(filepath.Dir(phase2File.Name()), "z")
 	if err != nil {
 		return err
 	}
-	defer r1csFile.Close()
-	var r1cs cs_bn254.R1CS
-	if _, err := r1cs.ReadFrom(r1csFile); err != nil {
-		return err
-	}
-
-	var buffSRS []bn254.G1Affine
-	reader := bufio.NewReader(lagFile)
-	writer := bufio.NewWriter(phase2File)
-	defer writer.Flush()
-	dec := bn254.NewDecoder(reader)
-	enc := bn254.NewEncoder(writer)
-
-	// L = O(TauG1) + R(AlphaTauG1) + L(BetaTauG1)
-	L := make([]bn254.G1Affine, header2.Wires)
-
-	// Deserialize Lagrange SRS TauG1
-	if err := dec.Decode(&buffSRS); err != nil {
-		return err
-	}
-
-	for i, c := range r1cs.Constraints {
-		// Output(Tau)
-		for _, t := range c.O {
-			accumulateG1(&r1cs, &L[t.WireID()], t, &buffSRS[i])
+	defer os.Remove(spillFile.Name())
+	defer spillFile.Close()
+	spillWriter := bufio.NewWriter(spillFile)
+	if err := z(func(_ int, batch []bn254.G1Affine) error {
+		for i := range batch {
+			raw := batch[i].RawBytes()
+			if _, err := spillWriter.Write(raw[:]); err != nil {
+				return err
+			}
 		}
-	}
-
-	// Deserialize Lagrange SRS AlphaTauG1
-	if err := dec.Decode(&buffSRS); err != nil {
+		return nil
+	}); err != nil {
 		return err
 	}
-	for i, c := range r1cs.Constraints {
-		// Right(AlphaTauG1)
-		for _, t := range c.R {
-			accumulateG1(&r1cs, &L[t.WireID()], t, &buffSRS[i])
-		}
-	}
-
-	// Deserialize Lagrange SRS BetaTauG1
-	if err := dec.Decode(&buffSRS); err != nil {
+	if err := spillWriter.Flush(); err != nil {
 		return err
 	}
-	for i, c := range r1cs.Constraints {
-		// Left(BetaTauG1)
-		for _, t := range c.L {
-			accumulateG1(&r1cs, &L[t.WireID()], t, &buffSRS[i])
-		}
-	}
+	return writeBitReversed(spillFile, n, batchSize, enc)
+}
 
-	pkk, vkk, ckk := filterL(L, header2, &r1cs.CommitmentInfo)
-	// Write PKK
-	for i := 0; i < len(pkk); i++ {
-		if err := enc.Encode(&pkk[i]); err != nil {
-			return err
+// writeBitReversed encodes the n-1 first points of the bit reversal of the n raw points spilled to file,
+// the last one is never read as it stays in place
+func writeBitReversed(file *os.File, n, batchSize int, enc *bn254.Encoder) error {
+	const size = bn254.SizeOfG1AffineUncompressed
+	shift := 64 - bits.TrailingZeros64(uint64(n))
+	buf := make([]byte, batchSize*size)
+	points := make([]bn254.G1Affine, batchSize)
+	for start := 0; start < n-1; start += batchSize {
+		count := minInt(batchSize, n-1-start)
+		for j := 0; j < count; j++ {
+			i := bits.Reverse64(uint64(start+j)) >> shift
+			if _, err := file.ReadAt(buf[j*size:(j+1)*size], int64(i)*size); err != nil {
+				return err
+			}
+		}
+		errs := make([]error, count)
+		common.Parallelize(count, func(start, end int) {
+			for j := start; j < end; j++ {
+				_, errs[j] = points[j].SetBytes(buf[j*size : (j+1)*size])
+			}
+		})
+		for j := 0; j < count; j++ {
+			if errs[j] != nil {
+				return errs[j]
+			}
+			if err := enc.Encode(&points[j]); err != nil {
+				return err
+			}
 		}
 	}
-
-	// VKK
-	evalFile, err := os.OpenFile("evals", os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer evalFile.Close()
-	evalWriter := bufio.NewWriter(evalFile)
-	defer evalWriter.Flush()
-	evalEnc := bn254.NewEncoder(evalWriter)
-	if err := evalEnc.Encode(vkk); err != nil {
-		return err
-	}
-
-	// Write CKK
-	if err := evalEnc.Encode(ckk); err != nil {
-		return err
-	}
-
-	// Write CommitmentInfo
-	cmtEnc := gob.NewEncoder(evalWriter)
-	if err := cmtEnc.Encode(r1cs.CommitmentInfo); err != nil {
-		return err
-	}
-
 	return nil
 }
 
-func accumulateG1(r1cs *cs_bn254.R1CS, res *bn254.G1Affine, t constraint.Term, value *bn254.G1Affine) {
-	cID := t.CoeffID()
-	switch cID {
-	case constraint.CoeffIdZero:
-		return
-	case constraint.CoeffIdOne:
-		res.Add(res, value)
-	case constraint.CoeffIdMinusOne:
-		res.Sub(res, value)
-	case constraint.CoeffIdTwo:
-		res.Add(res, value).Add(res, value)
-	default:
-		var tmp bn254.G1Affine
-		var vBi big.Int
-		r1cs.Coefficients[cID].BigInt(&vBi)
-		tmp.ScalarMultiplication(value, &vBi)
-		res.Add(res, &tmp)
-	}
-}
+func processPVCKK(header1 *phase1.Header, header2 *Header, r1csPath string, commitments []Commitment, phase2File *os.File, lagPath, evalsPath string, opts *Options) error {
+	fmt.Println("Processing PKK, VKK, and CKK")
 
-func accumulateG2(r1cs *cs_bn254.R1CS, res *bn254.G2Affine, t constraint.Term, value *bn254.G2Affine) {
-	cID := t.CoeffID()
-	switch cID {
-	case constraint.CoeffIdZero:
-		return
-	case constraint.CoeffIdOne:
-		res.Add(res, value)
-	case constraint.CoeffIdMinusOne:
-		res.Sub(res, value)
-	case constraint.CoeffIdTwo:
-		res.Add(res, value).Add(res, value)
-	default:
-		var tmp bn254.G2Affine
-		var vBi big.Int
-		r1cs.Coefficients[cID].BigInt(&vBi)
-		tmp.ScalarMultiplication(value, &vBi)
-		res.Add(res, &tmp)
+	// Read R1CS File
+	r1cs, err := ReadR1CS(r1csPath)
+	if err != nil {
+		return err
 	}
+	return inMemory(r1cs, opts.MemoryBudget).writePVCKK(header2, commitments, phase2File, lagPath, evalsPath)
 }
 
+// scale multiplies the N points read from dec by delta
 func scale(dec *bn254.Decoder, enc *bn254.Encoder, N int, delta *big.Int) error {
 	// Allocate batch with smallest of (N, batchSize)
 	const batchSize = 1048576 // 2^20
@@ -427,6 +324,9 @@ func scale(dec *bn254.Decoder, enc *bn254.Encoder, N int, delta *big.Int) error
 				return err
 			}
 		}
+		if err := common.CheckG1(buff[:readCount]); err != nil {
+			return err
+		}
 
 		// Process the batch
 		common.Parallelize(readCount, func(start, end int) {
@@ -474,7 +374,7 @@ func verifyContribution(c *Contribution, prevDelta bn254.G1Affine, prevHash []by
 func verifyParameter(delta, g *bn254.G2Affine, inputDecoder, originDecoder *bn254.Decoder, size int, field string) error {
 	// aggregate points
 	if in, or, err := aggregate(inputDecoder, originDecoder, size); err != nil {
-		return nil
+		return err
 	} else {
 		if !common.SameRatio(*in, *or, *delta, *g) {
 			return fmt.Errorf("inconsistent update to %s", field)
@@ -483,6 +383,7 @@ func verifyParameter(delta, g *bn254.G2Affine, inputDecoder, originDecoder *bn25
 	return nil
 }
 
+// aggregate returns the same random combination of the size points read from each decoder
 func aggregate(inputDecoder, originDecoder *bn254.Decoder, size int) (*bn254.G1Affine, *bn254.G1Affine, error) {
 	var inG, orG, tmp bn254.G1Affine
 	// Allocate batch with smallest of (N, batchSize)
@@ -508,6 +409,9 @@ func aggregate(inputDecoder, originDecoder *bn254.Decoder, size int) (*bn254.G1A
 				return nil, nil, err
 			}
 		}
+		if err := common.CheckG1(buff[:readCount]); err != nil {
+			return nil, nil, err
+		}
 
 		// Aggregate input
 		if _, err := tmp.MultiExp(buff[:readCount], r[:readCount], ecc.MultiExpConfig{}); err != nil {
@@ -521,6 +425,9 @@ func aggregate(inputDecoder, originDecoder *bn254.Decoder, size int) (*bn254.G1A
 				return nil, nil, err
 			}
 		}
+		if err := common.CheckG1(buff[:readCount]); err != nil {
+			return nil, nil, err
+		}
 
 		// Aggregate origin
 		if _, err := tmp.MultiExp(buff[:readCount], r[:readCount], ecc.MultiExpConfig{}); err != nil {
@@ -535,35 +442,101 @@ func aggregate(inputDecoder, originDecoder *bn254.Decoder, size int) (*bn254.G1A
 	return &inG, &orG, nil
 }
 
-func filterL(L []bn254.G1Affine, header2 *Header, cmtInfo *constraint.Commitment) ([]bn254.G1Affine, []bn254.G1Affine, []bn254.G1Affine) {
-	pkk := make([]bn254.G1Affine, header2.Witness)
-	vkk := make([]bn254.G1Affine, header2.Public)
-	ckk := make([]bn254.G1Affine, header2.PrivateCommitted)
-	vI, cI := 0, 0
+// kkWriter splits L, given wire after wire, into the K of the prover, the K of the verifier which includes
+// the commitment wires, and the K of the private wires committed to by each commitment. PKK is written
+// as it comes, while VKK and CKK are kept until finish.
+type kkWriter struct {
+	enc         *bn254.Encoder
+	nbPublic    int
+	commitments []Commitment
+	vkk         []bn254.G1Affine
+	ckk         [][]bn254.G1Affine
+	wire        int // next wire of L
+	nbCommitted int // commitment wires met so far
+}
+
+func newKKWriter(header2 *Header, commitments []Commitment, enc *bn254.Encoder) *kkWriter {
+	ckk := make([][]bn254.G1Affine, len(commitments))
+	for j := range commitments {
+		ckk[j] = make([]bn254.G1Affine, 0, len(commitments[j].PrivateCommitted))
+	}
+	return &kkWriter{
+		enc:         enc,
+		nbPublic:    header2.Public - len(commitments),
+		commitments: commitments,
+		vkk:         make([]bn254.G1Affine, 0, header2.Public),
+		ckk:         ckk,
+	}
+}
+
+// write writes PKK of the next wires, whose keys are L
+func (w *kkWriter) write(L []bn254.G1Affine) error {
 	for i := range L {
-		isCommittedPrivate := cI < cmtInfo.NbPrivateCommitted && i == cmtInfo.PrivateCommitted()[i]
-		isCommitment := cmtInfo.Is() && i == cmtInfo.CommitmentIndex
-		isPublic := i < header2.Public
-		if isCommittedPrivate {
-			ckk[cI].Set(&L[i])
-			cI++
-		} else if isCommitment || isPublic {
-			vkk[vI].Set(&L[i])
-			vI++
-		} else {
-			pkk[i-cI-vI].Set(&L[i])
+		wire := w.wire
+		w.wire++
+		isPublic := wire < w.nbPublic
+		isCommitment := w.nbCommitted < len(w.commitments) && wire == w.commitments[w.nbCommitted].CommitmentIndex
+		committedBy := -1
+		for j := range w.commitments {
+			if cI := len(w.ckk[j]); cI < len(w.commitments[j].PrivateCommitted) && wire == w.commitments[j].PrivateCommitted[cI] {
+				committedBy = j
+				break
+			}
 		}
+		if isCommitment || isPublic {
+			w.vkk = append(w.vkk, L[i])
+			if isCommitment {
+				w.nbCommitted++
+			}
+		} else if committedBy != -1 {
+			w.ckk[committedBy] = append(w.ckk[committedBy], L[i])
+		} else if err := w.enc.Encode(&L[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish writes VKK, CKK, and the commitments to the evaluations file.
+// CKK of all commitments are written as one vector, and the commitments are written twice: first as
+// the CommitmentInfo of the gnark fork, which is empty for several commitments, then as []Commitment
+func (w *kkWriter) finish(info constraint.Commitment, evalsPath string) error {
+	evalFile, err := os.OpenFile(evalsPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer evalFile.Close()
+	evalWriter := bufio.NewWriter(evalFile)
+	defer evalWriter.Flush()
+	evalEnc := bn254.NewEncoder(evalWriter)
+
+	// Write VKK
+	if err := evalEnc.Encode(w.vkk); err != nil {
+		return err
 	}
 
-	return pkk, vkk, ckk
+	// Write CKK
+	var allCKK []bn254.G1Affine
+	for j := range w.ckk {
+		allCKK = append(allCKK, w.ckk[j]...)
+	}
+	if err := evalEnc.Encode(allCKK); err != nil {
+		return err
+	}
+
+	// Write CommitmentInfo and commitments
+	cmtEnc := gob.NewEncoder(evalWriter)
+	if err := cmtEnc.Encode(info); err != nil {
+		return err
+	}
+	return cmtEnc.Encode(w.commitments)
 }
 
-func readPhase1(phase1File *os.File, power byte) (*bn254.G1Affine, *bn254.G1Affine, *bn254.G2Affine, error) {
+func readPhase1(phase1File *os.File, header1 *phase1.Header) (*bn254.G1Affine, *bn254.G1Affine, *bn254.G2Affine, error) {
 	var alpha, beta1 bn254.G1Affine
 	var beta2 bn254.G2Affine
-	N := int64(math.Pow(2, float64(power)))
-	const HeaderSize = 3
-	posAlpha := HeaderSize + 32*(2*N-1)
+	N := int64(math.Pow(2, float64(header1.Power)))
+	posAlpha := header1.Size() + 32*(2*N-1)
 	posBeta1 := posAlpha + 32*N
 	posBeta2 := posBeta1 + 96*N
 
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/phase1"
+	"github.com/bnb-chain/zkbnb-setup/phase2"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// CubicCircuit defines x³ + x + 5 == y
+type CubicCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (circuit *CubicCircuit) Define(api frontend.API) error {
+	x3 := api.Mul(circuit.X, circuit.X, circuit.X)
+	api.AssertIsEqual(circuit.Y, api.Add(x3, circuit.X, 5))
+	return nil
+}
+
+func TestBundle(t *testing.T) {
+	// Compile the circuits
+	circuits := map[string]frontend.Circuit{
+		"mimc.r1cs":  &Circuit{},
+		"cubic.r1cs": &CubicCircuit{},
+	}
+	for name, circuit := range circuits {
+		ccs, err := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, circuit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer, err := os.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ccs.WriteTo(writer)
+		writer.Close()
+	}
+
+	var power byte = 9
+
+	// Phase 1
+	if err := phase1.Initialize(power, "test", "0.ph1"); err != nil {
+		t.Error(err)
+	}
+	if err := phase1.Contribute("0.ph1", "1.ph1", phase1.ContributeOptions{}); err != nil {
+		t.Error(err)
+	}
+
+	// Phase 2 initialization of each circuit
+	if err := phase2.Initialize("1.ph1", "mimc.r1cs", "mimc.ph2", "", phase2.Options{}); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.Initialize("1.ph1", "cubic.r1cs", "cubic.ph2", "", phase2.Options{}); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.NewBundle("0.ph2b", []string{"mimc.ph2", "cubic.ph2"}); err != nil {
+		t.Error(err)
+	}
+
+	// Contribute to the bundle
+	if err := phase2.ContributeBundle("0.ph2b", "1.ph2b"); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.ContributeBundle("1.ph2b", "2.ph2b"); err != nil {
+		t.Error(err)
+	}
+
+	// Each circuit is labelled after its R1CS
+	labels, err := phase2.Labels("2.ph2b")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(labels) != 2 || labels[0] != "mimc" || labels[1] != "cubic" {
+		t.Errorf("unexpected circuit labels %v", labels)
+	}
+
+	// Verify the bundle and each circuit on its own
+	if err := phase2.VerifyBundle("2.ph2b", "0.ph2b"); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.VerifyBundleTransition("1.ph2b", "2.ph2b"); err != nil {
+		t.Error(err)
+	}
+	if err := os.MkdirAll("bundle", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.ExtractBundle("2.ph2b", "bundle"); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.Verify("bundle/mimc.ph2", "mimc.ph2"); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.Verify("bundle/cubic.ph2", "cubic.ph2"); err != nil {
+		t.Error(err)
+	}
+}
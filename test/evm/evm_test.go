@@ -0,0 +1,295 @@
+// Package evm checks that the multi-circuit verifier contract accepts valid proofs when called in an in-process EVM.
+// It's a separate module to keep go-ethereum out of the dependencies of the setup. The keys, the proofs, and the
+// contract compiled by solc 0.8.30 are in testdata, so the test runs without solc; `go test -update` regenerates them
+// with solc found on the PATH or at $SOLC.
+package evm
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/keys"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// MimcCircuit defines a pre-image knowledge proof
+// mimc(secret preImage) = public hash
+type MimcCircuit struct {
+	PreImage frontend.Variable
+	Hash     frontend.Variable `gnark:",public"`
+}
+
+// Define declares the circuit's constraints
+// Hash = mimc(PreImage)
+func (circuit *MimcCircuit) Define(api frontend.API) error {
+	mimc, _ := mimc.NewMiMC(api)
+	mimc.Write(circuit.PreImage)
+	api.AssertIsEqual(circuit.Hash, mimc.Sum())
+	return nil
+}
+
+// ProductCircuit proves the knowledge of X such that X·Y = Z for public Y and Z
+type ProductCircuit struct {
+	X    frontend.Variable
+	Y, Z frontend.Variable `gnark:",public"`
+}
+
+// Define declares the circuit's constraints
+// Z = X·Y
+func (circuit *ProductCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(circuit.X, circuit.Y), circuit.Z)
+	return nil
+}
+
+// update regenerates the testdata, compiling the contract with solc found on the PATH or at $SOLC
+var update = flag.Bool("update", false, "regenerate testdata with solc")
+
+// sample is a proof of a circuit of the contract, as verifyProof takes it
+type sample struct {
+	id    *big.Int
+	proof [8]*big.Int
+	input []*big.Int
+}
+
+// setupAndProve sets up circuit, and writes its verifying key, a proof of assignment, and its public inputs
+// to name.vk, name.proof, and name.public in dir
+func setupAndProve(t *testing.T, circuit, assignment frontend.Circuit, dir, name string) {
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, circuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prf, err := groth16.Prove(ccs, pk, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := w.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ext, v := range map[string]io.WriterTo{".vk": vk, ".proof": prf, ".public": public} {
+		var buf bytes.Buffer
+		if _, err := v.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+ext), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readSample reads the proof and the public inputs written by setupAndProve
+func readSample(t *testing.T, id uint64, dir, name string) sample {
+	// Proof is [A]₁, [B]₂, [C]₁, the coordinates of G2 are passed as A1 then A0
+	proofFile, err := os.Open(filepath.Join(dir, name+".proof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proofFile.Close()
+	var a, c bn254.G1Affine
+	var b bn254.G2Affine
+	dec := bn254.NewDecoder(proofFile)
+	for _, v := range []interface{}{&a, &b, &c} {
+		if err := dec.Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := sample{id: new(big.Int).SetUint64(id)}
+	for i, e := range []interface{ BigInt(*big.Int) *big.Int }{
+		&a.X, &a.Y, &b.X.A1, &b.X.A0, &b.Y.A1, &b.Y.A0, &c.X, &c.Y,
+	} {
+		s.proof[i] = e.BigInt(new(big.Int))
+	}
+
+	publicFile, err := os.Open(filepath.Join(dir, name+".public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publicFile.Close()
+	public, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := public.ReadFrom(publicFile); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range public.Vector().(fr.Vector) {
+		s.input = append(s.input, v.BigInt(new(big.Int)))
+	}
+	return s
+}
+
+// compile compiles the contract at solPath with solc for Shanghai, and writes the creation code and ABI of the
+// contract name to name.bin and name.abi in dir
+func compile(t *testing.T, solPath, name, dir string) {
+	solc := os.Getenv("SOLC")
+	if solc == "" {
+		var err error
+		if solc, err = exec.LookPath("solc"); err != nil {
+			t.Fatal("solc is needed to update the testdata")
+		}
+	}
+	outDir := t.TempDir()
+	out, err := exec.Command(solc, "--optimize", "--evm-version", "shanghai", "--bin", "--abi", "-o", outDir, solPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("solc: %v\n%s", err, out)
+	}
+	for _, ext := range []string{".bin", ".abi"} {
+		b, err := os.ReadFile(filepath.Join(outDir, name+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+ext), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readContract reads the creation code and the ABI written by compile
+func readContract(t *testing.T, dir, name string) ([]byte, abi.ABI) {
+	bin, err := os.ReadFile(filepath.Join(dir, name+".bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abiJSON, err := os.ReadFile(filepath.Join(dir, name+".abi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contractABI, err := abi.JSON(bytes.NewReader(abiJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return common.FromHex(strings.TrimSpace(string(bin))), contractABI
+}
+
+func TestMultiVerifier(t *testing.T) {
+	const dir = "testdata"
+	circuits := []keys.SolCircuit{
+		{ID: 1, VKPath: filepath.Join(dir, "mimc.vk")},
+		{ID: 16, VKPath: filepath.Join(dir, "product.vk")},
+	}
+	solPath := filepath.Join(dir, "MultiVerifier.sol")
+	if *update {
+		preImage, _ := new(big.Int).SetString("16130099170765464552823636852555369511329944820189892919423002775646948828469", 10)
+		hash, _ := new(big.Int).SetString("12886436712380113721405259596386800092738845035233065858332878701083870690753", 10)
+		setupAndProve(t, &MimcCircuit{}, &MimcCircuit{PreImage: preImage, Hash: hash}, dir, "mimc")
+		setupAndProve(t, &ProductCircuit{}, &ProductCircuit{X: 3, Y: 5, Z: 15}, dir, "product")
+		if err := keys.ExportMultiSol(circuits, solPath, keys.SolOptions{Name: "MultiVerifier"}); err != nil {
+			t.Fatal(err)
+		}
+		compile(t, solPath, "MultiVerifier", dir)
+	}
+
+	// The committed bytecode was compiled from the contract exported for the committed keys,
+	// so it's exported again to catch changes of the template that weren't compiled
+	exported := filepath.Join(t.TempDir(), "MultiVerifier.sol")
+	if err := keys.ExportMultiSol(circuits, exported, keys.SolOptions{Name: "MultiVerifier"}); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(solPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("the exported contract differs from testdata/MultiVerifier.sol, run the test with -update and solc")
+	}
+	code, contractABI := readContract(t, dir, "MultiVerifier")
+	samples := []sample{
+		readSample(t, 1, dir, "mimc"),
+		readSample(t, 16, dir, "product"),
+	}
+
+	// Deploy, then verify through eth_call-style calls on the same state.
+	// The contract is compiled for Shanghai, so PUSH0 has to be enabled
+	shanghai := uint64(0)
+	cfg := &runtime.Config{ChainConfig: &params.ChainConfig{
+		ChainID:             big.NewInt(1),
+		HomesteadBlock:      new(big.Int),
+		EIP150Block:         new(big.Int),
+		EIP155Block:         new(big.Int),
+		EIP158Block:         new(big.Int),
+		ByzantiumBlock:      new(big.Int),
+		ConstantinopleBlock: new(big.Int),
+		PetersburgBlock:     new(big.Int),
+		IstanbulBlock:       new(big.Int),
+		MuirGlacierBlock:    new(big.Int),
+		BerlinBlock:         new(big.Int),
+		LondonBlock:         new(big.Int),
+		ShanghaiTime:        &shanghai,
+	}}
+	_, address, _, err := runtime.Create(code, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyProof := func(s sample) (bool, error) {
+		input, err := contractABI.Pack("verifyProof", s.id, s.proof, s.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret, _, err := runtime.Call(address, input, cfg)
+		if err != nil {
+			return false, err
+		}
+		res, err := contractABI.Unpack("verifyProof", ret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res[0].(bool), nil
+	}
+
+	for _, s := range samples {
+		if ok, err := verifyProof(s); err != nil || !ok {
+			t.Errorf("valid proof of circuit %s is rejected: %v", s.id, err)
+		}
+
+		// A wrong public input fails the pairing check
+		wrong := s
+		wrong.input = append([]*big.Int{}, s.input...)
+		wrong.input[0] = new(big.Int).Add(s.input[0], big.NewInt(1))
+		if ok, err := verifyProof(wrong); err != nil || ok {
+			t.Errorf("proof of circuit %s is accepted for a wrong input: %v", s.id, err)
+		}
+	}
+
+	// The proof of a circuit doesn't verify against the verifying key of another one, and unknown circuits revert
+	swapped := samples[0]
+	swapped.id = samples[1].id
+	swapped.input = samples[1].input
+	if ok, _ := verifyProof(swapped); ok {
+		t.Error("proof is accepted for another circuit")
+	}
+	unknown := samples[0]
+	unknown.id = big.NewInt(2)
+	if _, err := verifyProof(unknown); err == nil {
+		t.Error("proof of an unknown circuit doesn't revert")
+	}
+}
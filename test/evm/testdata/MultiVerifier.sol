@@ -0,0 +1,301 @@
+
+// SPDX-License-Identifier: AML
+//
+// Copyright 2017 Christian Reitwiessner
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+// 2019 OKIMS
+
+pragma solidity ^0.8.0;
+
+library Pairing {
+
+    uint256 constant PRIME_Q = 21888242871839275222246405745257275088696311157297823662689037894645226208583;
+
+    struct G1Point {
+        uint256 X;
+        uint256 Y;
+    }
+
+    // Encoding of field elements is: X[0] * z + X[1]
+    struct G2Point {
+        uint256[2] X;
+        uint256[2] Y;
+    }
+
+    /*
+     * @return The negation of p, i.e. p.plus(p.negate()) should be zero.
+     */
+    function negate(G1Point memory p) internal pure returns (G1Point memory) {
+
+        // The prime q in the base field F_q for G1
+        if (p.X == 0 && p.Y == 0) {
+            return G1Point(0, 0);
+        } else {
+            return G1Point(p.X, PRIME_Q - (p.Y % PRIME_Q));
+        }
+    }
+
+    /*
+     * @return The sum of two points of G1
+     */
+    function plus(
+        G1Point memory p1,
+        G1Point memory p2
+    ) internal view returns (G1Point memory r) {
+
+        uint256[4] memory input;
+        input[0] = p1.X;
+        input[1] = p1.Y;
+        input[2] = p2.X;
+        input[3] = p2.Y;
+        bool success;
+
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 6, input, 0xc0, r, 0x60)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 { invalid() }
+        }
+
+        require(success,"pairing-add-failed");
+    }
+
+
+    /*
+     * Same as plus but accepts raw input instead of struct
+     * @return The sum of two points of G1, one is represented as array
+     */
+    function plus_raw(uint256[4] memory input, G1Point memory r) internal view {
+        bool success;
+
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 6, input, 0xc0, r, 0x60)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 {invalid()}
+        }
+
+        require(success, "pairing-add-failed");
+    }
+
+    /*
+     * @return The product of a point on G1 and a scalar, i.e.
+     *         p == p.scalar_mul(1) and p.plus(p) == p.scalar_mul(2) for all
+     *         points p.
+     */
+    function scalar_mul(G1Point memory p, uint256 s) internal view returns (G1Point memory r) {
+
+        uint256[3] memory input;
+        input[0] = p.X;
+        input[1] = p.Y;
+        input[2] = s;
+        bool success;
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 7, input, 0x80, r, 0x60)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 { invalid() }
+        }
+        require (success,"pairing-mul-failed");
+    }
+
+
+    /*
+     * Same as scalar_mul but accepts raw input instead of struct,
+     * Which avoid extra allocation. provided input can be allocated outside and re-used multiple times
+     */
+    function scalar_mul_raw(uint256[3] memory input, G1Point memory r) internal view {
+        bool success;
+
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 7, input, 0x80, r, 0x60)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 {invalid()}
+        }
+        require(success, "pairing-mul-failed");
+    }
+
+    /* @return The result of computing the pairing check
+     *         e(p1[0], p2[0]) *  .... * e(p1[n], p2[n]) == 1
+     *         For example,
+     *         pairing([P1(), P1().negate()], [P2(), P2()]) should return true.
+     */
+    function pairing(
+        G1Point memory a1,
+        G2Point memory a2,
+        G1Point memory b1,
+        G2Point memory b2,
+        G1Point memory c1,
+        G2Point memory c2,
+        G1Point memory d1,
+        G2Point memory d2
+    ) internal view returns (bool) {
+
+        G1Point[4] memory p1 = [a1, b1, c1, d1];
+        G2Point[4] memory p2 = [a2, b2, c2, d2];
+        uint256 inputSize = 24;
+        uint256[] memory input = new uint256[](inputSize);
+
+        for (uint256 i = 0; i < 4; i++) {
+            uint256 j = i * 6;
+            input[j + 0] = p1[i].X;
+            input[j + 1] = p1[i].Y;
+            input[j + 2] = p2[i].X[0];
+            input[j + 3] = p2[i].X[1];
+            input[j + 4] = p2[i].Y[0];
+            input[j + 5] = p2[i].Y[1];
+        }
+
+        uint256[1] memory out;
+        bool success;
+
+        // solium-disable-next-line security/no-inline-assembly
+        assembly {
+            success := staticcall(sub(gas(), 2000), 8, add(input, 0x20), mul(inputSize, 0x20), out, 0x20)
+            // Use "invalid" to make gas estimation work
+            switch success case 0 { invalid() }
+        }
+
+        require(success,"pairing-opcode-failed");
+
+        return out[0] != 0;
+    }
+}
+
+contract MultiVerifier {
+
+    using Pairing for *;
+
+    uint256 constant SNARK_SCALAR_FIELD = 21888242871839275222246405745257275088548364400416034343698204186575808495617;
+    uint256 constant PRIME_Q = 21888242871839275222246405745257275088696311157297823662689037894645226208583;
+
+    struct VerifyingKey {
+        Pairing.G1Point alfa1;
+        Pairing.G2Point beta2;
+        Pairing.G2Point gamma2;
+        Pairing.G2Point delta2;
+        Pairing.G1Point[] IC; // K in gnark
+    }
+
+    /*
+     * @returns The hardcoded verifying key of circuitId, reverts if the circuit is unknown
+     */
+    function verifyingKey(uint256 circuitId) internal pure returns (VerifyingKey memory vk) {
+        if (circuitId == 1) {
+            vk.alfa1 = Pairing.G1Point(uint256(517448958946048960648932891694132603290254602679117211253515955043639954017), uint256(18696524415995905418826054200514214990874624133134385884103167254469481044484));
+            vk.beta2 = Pairing.G2Point([uint256(5059675755539599272521991551797617117900222333987252627324229120516251640412), uint256(2095944599029444925303737448878110452234350311604316924523848180188130511120)], [uint256(2836205668063028461261097217625319898784374181572336906843698491441889398412), uint256(11403597785540367879401215690075977282337668722231566665919799874328127647970)]);
+            vk.gamma2 = Pairing.G2Point([uint256(10117898636383375693355357632008421040982051206345578119219632393721211174677), uint256(13659654418703014805177319633272210934754612271735678710713486322440766537855)], [uint256(20488420040000538665837665897089109120831262426629092989973310512487195225166), uint256(12367228429848391595461670391070314887418193132336830051031806435400432093048)]);
+            vk.delta2 = Pairing.G2Point([uint256(19290264573575152929905231859368421313463146075063911704364094322865954272885), uint256(8659544547757165184137003275557476243374543604215726763728806626489120599652)], [uint256(6991015592260307076275522614204547759142645332377112199549559275350668642714), uint256(7823879421600015992067039924166931601276259889792424116304702219581864696174)]);
+            vk.IC = new Pairing.G1Point[](2);
+            vk.IC[0] = Pairing.G1Point(uint256(8815950058006923025721951707618001461372254996510921749229047804811754398022), uint256(2351854424292690759041269499388728129549326289315952512494551030385485801947));
+            vk.IC[1] = Pairing.G1Point(uint256(19450137924654625420095971529983227486731376294134626855795162893370152876749), uint256(13460687290223176335059941898556007643866379230432273137802314033820522625909));
+        } else if (circuitId == 16) {
+            vk.alfa1 = Pairing.G1Point(uint256(14214288067160084003820847729268061613171835754660220245135086994280102601054), uint256(20134695198034081114313787755308331493821224166463148057164007357457290966552));
+            vk.beta2 = Pairing.G2Point([uint256(21424064244539897160587262523813071035998656453122949157130947664244680608319), uint256(19557277196415629505830773851714867673319837786446920551766272535529204823924)], [uint256(10835475334132486031349619993979705125421225494912266819353506606279739023432), uint256(19398409639965228291283495741538135362967661399624619345267472363693097280442)]);
+            vk.gamma2 = Pairing.G2Point([uint256(12507294671911333518243417135492428137095057509537705370192620685583783355844), uint256(8947595962275362702115046402602749053833198335757165401833401196302798877051)], [uint256(17518569578344959800916812536773135334562732827017477487805281430832691613694), uint256(3789417426191774678378451634922943313039732522213787212880645687699572494903)]);
+            vk.delta2 = Pairing.G2Point([uint256(7517315062373444558605178938005284339209615366336039309242840822598986383894), uint256(20757387745462431166512067171620936806283940594310417056256871389809033614553)], [uint256(2178158987205505259400734201905961235863784323089746015130531076471660524539), uint256(6285496001545495064664521128492586314150206093733455434158175204676258439559)]);
+            vk.IC = new Pairing.G1Point[](3);
+            vk.IC[0] = Pairing.G1Point(uint256(16979958383404060879061262462091796643891775218298491921302843401650113093867), uint256(3833276377761780566060596030543152456612582340203731525946956728060780057797));
+            vk.IC[1] = Pairing.G1Point(uint256(20477086301070665188765881237838327883858357122235694455262493414072853803315), uint256(6667514555009987761625808533065739783341918253625946133893396515992934257053));
+            vk.IC[2] = Pairing.G1Point(uint256(6781007347918944122214801954610128948464982541727097303518268561444254466032), uint256(7006434404302299357309731011014755599522331643597595798807454374742312329081));
+        } else {
+            revert("verifier-unknown-circuit");
+        }
+    }
+
+    // accumulate scalarMul(mul_input) into q
+    // that is computes sets q = (mul_input[0:2] * mul_input[3]) + q
+    function accumulate(
+        uint256[3] memory mul_input,
+        Pairing.G1Point memory p,
+        uint256[4] memory buffer,
+        Pairing.G1Point memory q
+    ) internal view {
+        // computes p = mul_input[0:2] * mul_input[3]
+        Pairing.scalar_mul_raw(mul_input, p);
+
+        // point addition inputs
+        buffer[0] = q.X;
+        buffer[1] = q.Y;
+        buffer[2] = p.X;
+        buffer[3] = p.Y;
+
+        // q = p + q
+        Pairing.plus_raw(buffer, q);
+    }
+
+    /*
+     * @returns Whether the proof is valid given the hardcoded verifying key
+     *          of circuitId above and the public inputs
+     */
+    function verifyProof(
+        uint256 circuitId,
+        uint256[8] calldata proof,
+        uint256[] calldata input
+    ) public view returns (bool r) {
+
+        // Make sure that proof.A, B, and C are each less than the prime q
+        for (uint256 i = 0; i < proof.length; i++) {
+            require(proof[i] < PRIME_Q, "verifier-proof-gte-prime-q");
+        }
+
+        // Make sure that every input is less than the snark scalar field
+        for (uint256 i = 0; i < input.length; i++) {
+            require(input[i] < SNARK_SCALAR_FIELD,"verifier-gte-snark-scalar-field");
+        }
+
+        VerifyingKey memory vk = verifyingKey(circuitId);
+        require(input.length + 1 == vk.IC.length, "verifier-bad-input-length");
+
+        // Compute the linear combination vk_x
+        Pairing.G1Point memory vk_x = Pairing.G1Point(vk.IC[0].X, vk.IC[0].Y);
+
+        // Scoped so that the buffers don't overflow the stack of the pairing call below
+        {
+            // Buffer reused for addition p1 + p2 to avoid memory allocations
+            // [0:2] -> p1.X, p1.Y ; [2:4] -> p2.X, p2.Y
+            uint256[4] memory add_input;
+
+            // Buffer reused for multiplication p1 * s
+            // [0:2] -> p1.X, p1.Y ; [3] -> s
+            uint256[3] memory mul_input;
+
+            // temporary point to avoid extra allocations in accumulate
+            Pairing.G1Point memory q = Pairing.G1Point(0, 0);
+
+            for (uint256 i = 0; i < input.length; i++) {
+                mul_input[0] = vk.IC[i + 1].X;
+                mul_input[1] = vk.IC[i + 1].Y;
+                mul_input[2] = input[i];
+                accumulate(mul_input, q, add_input, vk_x); // vk_x += vk.IC[i + 1] * input[i]
+            }
+        }
+
+        return Pairing.pairing(
+            Pairing.negate(Pairing.G1Point(proof[0], proof[1])),
+            Pairing.G2Point([proof[2], proof[3]], [proof[4], proof[5]]),
+            vk.alfa1,
+            vk.beta2,
+            vk_x,
+            vk.gamma2,
+            Pairing.G1Point(proof[6], proof[7]),
+            vk.delta2
+        );
+    }
+}
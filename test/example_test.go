@@ -1,6 +1,13 @@
 package test
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math"
 	"os"
 	"testing"
 
@@ -57,21 +64,22 @@ func TestSetup(t *testing.T) {
 	var power byte = 9
 
 	// Initialize to Phase 1
-	if err := phase1.Initialize(power, "0.ph1"); err != nil {
+	if err := phase1.Initialize(power, "test", "0.ph1"); err != nil {
 		t.Error(err)
 	}
 
 	// Contribute to Phase 1
-	if err := phase1.Contribute("0.ph1", "1.ph1"); err != nil {
+	if err := phase1.Contribute("0.ph1", "1.ph1", phase1.ContributeOptions{}); err != nil {
 		t.Error(err)
 	}
-	if err := phase1.Contribute("1.ph1", "2.ph1"); err != nil {
+	if err := phase1.Contribute("1.ph1", "2.ph1", phase1.ContributeOptions{}); err != nil {
 		t.Error(err)
 	}
-	if err := phase1.Contribute("2.ph1", "3.ph1"); err != nil {
+	if err := phase1.Contribute("2.ph1", "3.ph1", phase1.ContributeOptions{}); err != nil {
 		t.Error(err)
 	}
-	if err := phase1.Contribute("3.ph1", "4.ph1"); err != nil {
+	// The sections of the last contribution are processed concurrently
+	if err := phase1.Contribute("3.ph1", "4.ph1", phase1.ContributeOptions{ParallelSections: true}); err != nil {
 		t.Error(err)
 	}
 
@@ -80,16 +88,97 @@ func TestSetup(t *testing.T) {
 		t.Error(err)
 	}
 
+	// Contributions can't be replayed in another ceremony
+	replayed, err := os.ReadFile("4.ph1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID := sha256.Sum256([]byte("other"))
+	copy(replayed[4:36], otherID[:])
+	if err := os.WriteFile("replayed.ph1", replayed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("replayed.ph1", ""); err == nil {
+		t.Error("contributions replayed in another ceremony should fail verification")
+	} else if err.Error() != "couldn't verify knowledge of Tau in contribution 1" {
+		t.Errorf("the failing check of the batch should be pinpointed, got %v", err)
+	}
+
 	// Phase 2 initialization
-	if err := phase2.Initialize("4.ph1", "circuit.r1cs", "0.ph2"); err != nil {
+	if err := phase2.Initialize("4.ph1", "circuit.r1cs", "0.ph2", "", phase2.Options{}); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.Audit("4.ph1", "circuit.r1cs", "0.ph2", "evals", "srs.lag", "", phase2.Options{}); err != nil {
+		t.Error(err)
+	}
+
+	// The state is bound to the phase 1 parameters, whatever the header and contributions around them
+	if phase1Digest, err := parametersDigest("4.ph1"); err != nil {
+		t.Error(err)
+	} else if header2, err := readPhase2Header("0.ph2"); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(header2.Phase1Digest, phase1Digest) {
+		t.Error("phase 2 state should be bound to the digest of the phase 1 parameters")
+	}
+
+	// Within a small memory budget, the SRS is converted to the Lagrange basis on disk,
+	// the keys are accumulated wire after wire and Z is spilled to disk
+	if err := phase2.Audit("4.ph1", "circuit.r1cs", "0.ph2", "evals", "srs.lag", "", phase2.Options{MemoryBudget: 1 << 10}); err != nil {
+		t.Error(err)
+	}
+
+	// The Lagrange SRS prepared for every domain matches the phase 1 parameters, and is copied by the initialization
+	if err := phase2.Prepare("4.ph1", "prepared", phase2.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	for domain := 1; domain <= 1<<power; domain *= 2 {
+		if err := phase2.VerifyPrepared("4.ph1", fmt.Sprintf("prepared/srs.%d.lag", domain)); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := phase2.VerifyPrepared("3.ph1", "prepared/srs.64.lag"); err == nil {
+		t.Error("Lagrange SRS prepared from other parameters should fail verification")
+	}
+	if err := phase2.Initialize("4.ph1", "circuit.r1cs", "prepared.ph2", "", phase2.Options{PreparedDir: "prepared"}); err != nil {
 		t.Error(err)
 	}
+	if err := phase2.Audit("4.ph1", "circuit.r1cs", "prepared.ph2", "evals", "srs.lag", "", phase2.Options{}); err != nil {
+		t.Error(err)
+	}
+
+	// Swapping two points of the prepared SRS is caught at the random point
+	tampered, err := os.ReadFile("prepared/srs.512.lag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := tampered[40:72], tampered[72:104]
+	swapped := append(append([]byte{}, second...), first...)
+	copy(tampered[40:], swapped)
+	if err := os.WriteFile("tampered.lag", tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.VerifyPrepared("4.ph1", "tampered.lag"); err == nil {
+		t.Error("tampered Lagrange SRS should fail verification")
+	}
 
 	// Contribute to Phase 2
 	if err := phase2.Contribute("0.ph2", "1.ph2"); err != nil {
 		t.Error(err)
 	}
 
+	// The sections are compared even when the headers differ, here by the label of a renamed circuit
+	r1csData, err := os.ReadFile("circuit.r1cs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("renamed.r1cs", r1csData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected := "audit failed, mismatch in sections: Header, [δ]₁, [δ]₂, Z, PKK, Contributions"
+	if err := phase2.Audit("4.ph1", "renamed.r1cs", "1.ph2", "evals", "srs.lag", "", phase2.Options{}); err == nil || err.Error() != expected {
+		t.Errorf("every mismatching section should be reported, got %v", err)
+	}
+
 	if err := phase2.Contribute("1.ph2", "2.ph2"); err != nil {
 		t.Error(err)
 	}
@@ -103,9 +192,113 @@ func TestSetup(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := keys.ExtractKeys("3.ph2"); err != nil {
+	// Verify the last Phase 2 contribution against the previous state only
+	if err := phase2.VerifyTransition("2.ph2", "3.ph2"); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.VerifyTransition("1.ph2", "3.ph2"); err == nil {
+		t.Error("transition skipping a contribution should fail")
+	}
+
+	if err := keys.ExtractKeys("3.ph2", keys.FormatFork); err != nil {
 		t.Error(err)
 	}
+
+	// Verify keys consistency
+	if err := keys.VerifyKeys("pk", "vk", "3.ph2", "evals"); err != nil {
+		t.Error(err)
+	}
+	if err := keys.VerifyKeys("pk", "vk", "", ""); err != nil {
+		t.Error(err)
+	}
+
+	// Z and K are checked against [δ]₂ with any state of phase 2, so tampering with Z of the initial state is caught
+	if err := keys.VerifyKeys("pk", "vk", "0.ph2", "evals"); err != nil {
+		t.Error(err)
+	}
+	header2, err := readPhase2Header("0.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial, err := os.ReadFile("0.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var headerBuf bytes.Buffer
+	if err := gob.NewEncoder(&headerBuf).Encode(*header2); err != nil {
+		t.Fatal(err)
+	}
+	Z := initial[headerBuf.Len()+bn254.SizeOfG1AffineCompressed+bn254.SizeOfG2AffineCompressed:]
+	for i := 32; i < 32*(header2.Domain-1); i += 32 {
+		if !bytes.Equal(Z[:32], Z[i:i+32]) {
+			first := append([]byte{}, Z[:32]...)
+			copy(Z[:32], Z[i:i+32])
+			copy(Z[i:i+32], first)
+			break
+		}
+	}
+	if err := os.WriteFile("tampered.ph2", initial, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.VerifyKeys("pk", "vk", "tampered.ph2", ""); err == nil {
+		t.Error("keys shouldn't belong to a phase 2 state with other Z")
+	}
+
+	// Keys are checked point by point against the evaluations, swapping two points of A is caught
+	evals, err := os.ReadFile("evals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	A := evals[132:]
+	for i := 32; i < len(A); i += 32 {
+		if !bytes.Equal(A[:32], A[i:i+32]) {
+			first := append([]byte{}, A[:32]...)
+			copy(A[:32], A[i:i+32])
+			copy(A[i:i+32], first)
+			break
+		}
+	}
+	if err := os.WriteFile("stale.evals", evals, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.VerifyKeys("pk", "vk", "3.ph2", "stale.evals"); err == nil {
+		t.Error("keys shouldn't belong to other evaluations")
+	}
+
+	// Evaluations whose sizes don't match the phase 2 header are rejected before their points are allocated
+	binary.BigEndian.PutUint32(evals[128:], math.MaxUint32)
+	if err := os.WriteFile("mismatched.evals", evals, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.ExportSol("3.ph2", "mismatched.sol", keys.SolOptions{EvalsPath: "mismatched.evals"}); err == nil {
+		t.Error("evaluations of another circuit should be rejected")
+	}
+}
+
+func parametersDigest(phase1Path string) ([]byte, error) {
+	file, err := os.Open(phase1Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var header phase1.Header
+	if err := header.ReadFrom(file); err != nil {
+		return nil, err
+	}
+	return phase1.ParametersDigest(file, &header)
+}
+
+func readPhase2Header(phase2Path string) (*phase2.Header, error) {
+	file, err := os.Open(phase2Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var header phase2.Header
+	if err := header.Read(bufio.NewReader(file)); err != nil {
+		return nil, err
+	}
+	return &header, nil
 }
 
 func TestProveAndVerify(t *testing.T) {
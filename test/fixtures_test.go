@@ -0,0 +1,59 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/keys"
+	"github.com/bnb-chain/zkbnb-setup/phase1"
+	"github.com/bnb-chain/zkbnb-setup/phase2"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// setupCircuit runs a ceremony with one contribution to each phase for the test circuit in a temporary directory,
+// and changes to that directory until the end of the test. It leaves circuit.r1cs, 1.ph1, 0.ph2, 1.ph2, evals,
+// srs.lag, pk, and vk there, so the test doesn't depend on the files of another test.
+func setupCircuit(t *testing.T) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	var myCircuit Circuit
+	ccs, err := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := os.Create("circuit.r1cs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if _, err := ccs.WriteTo(writer); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := phase1.Initialize(9, "test", "0.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Contribute("0.ph1", "1.ph1", phase1.ContributeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Initialize("1.ph1", "circuit.r1cs", "0.ph2", "", phase2.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Contribute("0.ph2", "1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.ExtractKeys("1.ph2", keys.FormatFork); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
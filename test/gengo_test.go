@@ -0,0 +1,111 @@
+package test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/keys"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// TestGenGo generates the Go verifier package and runs its generated test
+func TestGenGo(t *testing.T) {
+	// The package is generated in the module so that it builds with its dependencies, the rest in the fixture directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupCircuit(t)
+
+	// Sample proof
+	var myCircuit Circuit
+	ccs, _ := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	pk := groth16.NewProvingKey(ecc.BN254)
+	pkFile, err := os.Open("pk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pkFile.Close()
+	if _, err := pk.ReadFrom(pkFile); err != nil {
+		t.Fatal(err)
+	}
+	assignment := &Circuit{
+		PreImage: "16130099170765464552823636852555369511329944820189892919423002775646948828469",
+		Hash:     "12886436712380113721405259596386800092738845035233065858332878701083870690753",
+	}
+	witness, _ := frontend.NewWitness(assignment, bn254.ID.ScalarField())
+	pubWitness, _ := witness.Public()
+	prf, err := groth16.Prove(ccs, pk, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proofBuf, witnessBuf bytes.Buffer
+	if _, err := prf.WriteTo(&proofBuf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pubWitness.WriteTo(&witnessBuf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("gengo.proof", proofBuf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("gengo.wtns", witnessBuf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := os.MkdirTemp(wd, "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := keys.GoOptions{
+		Package:     "mimcverifier",
+		ProofPath:   "gengo.proof",
+		WitnessPath: "gengo.wtns",
+	}
+	if err := keys.ExportGo("vk", filepath.Join(dir, "verifier.go"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The package generated from the phase 2 file is the same, but for the Pedersen commitment key set up on the fly
+	ph2Path := filepath.Join(t.TempDir(), "verifier.go")
+	if err := keys.ExportGo("1.ph2", ph2Path, opts); err != nil {
+		t.Fatal(err)
+	}
+	fromVK, err := os.ReadFile(filepath.Join(dir, "verifier.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPh2, err := os.ReadFile(ph2Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(withoutCommitmentKey(fromVK), withoutCommitmentKey(fromPh2)) {
+		t.Error("package generated from the phase 2 file differs from the one generated from vk")
+	}
+
+	// The generated package compiles and passes its generated test
+	cmd := exec.Command("go", "test", "./"+filepath.Base(dir))
+	cmd.Dir = wd
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated test failed: %v\n%s", err, out)
+	}
+}
+
+// withoutCommitmentKey drops the lines of the Pedersen commitment key from the generated source
+func withoutCommitmentKey(src []byte) []byte {
+	var res []byte
+	for _, line := range bytes.SplitAfter(src, []byte("\n")) {
+		if !bytes.Contains(line, []byte("commitmentG")) {
+			res = append(res, line...)
+		}
+	}
+	return res
+}
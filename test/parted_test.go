@@ -1,6 +1,7 @@
 package test
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"runtime"
@@ -67,7 +68,7 @@ func TestSetupFromPartedR1CS(t *testing.T) {
 		nbCons = ccs.GetNbConstraints()
 		nbR1C = ccs.GetNbR1C()
 		fmt.Println("After Lazify: ", ccs.GetNbR1C(), "/", ccs.GetNbConstraints())
-		batchSize = 100000
+		batchSize = 3
 
 		ccs.SplitDumpBinary("Foo", batchSize)
 	}
@@ -75,21 +76,21 @@ func TestSetupFromPartedR1CS(t *testing.T) {
 	var power byte = 9 + Cnt
 
 	// Initialize to Phase 1
-	if err := phase1.Initialize(power, "0.ph1"); err != nil {
+	if err := phase1.Initialize(power, "test", "0.ph1"); err != nil {
 		t.Error(err)
 	}
 
 	// Contribute to Phase 1
-	if err := phase1.Contribute("0.ph1", "1.ph1"); err != nil {
+	if err := phase1.Contribute("0.ph1", "1.ph1", phase1.ContributeOptions{}); err != nil {
 		t.Error(err)
 	}
-	if err := phase1.Contribute("1.ph1", "2.ph1"); err != nil {
+	if err := phase1.Contribute("1.ph1", "2.ph1", phase1.ContributeOptions{}); err != nil {
 		t.Error(err)
 	}
-	if err := phase1.Contribute("2.ph1", "3.ph1"); err != nil {
+	if err := phase1.Contribute("2.ph1", "3.ph1", phase1.ContributeOptions{}); err != nil {
 		t.Error(err)
 	}
-	if err := phase1.Contribute("3.ph1", "4.ph1"); err != nil {
+	if err := phase1.Contribute("3.ph1", "4.ph1", phase1.ContributeOptions{}); err != nil {
 		t.Error(err)
 	}
 
@@ -98,10 +99,38 @@ func TestSetupFromPartedR1CS(t *testing.T) {
 		t.Error(err)
 	}
 
-	// Phase 2 initialization
-	if err := phase2.InitializeFromPartedR1CS("4.ph1", "Foo", "0.ph2", nbCons, nbR1C, batchSize); err != nil {
+	// Phase 2 initialization, the sizes are read from the parts of the R1CS
+	split, err := phase2.ReadSplitR1CS("Foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, nbCons, split.NbConstraints)
+	assert.Equal(t, nbR1C, split.NbR1C)
+	assert.Equal(t, batchSize, split.BatchSize)
+
+	// Other files of the session, such as the levels and hints in E11 to E15, aren't part of the R1CS digest
+	assert.NoError(t, os.WriteFile("Foo.r1cs.stray", []byte("stray"), 0644))
+	defer os.Remove("Foo.r1cs.stray")
+	if err := phase2.InitializeFromPartedR1CS("4.ph1", "Foo", "0.ph2", phase2.Options{}); err != nil {
 		t.Error(err)
 	}
+	parts := []string{"Foo.r1cs.E1.save", "Foo.r1cs.E2.save", "Foo.r1cs.E3.save", "Foo.r1cs.E4.save"}
+	for from := 0; from < nbR1C; from += batchSize {
+		to := from + batchSize
+		if to > nbR1C {
+			to = nbR1C
+		}
+		parts = append(parts, fmt.Sprintf("Foo.r1cs.Cons.%d.%d.save", from, to))
+	}
+	sha := sha256.New()
+	for _, p := range parts {
+		b, err := os.ReadFile(p)
+		assert.NoError(t, err)
+		sha.Write(b)
+	}
+	header2, err := readPhase2Header("0.ph2")
+	assert.NoError(t, err)
+	assert.Equal(t, sha.Sum(nil), header2.R1CSDigest)
 
 	// Contribute to Phase 2
 	if err := phase2.Contribute("0.ph2", "1.ph2"); err != nil {
@@ -121,7 +150,10 @@ func TestSetupFromPartedR1CS(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := keys.ExtractSplitKeys("1.ph2", "Foo"); err != nil {
+	if err := keys.ExtractSplitKeys("1.ph2", "Foo", keys.FormatFork); err != nil {
+		t.Error(err)
+	}
+	if err := keys.VerifySplitKeys("Foo", "1.ph2", "evals"); err != nil {
 		t.Error(err)
 	}
 }
@@ -162,14 +194,14 @@ func TestProveFromPK(t *testing.T) {
 }
 
 func TestProveFromSplitPK(t *testing.T) {
-	// Compile the circuit
-	nbR1C := 8
-	nbCons := 2648
+	// Load the parted R1CS
 	session := "Foo"
-	batchSize := 100000
+	split, err := phase2.ReadSplitR1CS(session)
+	assert.NoError(t, err)
+	nbR1C, batchSize := split.NbR1C, split.BatchSize
 	cs2 := groth16.NewCS(ecc.BN254)
 	cs2.LoadFromSplitBinaryConcurrent(session, nbR1C, batchSize, runtime.NumCPU())
-	fmt.Println("nbCons:", cs2.GetNbConstraints(), nbCons, "nbR1C:", cs2.GetNbR1C())
+	fmt.Println("nbCons:", cs2.GetNbConstraints(), split.NbConstraints, "nbR1C:", cs2.GetNbR1C())
 
 	vk := groth16.NewVerifyingKey(ecc.BN254)
 	name := fmt.Sprintf("%s.vk.save", session)
@@ -194,4 +226,11 @@ func TestProveFromSplitPK(t *testing.T) {
 	assert.NoError(t, err)
 	err = groth16.Verify(prf, vk, pubWitness)
 	assert.NoError(t, err)
+
+	witnessJSON := `{
+		"public": ["12886436712380113721405259596386800092738845035233065858332878701083870690753"],
+		"secret": ["16130099170765464552823636852555369511329944820189892919423002775646948828469"]
+	}`
+	assert.NoError(t, os.WriteFile("witness.json", []byte(witnessJSON), 0644))
+	assert.NoError(t, keys.ProveSplit(session, name, "witness.json", "provep"))
 }
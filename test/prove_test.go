@@ -0,0 +1,65 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/keys"
+)
+
+// TestProve proves the witness JSON with the extracted keys
+func TestProve(t *testing.T) {
+	setupCircuit(t)
+	witness := `{
+		"public": ["12886436712380113721405259596386800092738845035233065858332878701083870690753"],
+		"secret": ["16130099170765464552823636852555369511329944820189892919423002775646948828469"]
+	}`
+	if err := os.WriteFile("witness.json", []byte(witness), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Prove("circuit.r1cs", "pk", "vk", "witness.json", "prove"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile("prove/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var public []string
+	if err := json.Unmarshal(data, &public); err != nil {
+		t.Fatal(err)
+	}
+	if len(public) != 1 || public[0] != "12886436712380113721405259596386800092738845035233065858332878701083870690753" {
+		t.Errorf("unexpected public inputs %v", public)
+	}
+
+	// Selector of verifyProof(uint256[2],uint256[2][2],uint256[2],uint256[1]), then 9 words
+	data, err = os.ReadFile("prove/calldata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calldata := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(calldata, "0x43753b4d") || len(calldata) != 2+2*(4+9*32) {
+		t.Errorf("unexpected calldata %s", calldata)
+	}
+
+	// The proof and public witness written feed the Foundry test of the contract
+	if err := keys.ExportSol("vk", "prove/Verifier.sol", keys.SolOptions{
+		TestPath:    "prove/Verifier.t.sol",
+		ProofPath:   "prove/proof",
+		WitnessPath: "prove/public.wtns",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A witness which doesn't satisfy the circuit isn't proven
+	witness = strings.Replace(witness, "12886436712380113721405259596386800092738845035233065858332878701083870690753", "1", 1)
+	if err := os.WriteFile("witness.json", []byte(witness), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Prove("circuit.r1cs", "pk", "vk", "witness.json", "prove"); err == nil {
+		t.Error("witness which doesn't satisfy the circuit shouldn't be proven")
+	}
+}
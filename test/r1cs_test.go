@@ -0,0 +1,202 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/phase2"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// writeCircomR1CS writes ccs in circom R1CS binary format, the public wires are written as public inputs
+func writeCircomR1CS(t *testing.T, ccs *cs_bn254.R1CS, path string) {
+	var header, constraints, labels bytes.Buffer
+	nbWires := ccs.GetNbPublicVariables() + ccs.GetNbSecretVariables() + ccs.NbInternalVariables
+
+	// Header
+	binary.Write(&header, binary.LittleEndian, uint32(fr.Bytes))
+	header.Write(littleEndian(fr.Modulus().Bytes()))
+	for _, v := range []uint32{uint32(nbWires), 0, uint32(ccs.GetNbPublicVariables() - 1), uint32(ccs.GetNbSecretVariables())} {
+		binary.Write(&header, binary.LittleEndian, v)
+	}
+	binary.Write(&header, binary.LittleEndian, uint64(nbWires))
+	binary.Write(&header, binary.LittleEndian, uint32(len(ccs.Constraints)))
+
+	// Constraints
+	for _, c := range ccs.Constraints {
+		for _, l := range []constraint.LinearExpression{c.L, c.R, c.O} {
+			binary.Write(&constraints, binary.LittleEndian, uint32(len(l)))
+			for _, term := range l {
+				binary.Write(&constraints, binary.LittleEndian, uint32(term.WireID()))
+				value := ccs.Coefficients[term.CoeffID()].Bytes()
+				constraints.Write(littleEndian(value[:]))
+			}
+		}
+	}
+
+	// Wire to label map
+	for i := 0; i < nbWires; i++ {
+		binary.Write(&labels, binary.LittleEndian, uint64(i))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	defer writer.Flush()
+	writer.WriteString("r1cs")
+	binary.Write(writer, binary.LittleEndian, uint32(1))
+	binary.Write(writer, binary.LittleEndian, uint32(3))
+	for i, section := range []*bytes.Buffer{&header, &constraints, &labels} {
+		binary.Write(writer, binary.LittleEndian, uint32(i+1))
+		binary.Write(writer, binary.LittleEndian, uint64(section.Len()))
+		writer.Write(section.Bytes())
+	}
+}
+
+// littleEndian pads b to 32 bytes and reverses it
+func littleEndian(b []byte) []byte {
+	res := make([]byte, fr.Bytes)
+	for i := range b {
+		res[i] = b[len(b)-1-i]
+	}
+	return res
+}
+
+// splitHeader returns the header of the phase 2 file at path and the remaining parameters
+func splitHeader(t *testing.T, path string) (phase2.Header, []byte) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bytes.NewReader(data)
+	var header phase2.Header
+	if err := header.Read(reader); err != nil {
+		t.Fatal(err)
+	}
+	return header, data[len(data)-reader.Len():]
+}
+
+// TestCircom initializes phase 2 from the circom R1CS of the test circuit
+// and compares it with the initialization from gnark R1CS
+func TestCircom(t *testing.T) {
+	wd := setupCircuit(t)
+
+	var myCircuit Circuit
+	ccs, err := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCircomR1CS(t, ccs.(*cs_bn254.R1CS), "circuit.circom.r1cs")
+
+	// Initialize in a separate directory to keep evals and srs.lag of the gnark R1CS
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := phase2.Initialize(filepath.Join(wd, "1.ph1"), filepath.Join(wd, "circuit.circom.r1cs"), "0.ph2", "", phase2.Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expHeader, expParams := splitHeader(t, filepath.Join(wd, "0.ph2"))
+	header, params := splitHeader(t, "0.ph2")
+	if header.Wires != expHeader.Wires || header.Witness != expHeader.Witness || header.Public != expHeader.Public ||
+		header.Constraints != expHeader.Constraints || header.Domain != expHeader.Domain {
+		t.Fatalf("circuit info mismatch %+v, expected %+v", header, expHeader)
+	}
+	if header.Label != "circuit.circom" {
+		t.Errorf("unexpected label %s", header.Label)
+	}
+	if !bytes.Equal(params, expParams) {
+		t.Error("phase 2 parameters mismatch")
+	}
+	for _, name := range []string{"evals", "srs.lag"} {
+		expected, err := os.ReadFile(filepath.Join(wd, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("%s mismatch", name)
+		}
+	}
+}
+
+// CommitmentCircuit commits to its private input, which the fork allows once per circuit
+type CommitmentCircuit struct {
+	X       frontend.Variable
+	Product frontend.Variable `gnark:",public"`
+}
+
+// Define declares the circuit's constraints
+// Product = X·X, with the commitment bound to X
+func (circuit *CommitmentCircuit) Define(api frontend.API) error {
+	c, err := api.Compiler().Commit(circuit.X)
+	if err != nil {
+		return err
+	}
+	api.AssertIsDifferent(c, 0)
+	api.AssertIsEqual(api.Mul(circuit.X, circuit.X), circuit.Product)
+	return nil
+}
+
+// TestGnarkCommitment initializes phase 2 for gnark R1CS with a commitment, which is read from the constraint system,
+// and checks the JSON of commitments is rejected for it
+func TestGnarkCommitment(t *testing.T) {
+	wd := setupCircuit(t)
+
+	var myCircuit CommitmentCircuit
+	ccs, err := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create("commitment.r1cs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ccs.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	r1csPath := filepath.Join(wd, "commitment.r1cs")
+	if err := phase2.Initialize(filepath.Join(wd, "1.ph1"), r1csPath, "0.ph2", "", phase2.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	header, _ := splitHeader(t, "0.ph2")
+	if header.PrivateCommitted != 1 || header.Public != ccs.GetNbPublicVariables()+1 {
+		t.Errorf("unexpected #PrivateCommitted %d and #Public %d", header.PrivateCommitted, header.Public)
+	}
+
+	commitments, err := json.Marshal(phase2.CommitmentsFromInfo(ccs.(*cs_bn254.R1CS).CommitmentInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("commitments.json", commitments, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Initialize(filepath.Join(wd, "1.ph1"), r1csPath, "0.ph2", "commitments.json", phase2.Options{}); err == nil {
+		t.Error("JSON of commitments is accepted for gnark R1CS")
+	}
+}
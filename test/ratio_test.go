@@ -0,0 +1,47 @@
+package test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/common"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+func TestRatioBatch(t *testing.T) {
+	_, _, g1, g2 := bn254.Generators()
+	random := func() *big.Int {
+		var x fr.Element
+		x.SetRandom()
+		return x.BigInt(new(big.Int))
+	}
+
+	// e([x]₁, [y]₂) = e([xy]₁, g₂), with the G2 operands shared among checks
+	var batch common.RatioBatch
+	errs := make([]error, 5)
+	for i := range errs {
+		x, y := random(), random()
+		var a1, b1 bn254.G1Affine
+		var a2 bn254.G2Affine
+		a1.ScalarMultiplication(&g1, x)
+		b1.ScalarMultiplication(&a1, y)
+		a2.ScalarMultiplication(&g2, y)
+		errs[i] = errors.New("check failed")
+		batch.Add(a1, b1, a2, g2, errs[i])
+	}
+	if err := batch.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing check is pinpointed
+	var bad bn254.G1Affine
+	bad.ScalarMultiplication(&g1, random())
+	wrong := errors.New("wrong check failed")
+	batch.Add(g1, bad, g2, g2, wrong)
+	batch.Add(g1, g1, g2, g2, errors.New("check failed"))
+	if err := batch.Verify(); err != wrong {
+		t.Errorf("expected the failing check to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/keys"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+type snarkJSVerifyingKey struct {
+	Protocol string     `json:"protocol"`
+	Curve    string     `json:"curve"`
+	NPublic  int        `json:"nPublic"`
+	Alpha1   []string   `json:"vk_alpha_1"`
+	Beta2    [][]string `json:"vk_beta_2"`
+	Gamma2   [][]string `json:"vk_gamma_2"`
+	Delta2   [][]string `json:"vk_delta_2"`
+	IC       [][]string `json:"IC"`
+}
+
+func parseG1(t *testing.T, c []string) bn254.G1Affine {
+	var p bn254.G1Affine
+	if _, err := p.X.SetString(c[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Y.SetString(c[1]); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func parseG2(t *testing.T, c [][]string) bn254.G2Affine {
+	var p bn254.G2Affine
+	p.X.SetString(c[0][0], c[0][1])
+	p.Y.SetString(c[1][0], c[1][1])
+	if !p.IsOnCurve() {
+		t.Fatal("point isn't on curve")
+	}
+	return p
+}
+
+// TestSnarkJS verifies a proof using the exported snarkjs verifying key, it depends on keys extracted by TestSetup
+func TestSnarkJS(t *testing.T) {
+	if err := keys.ExportSnarkJS("vk", "verification_key.json", keys.FormatFork); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("verification_key.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vk snarkJSVerifyingKey
+	if err := json.Unmarshal(data, &vk); err != nil {
+		t.Fatal(err)
+	}
+	if vk.Protocol != "groth16" || vk.Curve != "bn128" || vk.NPublic != 1 || len(vk.IC) != 2 {
+		t.Fatalf("unexpected verifying key %s %s %d %d", vk.Protocol, vk.Curve, vk.NPublic, len(vk.IC))
+	}
+
+	// Prove
+	var myCircuit Circuit
+	ccs, _ := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	pk := groth16.NewProvingKey(ecc.BN254)
+	pkFile, err := os.Open("pk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pkFile.Close()
+	pk.ReadFrom(pkFile)
+	assignment := &Circuit{
+		PreImage: "16130099170765464552823636852555369511329944820189892919423002775646948828469",
+		Hash:     "12886436712380113721405259596386800092738845035233065858332878701083870690753",
+	}
+	witness, _ := frontend.NewWitness(assignment, bn254.ID.ScalarField())
+	prf, err := groth16.Prove(ccs, pk, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode the proof as [A]₁, [B]₂, [C]₁
+	var buf bytes.Buffer
+	if _, err := prf.WriteRawTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var a, c bn254.G1Affine
+	var b bn254.G2Affine
+	dec := bn254.NewDecoder(&buf)
+	for _, v := range []interface{}{&a, &b, &c} {
+		if err := dec.Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// e(A, B) = e(α, β)·e(IC₀ + x·IC₁, γ)·e(C, δ)
+	var x fr.Element
+	x.SetString(assignment.Hash.(string))
+	xBI := x.BigInt(new(big.Int))
+	ic0, ic1 := parseG1(t, vk.IC[0]), parseG1(t, vk.IC[1])
+	var acc bn254.G1Affine
+	acc.ScalarMultiplication(&ic1, xBI)
+	acc.Add(&acc, &ic0)
+	var negA bn254.G1Affine
+	negA.Neg(&a)
+	ok, err := bn254.PairingCheck(
+		[]bn254.G1Affine{negA, parseG1(t, vk.Alpha1), acc, c},
+		[]bn254.G2Affine{b, parseG2(t, vk.Beta2), parseG2(t, vk.Gamma2), parseG2(t, vk.Delta2)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("proof doesn't verify with the snarkjs verifying key")
+	}
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/keys"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// TestSol exports the verifier contract from vk and from the phase 2 file
+func TestSol(t *testing.T) {
+	setupCircuit(t)
+
+	// The default options produce the contract of gnark
+	if err := keys.ExportSol("vk", "vk.sol", keys.SolOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	vk := groth16.NewVerifyingKey(ecc.BN254)
+	vkFile, err := os.Open("vk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vkFile.Close()
+	if _, err := vk.ReadFrom(vkFile); err != nil {
+		t.Fatal(err)
+	}
+	var expected bytes.Buffer
+	if err := vk.ExportSolidity(&expected); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := os.ReadFile("vk.sol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exported, expected.Bytes()) {
+		t.Error("contract with default options differs from the one of gnark")
+	}
+
+	// Sample proof for the Foundry test
+	var myCircuit Circuit
+	ccs, _ := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	pk := groth16.NewProvingKey(ecc.BN254)
+	pkFile, err := os.Open("pk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pkFile.Close()
+	pk.ReadFrom(pkFile)
+	assignment := &Circuit{
+		PreImage: "16130099170765464552823636852555369511329944820189892919423002775646948828469",
+		Hash:     "12886436712380113721405259596386800092738845035233065858332878701083870690753",
+	}
+	witness, _ := frontend.NewWitness(assignment, bn254.ID.ScalarField())
+	pubWitness, _ := witness.Public()
+	prf, err := groth16.Prove(ccs, pk, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proofBuf, witnessBuf bytes.Buffer
+	if _, err := prf.WriteTo(&proofBuf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pubWitness.WriteTo(&witnessBuf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("proof", proofBuf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("public.wtns", witnessBuf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The contract built from the phase 2 file has the same verifying key
+	if err := os.MkdirAll("foundry", 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := keys.SolOptions{
+		Name:        "MimcVerifier",
+		Pragma:      "0.8.19",
+		LinkLibrary: true,
+		TestPath:    "foundry/MimcVerifier.t.sol",
+		ProofPath:   "proof",
+		WitnessPath: "public.wtns",
+	}
+	if err := keys.ExportSol("1.ph2", "MimcVerifier.sol", opts); err != nil {
+		t.Fatal(err)
+	}
+	exported, err = os.ReadFile("MimcVerifier.sol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replacer := strings.NewReplacer(
+		"contract Verifier {", "contract MimcVerifier {",
+		"pragma solidity ^0.8.0;", "pragma solidity 0.8.19;",
+		"(G1Point memory p) internal pure", "(G1Point memory p) public pure",
+		") internal view returns (G1Point memory r) {", ") public view returns (G1Point memory r) {",
+		") internal view returns (bool) {", ") public view returns (bool) {",
+	)
+	if replacer.Replace(expected.String()) != string(exported) {
+		t.Error("contract exported from the phase 2 file differs from the one exported from vk")
+	}
+
+	// The Foundry test imports the contract and hardcodes the proof
+	test, err := os.ReadFile("foundry/MimcVerifier.t.sol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a bn254.G1Affine
+	if err := bn254.NewDecoder(bytes.NewReader(proofBuf.Bytes())).Decode(&a); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`import "../MimcVerifier.sol";`,
+		"contract MimcVerifierTest is Test {",
+		a.X.String(),
+		"uint256[1] input = [uint256(12886436712380113721405259596386800092738845035233065858332878701083870690753)];",
+	} {
+		if !strings.Contains(string(test), s) {
+			t.Errorf("Foundry test doesn't contain %s", s)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/common"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+// pointOutsideG2 returns a point of the twist which isn't in G2
+func pointOutsideG2(t *testing.T) bn254.G2Affine {
+	_, _, _, g2 := bn254.Generators()
+
+	// b of the twist is y² - x³ of the generator
+	b, x3 := g2.Y, g2.X
+	b.Square(&g2.Y)
+	x3.Square(&g2.X).Mul(&x3, &g2.X)
+	b.Sub(&b, &x3)
+
+	var p bn254.G2Affine
+	for {
+		p.X.SetRandom()
+		rhs := p.X
+		rhs.Square(&p.X).Mul(&rhs, &p.X).Add(&rhs, &b)
+		if rhs.Legendre() == 1 {
+			p.Y.Sqrt(&rhs)
+			break
+		}
+	}
+	if !p.IsOnCurve() || p.IsInSubGroup() {
+		t.Fatal("couldn't sample a point outside G2")
+	}
+	return p
+}
+
+func TestBatchSubgroupCheck(t *testing.T) {
+	_, _, g1, g2 := bn254.Generators()
+	scalars := make([]fr.Element, 1<<13)
+	for i := range scalars {
+		scalars[i].SetRandom()
+	}
+	pointsG1 := bn254.BatchScalarMultiplicationG1(&g1, scalars)
+	pointsG2 := bn254.BatchScalarMultiplicationG2(&g2, scalars)
+	if err := common.CheckG1(pointsG1); err != nil {
+		t.Error(err)
+	}
+	if err := common.CheckG2(pointsG2); err != nil {
+		t.Error(err)
+	}
+
+	// A point of the twist outside G2 is caught in a large batch and in a small one
+	bad := pointOutsideG2(t)
+	for _, size := range []int{len(pointsG2), 3} {
+		points := append([]bn254.G2Affine{}, pointsG2[:size]...)
+		points[size/2] = bad
+		if err := common.CheckG2(points); err == nil {
+			t.Errorf("a point outside G2 among %d points should fail the subgroup check", size)
+		}
+	}
+
+	// Points off the curve are caught
+	offCurveG1 := append([]bn254.G1Affine{}, pointsG1...)
+	offCurveG1[1].Y.Double(&offCurveG1[1].Y)
+	if err := common.CheckG1(offCurveG1); err == nil {
+		t.Error("a point off the curve should fail the G1 check")
+	}
+	offCurveG2 := append([]bn254.G2Affine{}, pointsG2...)
+	offCurveG2[1].Y.Double(&offCurveG2[1].Y)
+	if err := common.CheckG2(offCurveG2); err == nil {
+		t.Error("a point off the curve should fail the G2 check")
+	}
+}
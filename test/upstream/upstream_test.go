@@ -0,0 +1,229 @@
+// Package upstream checks that the keys of a ceremony load and prove with consensys/gnark v0.9.
+// It's a separate module since the setup depends on the bnb-chain fork of gnark, so it runs the setup through its CLI.
+package upstream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/hash/mimc"
+)
+
+// Circuit defines a pre-image knowledge proof
+// mimc(secret preImage) = public hash
+type Circuit struct {
+	PreImage frontend.Variable
+	Hash     frontend.Variable `gnark:",public"`
+}
+
+// Define declares the circuit's constraints
+// Hash = mimc(PreImage)
+func (circuit *Circuit) Define(api frontend.API) error {
+	mimc, _ := mimc.NewMiMC(api)
+	mimc.Write(circuit.PreImage)
+	api.AssertIsEqual(circuit.Hash, mimc.Sum())
+	return nil
+}
+
+// writeCircomR1CS writes ccs in circom R1CS binary format which the setup can read,
+// the public wires are written as public inputs
+func writeCircomR1CS(t *testing.T, ccs *cs_bn254.R1CS, path string) {
+	var header, constraints bytes.Buffer
+	nbWires := ccs.GetNbPublicVariables() + ccs.GetNbSecretVariables() + ccs.GetNbInternalVariables()
+	r1cs := ccs.GetR1Cs()
+
+	// Header
+	binary.Write(&header, binary.LittleEndian, uint32(fr.Bytes))
+	header.Write(littleEndian(fr.Modulus().Bytes()))
+	for _, v := range []uint32{uint32(nbWires), 0, uint32(ccs.GetNbPublicVariables() - 1), uint32(ccs.GetNbSecretVariables())} {
+		binary.Write(&header, binary.LittleEndian, v)
+	}
+	binary.Write(&header, binary.LittleEndian, uint64(nbWires))
+	binary.Write(&header, binary.LittleEndian, uint32(len(r1cs)))
+
+	// Constraints
+	for _, c := range r1cs {
+		for _, l := range []constraint.LinearExpression{c.L, c.R, c.O} {
+			binary.Write(&constraints, binary.LittleEndian, uint32(len(l)))
+			for _, term := range l {
+				binary.Write(&constraints, binary.LittleEndian, uint32(term.WireID()))
+				value := ccs.Coefficients[term.CoeffID()].Bytes()
+				constraints.Write(littleEndian(value[:]))
+			}
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	defer writer.Flush()
+	writer.WriteString("r1cs")
+	binary.Write(writer, binary.LittleEndian, uint32(1))
+	binary.Write(writer, binary.LittleEndian, uint32(2))
+	for i, section := range []*bytes.Buffer{&header, &constraints} {
+		binary.Write(writer, binary.LittleEndian, uint32(i+1))
+		binary.Write(writer, binary.LittleEndian, uint64(section.Len()))
+		writer.Write(section.Bytes())
+	}
+}
+
+// littleEndian pads b to 32 bytes and reverses it
+func littleEndian(b []byte) []byte {
+	res := make([]byte, fr.Bytes)
+	for i := range b {
+		res[i] = b[len(b)-1-i]
+	}
+	return res
+}
+
+// CommitmentCircuit commits twice, the second commitment committing to the first one
+type CommitmentCircuit struct {
+	X, Y    frontend.Variable
+	Product frontend.Variable `gnark:",public"`
+}
+
+// Define declares the circuit's constraints
+// Product = X·Y, with the commitments bound to X, Y, and Product
+func (circuit *CommitmentCircuit) Define(api frontend.API) error {
+	committer, ok := api.(frontend.Committer)
+	if !ok {
+		return errors.New("builder doesn't support commitments")
+	}
+	c1, err := committer.Commit(circuit.X, circuit.Product)
+	if err != nil {
+		return err
+	}
+	c2, err := committer.Commit(circuit.Y, c1)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(api.Mul(circuit.X, circuit.Y), circuit.Product)
+	api.AssertIsDifferent(api.Mul(c1, c2), 0)
+	return nil
+}
+
+// runCeremony runs a ceremony for ccs through the CLI of the setup with extra arguments of p2n,
+// then extracts pk, vk, Foo.pk and Foo.vk in the upstream layout to the returned directory
+func runCeremony(t *testing.T, ccs constraint.ConstraintSystem, p2nArgs ...string) string {
+	dir := t.TempDir()
+	writeCircomR1CS(t, ccs.(*cs_bn254.R1CS), filepath.Join(dir, "circuit.r1cs"))
+
+	// Build the setup and run a ceremony
+	setup := filepath.Join(dir, "zkbnb-setup")
+	build := exec.Command("go", "build", "-o", setup, ".")
+	build.Dir = filepath.Join("..", "..")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	steps := [][]string{
+		{"p1n", "--ceremony", "test", "9", "0.ph1"},
+		{"p1c", "0.ph1", "1.ph1"},
+		append(append([]string{"p2n"}, p2nArgs...), "1.ph1", "circuit.r1cs", "0.ph2"),
+		{"p2c", "--yes", "0.ph2", "1.ph2"},
+		{"key", "--format", "gnark-v0.9", "1.ph2"},
+		{"keys", "--format", "gnark-v0.9", "1.ph2", "Foo"},
+	}
+	for _, args := range steps {
+		cmd := exec.Command(setup, args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", args, err, out)
+		}
+	}
+	return dir
+}
+
+// proveAndVerify proves assignment with the keys of dir and verifies the proof
+func proveAndVerify(t *testing.T, ccs constraint.ConstraintSystem, dir string, assignment frontend.Circuit) {
+	witness, err := frontend.NewWitness(assignment, bn254.ID.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubWitness, err := witness.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keys := range [][2]string{{"pk", "vk"}, {"Foo.pk", "Foo.vk"}} {
+		pk := groth16.NewProvingKey(ecc.BN254)
+		vk := groth16.NewVerifyingKey(ecc.BN254)
+		pkFile, err := os.Open(filepath.Join(dir, keys[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pkFile.Close()
+		if _, err := pk.ReadFrom(bufio.NewReader(pkFile)); err != nil {
+			t.Fatal(err)
+		}
+		vkFile, err := os.Open(filepath.Join(dir, keys[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer vkFile.Close()
+		if _, err := vk.ReadFrom(bufio.NewReader(vkFile)); err != nil {
+			t.Fatal(err)
+		}
+
+		proof, err := groth16.Prove(ccs, pk, witness)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := groth16.Verify(proof, vk, pubWitness); err != nil {
+			t.Errorf("%s: %v", keys[0], err)
+		}
+	}
+}
+
+func TestUpstreamKeys(t *testing.T) {
+	var myCircuit Circuit
+	ccs, err := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := runCeremony(t, ccs)
+	proveAndVerify(t, ccs, dir, &Circuit{
+		PreImage: "16130099170765464552823636852555369511329944820189892919423002775646948828469",
+		Hash:     "12886436712380113721405259596386800092738845035233065858332878701083870690753",
+	})
+}
+
+func TestUpstreamCommitments(t *testing.T) {
+	var myCircuit CommitmentCircuit
+	ccs, err := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitments := ccs.(*cs_bn254.R1CS).CommitmentInfo.(constraint.Groth16Commitments)
+	if len(commitments) != 2 {
+		t.Fatalf("expected 2 commitments, got %d", len(commitments))
+	}
+
+	// The commitments are passed next to the circom R1CS which can't hold them
+	data, err := json.Marshal(commitments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitmentsPath := filepath.Join(t.TempDir(), "commitments.json")
+	if err := os.WriteFile(commitmentsPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := runCeremony(t, ccs, "--commitments", commitmentsPath)
+	proveAndVerify(t, ccs, dir, &CommitmentCircuit{X: 3, Y: 5, Product: 15})
+}
@@ -0,0 +1,260 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/bnb-chain/zkbnb-setup/keys"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+func readZKey(t *testing.T, path string) map[uint32]*bytes.Reader {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != "zkey" {
+		t.Fatal("invalid zkey magic")
+	}
+	reader := bytes.NewReader(data[4:])
+	var version, nbSections uint32
+	binary.Read(reader, binary.LittleEndian, &version)
+	binary.Read(reader, binary.LittleEndian, &nbSections)
+	sections := make(map[uint32]*bytes.Reader)
+	for i := uint32(0); i < nbSections; i++ {
+		var sectionType uint32
+		var size uint64
+		binary.Read(reader, binary.LittleEndian, &sectionType)
+		binary.Read(reader, binary.LittleEndian, &size)
+		buf := make([]byte, size)
+		if _, err := reader.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		sections[sectionType] = bytes.NewReader(buf)
+	}
+	return sections
+}
+
+func readZKeyG1(t *testing.T, reader *bytes.Reader, n int) []bn254.G1Affine {
+	points := make([]bn254.G1Affine, n)
+	for i := range points {
+		var coordinates [2]fp.Element
+		if err := binary.Read(reader, binary.LittleEndian, &coordinates); err != nil {
+			t.Fatal(err)
+		}
+		points[i].X, points[i].Y = coordinates[0], coordinates[1]
+		if !points[i].IsInfinity() && !points[i].IsOnCurve() {
+			t.Fatal("point isn't on curve")
+		}
+	}
+	return points
+}
+
+func readZKeyG2(t *testing.T, reader *bytes.Reader, n int) []bn254.G2Affine {
+	points := make([]bn254.G2Affine, n)
+	for i := range points {
+		var coordinates [4]fp.Element
+		if err := binary.Read(reader, binary.LittleEndian, &coordinates); err != nil {
+			t.Fatal(err)
+		}
+		points[i].X.A0, points[i].X.A1 = coordinates[0], coordinates[1]
+		points[i].Y.A0, points[i].Y.A1 = coordinates[2], coordinates[3]
+		if !points[i].IsInfinity() && !points[i].IsOnCurve() {
+			t.Fatal("point isn't on curve")
+		}
+	}
+	return points
+}
+
+// TestZKey proves the way snarkjs does with the exported zkey, then verifies the proof with the zkey verifying key
+func TestZKey(t *testing.T) {
+	setupCircuit(t)
+	if err := keys.ExportZKey("1.ph2", "evals", "circuit.r1cs", "circuit.zkey"); err != nil {
+		t.Fatal(err)
+	}
+	sections := readZKey(t, "circuit.zkey")
+
+	// The contributions of phase 2 can't be verified by snarkjs, so there is no MPC section
+	if _, ok := sections[10]; ok {
+		t.Error("zkey shouldn't have an MPC section")
+	}
+
+	// Evaluations whose sizes don't match the phase 2 header are rejected
+	evals, err := os.ReadFile("evals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint32(evals[128:], math.MaxUint32)
+	if err := os.WriteFile("mismatched.evals", evals, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.ExportZKey("1.ph2", "mismatched.evals", "circuit.r1cs", "mismatched.zkey"); err == nil {
+		t.Error("evaluations of another circuit should be rejected")
+	}
+
+	// Groth16 header
+	header := sections[2]
+	var n8q uint32
+	binary.Read(header, binary.LittleEndian, &n8q)
+	header.Seek(int64(n8q), 1)
+	var n8r uint32
+	binary.Read(header, binary.LittleEndian, &n8r)
+	header.Seek(int64(n8r), 1)
+	var nVars, nPublic, domainSize uint32
+	binary.Read(header, binary.LittleEndian, &nVars)
+	binary.Read(header, binary.LittleEndian, &nPublic)
+	binary.Read(header, binary.LittleEndian, &domainSize)
+	g1 := readZKeyG1(t, header, 2)
+	g2 := readZKeyG2(t, header, 2)
+	readZKeyG1(t, header, 1) // [δ]₁
+	delta2 := readZKeyG2(t, header, 1)[0]
+	alpha1, beta2, gamma2 := g1[0], g2[0], g2[1]
+	if nPublic != 1 {
+		t.Fatalf("expected 1 public input, got %d", nPublic)
+	}
+
+	IC := readZKeyG1(t, sections[3], int(nPublic)+1)
+	A := readZKeyG1(t, sections[5], int(nVars))
+	B2 := readZKeyG2(t, sections[7], int(nVars))
+	C := readZKeyG1(t, sections[8], int(nVars-nPublic-1))
+	H := readZKeyG1(t, sections[9], int(domainSize))
+
+	// Solve the circuit to get all wires
+	var myCircuit Circuit
+	ccs, _ := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	assignment := &Circuit{
+		PreImage: "16130099170765464552823636852555369511329944820189892919423002775646948828469",
+		Hash:     "12886436712380113721405259596386800092738845035233065858332878701083870690753",
+	}
+	witness, _ := frontend.NewWitness(assignment, bn254.ID.ScalarField())
+	opt, _ := backend.NewProverConfig()
+	nbCons := ccs.GetNbConstraints()
+	wires, err := ccs.(*cs_bn254.R1CS).Solve(witness.Vector().(fr.Vector), make(fr.Vector, nbCons), make(fr.Vector, nbCons), make(fr.Vector, nbCons), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Evaluate A and B on the domain using the coefficients, C = A∘B
+	var R2Inv fr.Element
+	R2Inv.SetBigInt(new(big.Int).Lsh(big.NewInt(1), 512)).Inverse(&R2Inv)
+	a := make([]fr.Element, domainSize)
+	b := make([]fr.Element, domainSize)
+	c := make([]fr.Element, domainSize)
+	coefficients := sections[4]
+	var nbCoefficients uint32
+	binary.Read(coefficients, binary.LittleEndian, &nbCoefficients)
+	for i := uint32(0); i < nbCoefficients; i++ {
+		var entry [3]uint32
+		var value [32]byte
+		binary.Read(coefficients, binary.LittleEndian, &entry)
+		coefficients.Read(value[:])
+		for j, k := 0, 31; j < k; j, k = j+1, k-1 {
+			value[j], value[k] = value[k], value[j]
+		}
+		var coefficient fr.Element
+		coefficient.SetBytes(value[:])
+		coefficient.Mul(&coefficient, &R2Inv)
+		coefficient.Mul(&coefficient, &wires[entry[2]])
+		if entry[0] == 0 {
+			a[entry[1]].Add(&a[entry[1]], &coefficient)
+		} else {
+			b[entry[1]].Add(&b[entry[1]], &coefficient)
+		}
+	}
+	for i := range c {
+		c[i].Mul(&a[i], &b[i])
+	}
+
+	// Evaluate A·B-C on the odd powers of ω₂ₙ
+	domain := fft.NewDomain(uint64(domainSize))
+	shift := fft.NewDomain(uint64(2 * domainSize)).Generator
+	for _, p := range [][]fr.Element{a, b, c} {
+		domain.FFTInverse(p, fft.DIF)
+		fft.BitReverse(p)
+		var s fr.Element
+		s.SetOne()
+		for i := range p {
+			p[i].Mul(&p[i], &s)
+			s.Mul(&s, &shift)
+		}
+		domain.FFT(p, fft.DIF)
+		fft.BitReverse(p)
+	}
+	for i := range a {
+		a[i].Mul(&a[i], &b[i]).Sub(&a[i], &c[i])
+	}
+
+	// [A]₁ = [α]₁ + ∑ wᵢ[Aᵢ]₁, [B]₂ = [β]₂ + ∑ wᵢ[Bᵢ]₂, [C]₁ = ∑ wᵢ[Cᵢ]₁ + ∑ hᵢ[Hᵢ]₁
+	var proofA, proofC, tmp bn254.G1Affine
+	var proofB bn254.G2Affine
+	config := ecc.MultiExpConfig{}
+	proofA.MultiExp(A, wires, config)
+	proofA.Add(&proofA, &alpha1)
+	proofB.MultiExp(B2, wires, config)
+	proofB.Add(&proofB, &beta2)
+	proofC.MultiExp(C, wires[nPublic+1:], config)
+	tmp.MultiExp(H, a, config)
+	proofC.Add(&proofC, &tmp)
+
+	// e(A, B) = e(α, β)·e(∑ wᵢ·ICᵢ, γ)·e(C, δ)
+	var acc bn254.G1Affine
+	acc.MultiExp(IC, wires[:nPublic+1], config)
+	proofA.Neg(&proofA)
+	ok, err := bn254.PairingCheck(
+		[]bn254.G1Affine{proofA, alpha1, acc, proofC},
+		[]bn254.G2Affine{proofB, beta2, gamma2, delta2},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("proof computed from the zkey doesn't verify")
+	}
+
+	// A proof of gnark verifies with the zkey verifying key as well
+	pk := groth16.NewProvingKey(ecc.BN254)
+	pkFile, err := os.Open("pk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pkFile.Close()
+	pk.ReadFrom(pkFile)
+	prf, err := groth16.Prove(ccs, pk, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := prf.WriteRawTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dec := bn254.NewDecoder(&buf)
+	for _, v := range []interface{}{&proofA, &proofB, &proofC} {
+		if err := dec.Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	proofA.Neg(&proofA)
+	ok, err = bn254.PairingCheck(
+		[]bn254.G1Affine{proofA, alpha1, acc, proofC},
+		[]bn254.G2Affine{proofB, beta2, gamma2, delta2},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("proof of gnark doesn't verify with the zkey verifying key")
+	}
+}